@@ -0,0 +1,43 @@
+package rtmp_bot
+
+import (
+	"github.com/instrumentisto/go-rtmp-bot/controller"
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/transport"
+)
+
+// Listener of RTMP client events.
+// Handles client events in event bus shard goroutine and passes
+// only events which change test state to launcher.
+type clientListener struct {
+	client    IRTMPClient            // RTMP client.
+	control   *controller.AppHandler // Launcher signals handler.
+	stop_chan chan bool              // Launcher stop channel.
+}
+
+// Handles RTMP client event.
+//
+// param: signal *model.Signal   Client event.
+func (c *clientListener) OnEvent(signal *model.Signal) {
+	switch signal.SignalType {
+	case model.STATUS:
+		c.client.SetStatus(signal.Data.(uint))
+	case model.STREAM_CREATE:
+		c.client.SetStream(signal.Data.(transport.Stream))
+	case model.PUBLISH_START:
+		c.client.PublishStream(signal.Data.(transport.Stream))
+		// Launcher starts players of published stream.
+		select {
+		case c.control.Signal_chan <- signal:
+		case <-c.stop_chan:
+		}
+	case model.CLOSED:
+		// Launcher stops starting players of stream with closed publisher.
+		select {
+		case c.control.Signal_chan <- signal:
+		case <-c.stop_chan:
+		}
+	case model.PLAY_STREAM:
+		c.client.PlayStream(signal.Data.(*transport.Message))
+	}
+}
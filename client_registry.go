@@ -0,0 +1,65 @@
+package rtmp_bot
+
+import "sync"
+
+// Concurrent registry of RTMP clients.
+// Clients are added and removed by the test loop while statistics
+// loop and Stop read them.
+type clientRegistry struct {
+	mutex   sync.RWMutex
+	clients map[string]IRTMPClient // Map of RTMP clients by ID.
+}
+
+// Constructs new empty clients registry.
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{
+		clients: make(map[string]IRTMPClient),
+	}
+}
+
+// Adds RTMP client to registry.
+//
+// param: client IRTMPClient   RTMP client.
+func (r *clientRegistry) Add(client IRTMPClient) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.clients[client.GetID()] = client
+}
+
+// Removes RTMP client from registry.
+//
+// param: id string   RTMP client ID.
+func (r *clientRegistry) Remove(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.clients, id)
+}
+
+// Returns RTMP client by ID.
+//
+// param: id string   RTMP client ID.
+func (r *clientRegistry) Get(id string) (IRTMPClient, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	client, ok := r.clients[id]
+	return client, ok
+}
+
+// Returns snapshot of registered RTMP clients.
+// The snapshot may be iterated while registry is changed.
+func (r *clientRegistry) List() []IRTMPClient {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	clients := make([]IRTMPClient, 0, len(r.clients))
+	for _, client := range r.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Removes all RTMP clients from registry.
+func (r *clientRegistry) Clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.clients = make(map[string]IRTMPClient)
+}
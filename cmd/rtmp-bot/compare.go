@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/instrumentisto/go-rtmp-bot/results"
+)
+
+// Command line flag of per metric thresholds in "metric=percent" format.
+type metricThresholds map[string]float64
+
+// Returns flag value as string.
+func (m metricThresholds) String() string {
+	var thresholds []string
+	for name, max_change := range m {
+		thresholds = append(thresholds,
+			name+"="+strconv.FormatFloat(max_change, 'f', -1, 64))
+	}
+	return strings.Join(thresholds, ",")
+}
+
+// Parses metric threshold.
+//
+// param: value string   Threshold in "metric=percent" format.
+func (m metricThresholds) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("threshold %q is not in metric=percent format", value)
+	}
+	max_change, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return err
+	}
+	m[parts[0]] = max_change
+	return nil
+}
+
+// Compares two saved test reports and prints metrics differences.
+// Returns non-zero exit code if any regression is detected.
+//
+// param: args []string   Command arguments.
+func runCompare(args []string) int {
+	thresholds := results.DefaultThresholds()
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr,
+			"Usage: rtmp-bot compare [flags] baseline.json current.json")
+		flags.PrintDefaults()
+	}
+	flags.Float64Var(&thresholds.MaxChange, "max_change",
+		results.DEFAULT_MAX_CHANGE, "Allowed metric worsening in percent")
+	flags.Float64Var(&thresholds.Significance, "significance",
+		results.DEFAULT_SIGNIFICANCE, "Significance level of metric change")
+	flags.Var(metricThresholds(thresholds.Metrics), "threshold",
+		"Allowed worsening of single metric as metric=percent, repeatable")
+	as_json := flags.Bool("json", false, "Print comparison as JSON")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return EXIT_ERROR
+	}
+	baseline, err := results.LoadJSON(flags.Arg(0))
+	if err != nil {
+		log.Printf("Load baseline report ERROR: %s", err.Error())
+		return EXIT_ERROR
+	}
+	current, err := results.LoadJSON(flags.Arg(1))
+	if err != nil {
+		log.Printf("Load current report ERROR: %s", err.Error())
+		return EXIT_ERROR
+	}
+	comparison := results.Compare(baseline, current, thresholds)
+	if *as_json {
+		jsn, err := json.MarshalIndent(comparison, "", "  ")
+		if err != nil {
+			log.Printf("Marshal comparison ERROR: %s", err.Error())
+			return EXIT_ERROR
+		}
+		fmt.Println(string(jsn))
+	} else {
+		results.WriteComparison(os.Stdout, comparison)
+	}
+	regressions := comparison.Regressions()
+	for _, metric := range regressions {
+		log.Printf("REGRESSION: %s changed by %+.2f%%",
+			metric.Name, metric.Change)
+	}
+	if len(regressions) > 0 {
+		return EXIT_FAILED
+	}
+	return EXIT_OK
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/instrumentisto/go-rtmp-bot"
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/publisher"
+	"github.com/instrumentisto/go-rtmp-bot/results"
+	"github.com/instrumentisto/go-rtmp-bot/transport"
+	"github.com/instrumentisto/go-rtmp-bot/utils"
+)
+
+// Exit codes.
+const (
+	EXIT_OK     = 0 // Test passed.
+	EXIT_FAILED = 1 // Test report assertions failed.
+	EXIT_ERROR  = 2 // Test can not be run.
+)
+
+var (
+	rtmp_url = flag.String("rtmp_url", "",
+		"RTMP Server application URL")
+	flvPath      = flag.String("flv_file", "", "Test flv file path or synthetic media source")
+	scenarioPath = flag.String("scenario", "", "Test scenario file path")
+	server       = flag.String("server", "stress_test", "Media server name")
+	model_count  = flag.Int("model_count", 1, "Count of model bots")
+	client_count = flag.Int("client_count", 1, "Count of client bots per model")
+	profile      = flag.String("profile", model.PROFILE_SPIKE,
+		"Load profile: spike, linear or step")
+	ramp_up    = flag.Int("ramp_up", 0, "Ramp-up duration in seconds")
+	step_count = flag.Int("step_count", 0, "Count of ramp-up steps")
+	plateau    = flag.Int("plateau", 0, "Plateau duration in seconds")
+	ramp_down  = flag.Int("ramp_down", 0, "Ramp-down duration in seconds")
+	duration   = flag.Duration("duration", 0,
+		"Test duration, defaults to scenario, sweep or load profile duration")
+	min_model_fps = flag.Int64("min_model_fps", -1,
+		"Minimal average model FPS, -1 disables the assertion")
+	min_client_fps = flag.Int64("min_client_fps", -1,
+		"Minimal average client FPS, -1 disables the assertion")
+	max_model_lag = flag.Int64("max_model_lag", -1,
+		"Maximal count of not connected models, -1 disables the assertion")
+	max_client_lag = flag.Int64("max_client_lag", -1,
+		"Maximal count of not connected clients, -1 disables the assertion")
+	max_startup_time = flag.Int64("max_startup_time", -1,
+		"Maximal average client startup time in ms, -1 disables the assertion")
+	rtmp_transport = flag.String("transport", transport.DEFAULT_TRANSPORT,
+		"RTMP client implementation")
+	frame_queue_size = flag.Int("frame_queue_size",
+		publisher.DEFAULT_FRAME_QUEUE_SIZE, "Capacity of publisher frame queue")
+	overflow_policy = flag.String("overflow_policy", publisher.OVERFLOW_DROP,
+		"Policy of full publisher frame queue: drop, block or disconnect")
+	flv_files = flag.String("flv_files", "",
+		"Comma separated test flv files or globs, defaults to flv_file")
+	independent_sources = flag.Bool("independent_sources", false,
+		"Each publisher reads its own copy of test flv file")
+	random_offset = flag.Bool("random_offset", false,
+		"Publishers start from random key frame of own test flv file")
+	stall_threshold = flag.Int("stall_threshold", model.DEFAULT_STALL_THRESHOLD,
+		"Video gap or lag of player considered as stall in ms")
+	verify_integrity = flag.Bool("verify_integrity", false,
+		"Publishers tag frames, so players verify loss, order and checksums")
+	sweep = flag.String("sweep", "",
+		"Swept parameter of synthetic media: bitrate or fps, empty disables sweep")
+	sweep_values = flag.String("sweep_values", "",
+		"Comma separated swept parameter values, e.g. 500k,1m,2m")
+	sweep_step = flag.Int("sweep_step", 0,
+		"Duration of each sweep step in seconds")
+	pacing = flag.String("pacing", model.PACING_REALTIME,
+		"Pacing of test media: realtime, fast or speed")
+	pacing_speed = flag.Float64("pacing_speed", 1,
+		"Speed factor of realtime in speed pacing mode")
+	pacing_lead = flag.Int("pacing_lead", model.DEFAULT_PACING_LEAD,
+		"Allowed lead of frames sending schedule in ms")
+	pacing_lag = flag.Int("pacing_lag", model.DEFAULT_PACING_LAG,
+		"Lag of frames sending schedule caught up by burst in ms")
+	reconnect_attempts = flag.Int("reconnect_attempts", 0,
+		"Max consecutive reconnect attempts, 0 disables, -1 is unlimited")
+	reconnect_backoff = flag.Int("reconnect_backoff",
+		model.DEFAULT_RECONNECT_BACKOFF, "Backoff before the first reconnect in ms")
+	reconnect_max_backoff = flag.Int("reconnect_max_backoff",
+		model.DEFAULT_RECONNECT_MAX_BACKOFF, "Maximal reconnect backoff in ms")
+	reconnect_jitter = flag.Float64("reconnect_jitter", 0.2,
+		"Random part of reconnect backoff from 0 to 1")
+	tls_ca = flag.String("tls_ca", "",
+		"PEM CA bundle file to verify RTMPS server certificate")
+	tls_cert = flag.String("tls_cert", "",
+		"PEM client certificate file of RTMPS connections")
+	tls_key = flag.String("tls_key", "",
+		"PEM client private key file of RTMPS connections")
+	tls_server_name = flag.String("tls_server_name", "",
+		"SNI server name of RTMPS connections, defaults to URL host")
+	tls_insecure = flag.Bool("tls_insecure", false,
+		"Skip RTMPS server certificate verification")
+	report_dir = flag.String("report_dir", "",
+		"Directory to save final test report files, empty disables saving")
+)
+
+// Runs single stress test for fixed duration and prints final test report.
+// Exits with non-zero code if any report assertion fails.
+// With "compare" command compares two saved test reports instead.
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(runCompare(os.Args[2:]))
+	}
+	flag.Parse()
+	os.Exit(run())
+}
+
+// Runs stress test and returns exit code.
+func run() int {
+	start_request := &model.StartRequest{
+		ServerURL:       *rtmp_url,
+		ModelCount:      *model_count,
+		ClientCount:     *client_count,
+		ScenarioFile:    *scenarioPath,
+		Transport:       *rtmp_transport,
+		FrameQueueSize:  *frame_queue_size,
+		OverflowPolicy:  *overflow_policy,
+		FlvFiles:        *flv_files,
+		Independent:     *independent_sources,
+		RandomOffset:    *random_offset,
+		StallThreshold:  *stall_threshold,
+		VerifyIntegrity: *verify_integrity,
+		LoadProfile: model.LoadProfile{
+			ProfileType:  *profile,
+			RampUpTime:   *ramp_up,
+			StepCount:    *step_count,
+			PlateauTime:  *plateau,
+			RampDownTime: *ramp_down,
+		},
+		TLSOptions: model.TLSOptions{
+			TLSCAFile:             *tls_ca,
+			TLSCertFile:           *tls_cert,
+			TLSKeyFile:            *tls_key,
+			TLSServerName:         *tls_server_name,
+			TLSInsecureSkipVerify: *tls_insecure,
+		},
+		ReconnectPolicy: model.ReconnectPolicy{
+			ReconnectAttempts:   *reconnect_attempts,
+			ReconnectBackoff:    *reconnect_backoff,
+			ReconnectMaxBackoff: *reconnect_max_backoff,
+			ReconnectJitter:     *reconnect_jitter,
+		},
+		Sweep: model.Sweep{
+			SweepParameter: *sweep,
+			SweepValues:    *sweep_values,
+			SweepStepTime:  *sweep_step,
+		},
+		Pacing: model.Pacing{
+			PacingMode:  *pacing,
+			PacingSpeed: *pacing_speed,
+			PacingLead:  *pacing_lead,
+			PacingLag:   *pacing_lag,
+		},
+	}
+	err := start_request.LoadScenario()
+	if err != nil {
+		log.Printf("Load scenario ERROR: %s", err.Error())
+		return EXIT_ERROR
+	}
+	if start_request.ServerURL == "" {
+		log.Print("RTMP server URL not specified!")
+		return EXIT_ERROR
+	}
+	assertions := &model.Assertions{}
+	test_duration := *duration
+	if start_request.Scenario != nil {
+		assertions.Merge(&start_request.Scenario.Assertions)
+		if test_duration == 0 {
+			test_duration = start_request.Scenario.Duration()
+		}
+	} else if test_duration == 0 && start_request.Sweep.Enabled() {
+		test_duration = start_request.Sweep.Duration()
+	} else if test_duration == 0 {
+		test_duration = start_request.LoadProfile.Duration()
+	}
+	if test_duration == 0 {
+		log.Print("test duration not specified!")
+		return EXIT_ERROR
+	}
+	assertions.Merge(flagAssertions())
+
+	report := model.NewReport(*server)
+	report.ResetReport(
+		utils.GetUUID(), start_request.ModelCount, start_request.ClientCount)
+	test_launcher := rtmp_bot.NewLauncher(start_request, report, *flvPath)
+	launched := make(chan error, 1)
+	go func() {
+		launched <- test_launcher.Start()
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-time.After(test_duration):
+	case <-interrupt:
+		log.Print("test interrupted")
+	case err := <-launched:
+		if err != nil {
+			log.Printf("Start test ERROR: %s", err.Error())
+			return EXIT_ERROR
+		}
+	}
+	final_report := report.Snapshot()
+	result := test_launcher.Result()
+	test_launcher.Stop()
+	if *report_dir != "" {
+		paths, err := results.Save(result, *report_dir)
+		if err != nil {
+			log.Printf("Save report ERROR: %s", err.Error())
+			return EXIT_ERROR
+		}
+		log.Printf("Report saved: %v", paths)
+	}
+
+	jsn, err := json.MarshalIndent(final_report, "", "  ")
+	if err != nil {
+		log.Printf("Marshal report ERROR: %s", err.Error())
+		return EXIT_ERROR
+	}
+	fmt.Println(string(jsn))
+	failures := assertions.Check(final_report)
+	for _, failure := range failures {
+		log.Printf("ASSERTION FAILED: %s", failure)
+	}
+	if len(failures) > 0 {
+		return EXIT_FAILED
+	}
+	return EXIT_OK
+}
+
+// Returns assertions requested by command line flags.
+func flagAssertions() *model.Assertions {
+	assertions := &model.Assertions{}
+	if *min_model_fps >= 0 {
+		assertions.MinModelFPS = min_model_fps
+	}
+	if *min_client_fps >= 0 {
+		assertions.MinClientFPS = min_client_fps
+	}
+	if *max_model_lag >= 0 {
+		assertions.MaxModelLag = max_model_lag
+	}
+	if *max_client_lag >= 0 {
+		assertions.MaxClientLag = max_client_lag
+	}
+	if *max_startup_time >= 0 {
+		assertions.MaxStartUpTime = max_startup_time
+	}
+	return assertions
+}
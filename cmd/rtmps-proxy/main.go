@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+var (
+	listen = flag.String("listen", ":1936", "Address to listen RTMPS on")
+	target = flag.String("target", "localhost:1935",
+		"Address of plain RTMP server")
+	cert_file = flag.String("cert", "", "PEM server certificate file")
+	key_file  = flag.String("key", "", "PEM server private key file")
+	hosts     = flag.String("hosts", "localhost,127.0.0.1",
+		"Comma separated host names of generated self-signed certificate")
+	ca_out = flag.String("ca_out", "",
+		"File to write generated self-signed certificate to, "+
+			"use it as rtmp-bot -tls_ca")
+)
+
+// Terminates TLS of RTMPS connections and proxies them to plain RTMP server.
+// Allows stress testing of RTMPS path with any RTMP media server.
+// Generates self-signed certificate if no certificate is specified.
+func main() {
+	flag.Parse()
+	cert, err := loadCertificate()
+	if err != nil {
+		log.Fatalf("Certificate ERROR: %s", err.Error())
+	}
+	listener, err := tls.Listen("tcp", *listen, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		log.Fatalf("Listen ERROR: %s", err.Error())
+	}
+	log.Printf("proxy RTMPS %s to RTMP %s", *listen, *target)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("Accept ERROR: %s", err.Error())
+			continue
+		}
+		go proxy(conn)
+	}
+}
+
+// Proxies client connection to RTMP server.
+//
+// param: conn net.Conn   Client TLS connection.
+func proxy(conn net.Conn) {
+	defer conn.Close()
+	upstream, err := net.DialTimeout("tcp", *target, 10*time.Second)
+	if err != nil {
+		log.Printf("Dial RTMP server ERROR: %s", err.Error())
+		return
+	}
+	defer upstream.Close()
+	done := make(chan bool, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		done <- true
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- true
+	}()
+	<-done
+}
+
+// Returns requested certificate or generates self-signed one.
+func loadCertificate() (tls.Certificate, error) {
+	if *cert_file != "" || *key_file != "" {
+		return tls.LoadX509KeyPair(*cert_file, *key_file)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "rtmps-proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature |
+			x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range strings.Split(*hosts, ",") {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if *ca_out != "" {
+		cert_pem := pem.EncodeToMemory(
+			&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		if err = ioutil.WriteFile(*ca_out, cert_pem, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
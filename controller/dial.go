@@ -0,0 +1,142 @@
+package controller
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/transport"
+)
+
+// RTMP dial constants.
+const (
+	DEFAULT_RTMP_PORT  = "1935"           // Default RTMP server port.
+	DEFAULT_RTMPS_PORT = "443"            // Default RTMPS server port.
+	DIAL_TIMEOUT       = 10 * time.Second // TCP connection timeout.
+)
+
+// Scheme of RTMP over TLS URLs.
+const RTMPS_SCHEME = "rtmps"
+
+// Dialer of RTMP connections.
+// Establishes TCP or TLS connection and passes it to RTMP transport.
+type Dialer struct {
+	Transport transport.Transport // RTMP client implementation.
+	TLSConfig *tls.Config         // TLS configuration of RTMPS connections.
+}
+
+// Returns new dialer of requested transport.
+//
+// params: transport_name string              RTMP transport name.
+//         tls_options    *model.TLSOptions   TLS options of test request.
+func NewDialer(transport_name string,
+	tls_options *model.TLSOptions) (*Dialer, error) {
+	t, err := transport.Get(transport_name)
+	if err != nil {
+		return nil, err
+	}
+	tls_config, err := NewTLSConfig(tls_options)
+	if err != nil {
+		return nil, err
+	}
+	return &Dialer{Transport: t, TLSConfig: tls_config}, nil
+}
+
+// Dials RTMP server and makes RTMP handshake.
+// Connection of "rtmps://" URL is wrapped into TLS.
+// Marks dial and handshake phases in the handler timeline.
+//
+// params: server_url string         RTMP server URL.
+//         handler    *RTMPHandler   RTMP connection handler.
+// returns: RTMP connection or error.
+func (d *Dialer) Dial(
+	server_url string, handler *RTMPHandler) (transport.Conn, error) {
+	u, err := url.Parse(server_url)
+	if err != nil {
+		return nil, err
+	}
+	secure := u.Scheme == RTMPS_SCHEME
+	c, err := net.DialTimeout("tcp", serverAddress(u), DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	handler.Timeline.Mark(model.PHASE_DIAL)
+	if secure {
+		c, err = handshakeTLS(c, u.Hostname(), d.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		handler.Timeline.Mark(model.PHASE_TLS_HANDSHAKE)
+	}
+	conn, err := d.Transport.NewConn(c, server_url, handler)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	handler.Timeline.Mark(model.PHASE_HANDSHAKE)
+	return conn, nil
+}
+
+// Checks that RTMP server is reachable.
+// Establishes TCP or TLS connection without RTMP handshake and closes it.
+//
+// param: server_url string   RTMP server URL.
+func (d *Dialer) Check(server_url string) error {
+	u, err := url.Parse(server_url)
+	if err != nil {
+		return err
+	}
+	c, err := net.DialTimeout("tcp", serverAddress(u), DIAL_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == RTMPS_SCHEME {
+		c, err = handshakeTLS(c, u.Hostname(), d.TLSConfig)
+		if err != nil {
+			return err
+		}
+	}
+	return c.Close()
+}
+
+// Returns TCP address of RTMP server with default port of URL scheme
+// if the port is not specified.
+//
+// param: u *url.URL   RTMP server URL.
+func serverAddress(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := DEFAULT_RTMP_PORT
+	if u.Scheme == RTMPS_SCHEME {
+		port = DEFAULT_RTMPS_PORT
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Makes TLS handshake over TCP connection.
+// Closes the connection if the handshake fails.
+//
+// params: c          net.Conn      TCP connection.
+//         host_name  string        Server host name used if SNI is not set.
+//         tls_config *tls.Config   TLS configuration.
+func handshakeTLS(
+	c net.Conn, host_name string, tls_config *tls.Config) (net.Conn, error) {
+	config := &tls.Config{}
+	if tls_config != nil {
+		config = tls_config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = host_name
+	}
+	tls_conn := tls.Client(c, config)
+	tls_conn.SetDeadline(time.Now().Add(DIAL_TIMEOUT))
+	if err := tls_conn.Handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	tls_conn.SetDeadline(time.Time{})
+	return tls_conn, nil
+}
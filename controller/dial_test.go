@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/transport"
+)
+
+// Transport which keeps dialed network connection without RTMP handshake.
+type testTransport struct {
+	conn net.Conn // The last passed network connection.
+}
+
+// Keeps passed network connection.
+//
+// params: c          net.Conn            Established network connection.
+//         server_url string              RTMP server application URL.
+//         handler    transport.Handler   Connection events handler.
+func (t *testTransport) NewConn(c net.Conn, server_url string,
+	handler transport.Handler) (transport.Conn, error) {
+	t.conn = c
+	return &testConn{c}, nil
+}
+
+// Connection of test transport.
+type testConn struct {
+	c net.Conn // Network connection.
+}
+
+// Does nothing.
+func (c *testConn) Connect() error {
+	return nil
+}
+
+// Returns handshake status.
+func (c *testConn) Status() uint {
+	return transport.STATUS_HANDSHAKE_OK
+}
+
+// Closes network connection.
+func (c *testConn) Close() {
+	c.c.Close()
+}
+
+// Starts TLS server with self-signed certificate of 127.0.0.1.
+// Returns server address and path of certificate PEM file.
+//
+// param: t *testing.T   Test.
+func startTLSServer(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rtmp-bot test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(
+		rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert_file := filepath.Join(t.TempDir(), "ca.pem")
+	cert_pem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(cert_file, cert_pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(ioutil.Discard, c)
+			}()
+		}
+	}()
+	return listener.Addr().String(), cert_file
+}
+
+// RTMPS connections are verified by TLS options.
+func TestDialTLS(t *testing.T) {
+	address, ca_file := startTLSServer(t)
+	_, port, _ := net.SplitHostPort(address)
+	tests := []struct {
+		name    string
+		url     string
+		options model.TLSOptions
+		valid   bool
+	}{
+		{"trusted CA", "rtmps://" + address + "/live",
+			model.TLSOptions{TLSCAFile: ca_file}, true},
+		{"trusted CA by host name", "rtmps://localhost:" + port + "/live",
+			model.TLSOptions{TLSCAFile: ca_file}, true},
+		{"unknown authority", "rtmps://" + address + "/live",
+			model.TLSOptions{}, false},
+		{"server name mismatch", "rtmps://" + address + "/live",
+			model.TLSOptions{TLSCAFile: ca_file, TLSServerName: "example.com"},
+			false},
+		{"insecure skip verify", "rtmps://" + address + "/live",
+			model.TLSOptions{TLSInsecureSkipVerify: true}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tls_config, err := NewTLSConfig(&test.options)
+			if err != nil {
+				t.Fatalf("can not make TLS config: %s", err)
+			}
+			rtmp_transport := &testTransport{}
+			dialer := &Dialer{Transport: rtmp_transport, TLSConfig: tls_config}
+			handler := &RTMPHandler{Timeline: model.NewConnTimeline()}
+			handler.Timeline.Start()
+			conn, err := dialer.Dial(test.url, handler)
+			if !test.valid {
+				if err == nil {
+					conn.Close()
+					t.Fatal("connection is established without verification")
+				}
+				if rtmp_transport.conn != nil {
+					t.Error("transport gets not verified connection")
+				}
+				if handler.Timeline.IsMarked(model.PHASE_TLS_HANDSHAKE) {
+					t.Error("failed TLS handshake is marked")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("can not dial %s: %s", test.url, err)
+			}
+			defer conn.Close()
+			tls_conn, ok := rtmp_transport.conn.(*tls.Conn)
+			if !ok {
+				t.Fatalf("transport gets %T, want *tls.Conn", rtmp_transport.conn)
+			}
+			if !tls_conn.ConnectionState().HandshakeComplete {
+				t.Error("TLS handshake is not complete")
+			}
+			for _, phase := range []string{model.PHASE_DIAL,
+				model.PHASE_TLS_HANDSHAKE, model.PHASE_HANDSHAKE} {
+				if !handler.Timeline.IsMarked(phase) {
+					t.Errorf("phase %s is not marked", phase)
+				}
+			}
+		})
+	}
+}
+
+// Not TLS RTMP connections are not wrapped into TLS.
+func TestDialPlain(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	rtmp_transport := &testTransport{}
+	dialer := &Dialer{Transport: rtmp_transport}
+	handler := &RTMPHandler{Timeline: model.NewConnTimeline()}
+	conn, err := dialer.Dial("rtmp://"+listener.Addr().String()+"/live", handler)
+	if err != nil {
+		t.Fatalf("can not dial: %s", err)
+	}
+	defer conn.Close()
+	if _, ok := rtmp_transport.conn.(*tls.Conn); ok {
+		t.Error("plain RTMP connection is wrapped into TLS")
+	}
+	if handler.Timeline.IsMarked(model.PHASE_TLS_HANDSHAKE) {
+		t.Error("TLS handshake is marked for plain RTMP")
+	}
+}
+
+// Check verifies that RTMPS server is reachable and trusted.
+func TestDialerCheck(t *testing.T) {
+	address, ca_file := startTLSServer(t)
+	trusted, err := NewTLSConfig(&model.TLSOptions{TLSCAFile: ca_file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := &Dialer{TLSConfig: trusted}
+	if err := dialer.Check("rtmps://" + address + "/live"); err != nil {
+		t.Errorf("trusted server is not reachable: %s", err)
+	}
+	if err := (&Dialer{}).Check("rtmps://" + address + "/live"); err == nil {
+		t.Error("not verified server is reachable")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+	if err := dialer.Check("rtmp://" + closed + "/live"); err == nil {
+		t.Error("closed port is reachable")
+	}
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+)
+
+// Event bus constants.
+const (
+	DEFAULT_EVENT_SHARDS = 64  // Default count of event bus shards.
+	EVENT_SHARD_BUFFER   = 256 // Capacity of shard events queue.
+)
+
+// Handler of signals.
+type SignalHandler interface {
+	OnSignal(signal *model.Signal) // Handles signal.
+}
+
+// Listener of RTMP client events.
+type EventListener interface {
+	OnEvent(signal *model.Signal) // Handles client event.
+}
+
+// Sharded bus of RTMP clients events.
+// Client events are dispatched to the client listener by the shard goroutine
+// chosen by client ID. So events of one client are handled in order,
+// while events of different clients are handled in parallel.
+type EventBus struct {
+	shards    []*eventShard // Bus shards.
+	stop_chan chan bool     // Stop channel.
+	stop_once sync.Once
+}
+
+// Shard of events bus.
+type eventShard struct {
+	mutex     sync.RWMutex
+	events    chan *model.Signal       // Events queue.
+	listeners map[string]EventListener // Clients listeners by client ID.
+}
+
+// Returns new event bus and starts its shards.
+//
+// param: shards int   Count of shards.
+func NewEventBus(shards int) *EventBus {
+	if shards < 1 {
+		shards = DEFAULT_EVENT_SHARDS
+	}
+	b := &EventBus{
+		shards:    make([]*eventShard, shards),
+		stop_chan: make(chan bool),
+	}
+	for i := range b.shards {
+		b.shards[i] = &eventShard{
+			events:    make(chan *model.Signal, EVENT_SHARD_BUFFER),
+			listeners: make(map[string]EventListener),
+		}
+		go b.shards[i].run(b.stop_chan)
+	}
+	return b
+}
+
+// Subscribes listener to events of RTMP client.
+//
+// params: id       string          RTMP client ID.
+//         listener EventListener   Client events listener.
+func (b *EventBus) Subscribe(id string, listener EventListener) {
+	shard := b.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	shard.listeners[id] = listener
+}
+
+// Unsubscribes listener of RTMP client events.
+// Not dispatched events of the client are dropped.
+//
+// param: id string   RTMP client ID.
+func (b *EventBus) Unsubscribe(id string) {
+	shard := b.shard(id)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+	delete(shard.listeners, id)
+}
+
+// Queues RTMP client event into the client shard.
+// Blocks while the shard queue is full.
+// Events are dropped after the bus is closed.
+//
+// param: signal *model.Signal   Client event.
+func (b *EventBus) OnSignal(signal *model.Signal) {
+	select {
+	case b.shard(signal.Target).events <- signal:
+	case <-b.stop_chan:
+	}
+}
+
+// Stops all shards.
+func (b *EventBus) Close() {
+	b.stop_once.Do(func() {
+		close(b.stop_chan)
+	})
+}
+
+// Returns shard of RTMP client.
+//
+// param: id string   RTMP client ID.
+func (b *EventBus) shard(id string) *eventShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return b.shards[hash.Sum32()%uint32(len(b.shards))]
+}
+
+// Dispatches shard events until stop.
+//
+// param: stop_chan chan bool   Stop channel.
+func (s *eventShard) run(stop_chan chan bool) {
+	for {
+		select {
+		case signal := <-s.events:
+			s.mutex.RLock()
+			listener, ok := s.listeners[signal.Target]
+			s.mutex.RUnlock()
+			if ok {
+				listener.OnEvent(signal)
+			}
+		case <-stop_chan:
+			return
+		}
+	}
+}
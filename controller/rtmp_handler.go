@@ -2,26 +2,31 @@ package controller
 
 import (
 	"github.com/instrumentisto/go-rtmp-bot/model"
-	rtmp "github.com/zhangpeihao/gortmp"
-	"log"
+	"github.com/instrumentisto/go-rtmp-bot/transport"
 )
 
+// Receiver of RTMP media messages.
+type MessageReceiver interface {
+	PlayStream(message *transport.Message) // Handles media message.
+}
+
 // RTMP clients event handler.
-// The implementation of rtmp OutboundHandler
+// The implementation of transport Handler
 type RTMPHandler struct {
-	ID      string
-	Handler *AppHandler
+	ID       string
+	Handler  SignalHandler
+	Timeline *model.ConnTimeline // Connection lifecycle timeline.
+	Receiver MessageReceiver     // Media messages receiver.
+	Lost     func()              // Called when connection is closed.
 }
 
 // Handles changing status of rtmp connection.
 // Just calls Application handler onSignal with status signal.
 //
-// param:  Reference to RTMP connection.
-func (h *RTMPHandler) OnStatus(conn rtmp.OutboundConn) {
-	var err error
-	status, err := conn.Status()
-	if err != nil {
-		log.Panicf("can not read status: %s", err.Error())
+// param:  RTMP connection status.
+func (h *RTMPHandler) OnStatus(status uint) {
+	if status == transport.STATUS_CONNECT_OK {
+		h.Timeline.Mark(model.PHASE_CONNECT)
 	}
 	signal := model.NewSignal(model.STATUS, h.ID)
 	signal.Data = status
@@ -29,60 +34,57 @@ func (h *RTMPHandler) OnStatus(conn rtmp.OutboundConn) {
 }
 
 // Handles close RTMP connection.
-// Just calls Application handler onSignal with closed signal.
-//
-// params:  Reference to rtmp connection.
-func (h *RTMPHandler) OnClosed(conn rtmp.Conn) {
+// Notifies client of connection loss and calls Application handler
+// onSignal with closed signal.
+func (h *RTMPHandler) OnClosed() {
+	if h.Lost != nil {
+		h.Lost()
+	}
 	signal := model.NewSignal(model.CLOSED, h.ID)
 	h.Handler.OnSignal(signal)
 }
 
-// Handles receiving of any RTMP message.
-// Just calls Application handler onSignal with play stream signal.
+// Handles receiving of RTMP media message.
+// Passes the message to receiver in the receiving goroutine,
+// so no signal is sent per message.
+// Without receiver calls Application handler onSignal with play stream signal.
 //
-// params:  Reference to RTMP connection;
-//          Any RTMP message (in this case - video frame).
-func (h *RTMPHandler) OnReceived(conn rtmp.Conn, message *rtmp.Message) {
+// params:  RTMP media message (in this case - video frame).
+func (h *RTMPHandler) OnReceived(message *transport.Message) {
+	if h.Receiver != nil {
+		h.Receiver.PlayStream(message)
+		return
+	}
 	signal := model.NewSignal(model.PLAY_STREAM, h.ID)
 	signal.Data = message
 	h.Handler.OnSignal(signal)
 }
 
-// Handles received rtmp command.
-// This method implements OutboundHandler interface only.
-//
-// params:  Reference to RTMP connection;
-//          Any RTMP command.
-func (h *RTMPHandler) OnReceivedRtmpCommand(
-	conn rtmp.Conn, command *rtmp.Command) {
-}
-
 // Handles stream creation.
 // Just calls Application handler onSignal with stream create signal.
 //
-// params:  Reference to RTMP connection;
-//          Reference to RTMP stream instance.
-
-func (h *RTMPHandler) OnStreamCreated(
-	conn rtmp.OutboundConn, stream rtmp.OutboundStream) {
+// params:  Reference to RTMP stream instance.
+func (h *RTMPHandler) OnStreamCreated(stream transport.Stream) {
+	h.Timeline.Mark(model.PHASE_CREATE_STREAM)
 	signal := model.NewSignal(model.STREAM_CREATE, h.ID)
 	signal.Data = stream
 	h.Handler.OnSignal(signal)
 }
 
 // Handles play start.
-// This method implements OutboundHandler interface only.
+// Just marks play phase in connection timeline.
 //
 // params: Reference to RTMP stream instance.
-func (h *RTMPHandler) OnPlayStart(stream rtmp.OutboundStream) {
-	// Does nothing.
+func (h *RTMPHandler) OnPlayStart(stream transport.Stream) {
+	h.Timeline.Mark(model.PHASE_PLAY)
 }
 
 // Handles start of stream publishing.
 // Just calls Application handler onSignal with publish start signal.
 //
 // params: Reference to RTMP stream instance.
-func (h *RTMPHandler) OnPublishStart(stream rtmp.OutboundStream) {
+func (h *RTMPHandler) OnPublishStart(stream transport.Stream) {
+	h.Timeline.Mark(model.PHASE_PUBLISH)
 	signal := model.NewSignal(model.PUBLISH_START, h.ID)
 	signal.Data = stream
 	h.Handler.OnSignal(signal)
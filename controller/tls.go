@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+)
+
+// Returns TLS configuration of RTMPS connections.
+//
+// param: options *model.TLSOptions   TLS options of test request.
+// returns: TLS configuration or error if certificates can not be loaded.
+func NewTLSConfig(options *model.TLSOptions) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         options.TLSServerName,
+		InsecureSkipVerify: options.TLSInsecureSkipVerify,
+	}
+	if options.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(options.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in CA bundle " +
+				options.TLSCAFile)
+		}
+	}
+	if options.TLSCertFile != "" || options.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(
+			options.TLSCertFile, options.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
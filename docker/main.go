@@ -10,6 +10,7 @@ import (
 	"github.com/instrumentisto/go-rtmp-bot"
 	"github.com/instrumentisto/go-rtmp-bot/prometheus"
 	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/results"
 	"github.com/instrumentisto/go-rtmp-bot/utils"
 	"os"
 )
@@ -17,6 +18,7 @@ import (
 var (
 	test_launcher *rtmp_bot.Launcher // The application is a stress tester for rtmp media servers.
 	report        *model.Report  // Test report value object.
+	prometheus_client *prometheus.ReportExportClient // Prometheus metrics exporter.
 	listenAddress = flag.String(
 		"web.listen-address",
 		":9132",
@@ -25,10 +27,16 @@ var (
 		"web.telemetry-path",
 		"/metrics",
 		"Path under which to expose metrics.")
+	roleLabels = flag.Bool("metrics.role-labels", false,
+		"Label clients metrics by client role.")
+	streamLabels = flag.Bool("metrics.stream-labels", false,
+		"Label clients metrics by stream key.")
 	api_addrs = flag.String("api.addrs",":8083",
 		"Address to listen http requests for API")
-	flvPath = flag.String("flv_file", "","Test flv file path")
+	flvPath = flag.String("flv_file", "","Test flv file path or synthetic media source")
 	server=flag.String("server","stress_test","Media server name")
+	reportDir = flag.String("report_dir", "",
+		"Directory to save final test reports, empty disables saving")
 )
 
 // Starts web interface for run stress tests.
@@ -36,13 +44,12 @@ var (
 func main() {
 	flag.Parse()
 	defer os.Exit(1)
-	if(*flvPath == ""){
-		log.Fatal("flv file not specified!")
-		return
-	}
 	report = model.NewReport(*server)
-	prometheus_client := prometheus.NewReportExportClient(
-		*listenAddress, *metricPath, report)
+	prometheus_client = prometheus.NewReportExportClient(
+		*listenAddress, *metricPath, report, prometheus.MetricLabels{
+			Role:   *roleLabels,
+			Stream: *streamLabels,
+		})
 	go prometheus_client.Run()
 	router := mux.NewRouter().StrictSlash(true)
 	router.HandleFunc("/start_test", startTest)
@@ -71,6 +78,12 @@ func startTest(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, model.GetResponse(2))
 		return
 	}
+	err = start_request.LoadScenario()
+	if err != nil {
+		log.Printf("Load scenario ERROR: %s", err.Error())
+		fmt.Fprintln(w, model.GetResponse(2))
+		return
+	}
 	report.ResetReport(
 		utils.GetUUID(), start_request.ModelCount, start_request.ClientCount)
 	if test_launcher != nil {
@@ -78,7 +91,8 @@ func startTest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	test_launcher = rtmp_bot.NewLauncher(start_request, report,*flvPath)
-	go test_launcher.Start()
+	test_launcher.Observer = prometheus_client
+	go startLauncher(test_launcher)
 	fmt.Fprintln(w, model.GetResponse(1))
 }
 
@@ -87,6 +101,7 @@ func startTest(w http.ResponseWriter, r *http.Request) {
 // Clean report.
 func stopTest(w http.ResponseWriter, r *http.Request) {
 	if test_launcher != nil {
+		saveResult(test_launcher.Result())
 		go test_launcher.Stop()
 		test_launcher = nil
 	}
@@ -95,6 +110,21 @@ func stopTest(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, model.GetResponse(0))
 }
 
+// Saves final test report files if reports directory is specified.
+//
+// param: result *model.TestResult   Test result.
+func saveResult(result *model.TestResult) {
+	if *reportDir == "" {
+		return
+	}
+	paths, err := results.Save(result, *reportDir)
+	if err != nil {
+		log.Printf("Save report ERROR: %s", err.Error())
+		return
+	}
+	log.Printf("Report saved: %v", paths)
+}
+
 // Returns status of current test.
 func getStatus(w http.ResponseWriter, r *http.Request) {
 	writeAccessHeaders(w)
@@ -113,3 +143,12 @@ func writeAccessHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Headers",
 		"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
 }
+
+// Runs stress test and logs error if the test can not be started.
+//
+// param: launcher *rtmp_bot.Launcher   Stress test launcher.
+func startLauncher(launcher *rtmp_bot.Launcher) {
+	if err := launcher.Start(); err != nil {
+		log.Printf("Start test ERROR: %s", err.Error())
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/prometheus"
+	"github.com/instrumentisto/go-rtmp-bot/redis"
+	"github.com/instrumentisto/go-rtmp-bot/utils"
+)
+
+var (
+	listenAddress = flag.String(
+		"web.listen-address",
+		":9133",
+		"Address to listen on for web interface and telemetry.")
+	metricPath = flag.String(
+		"web.telemetry-path",
+		"/metrics",
+		"Path under which to expose metrics.")
+	redis_url    = flag.String("redis", "localhost:6379", "redis url")
+	server       = flag.String("server", "stress_test", "Media server name")
+	model_count  = flag.Int("model_count", 1, "Total count of model bots")
+	client_count = flag.Int("client_count", 1, "Count of client bots per model")
+	duration     = flag.Duration("duration", time.Minute, "Test duration")
+	list_agents  = flag.Bool("list_agents", false,
+		"Print registrations of live agents and exit")
+)
+
+// Coordinates distributed stress test.
+// Splits load across agents started with "-agent" flag of redis example,
+// exports aggregated report of agents and prints it after the test.
+// Prints live agents only with "-list_agents" flag.
+func main() {
+	flag.Parse()
+	os.Exit(run())
+}
+
+// Runs distributed stress test and returns exit code.
+func run() int {
+	report := model.NewReport(*server)
+	if *list_agents {
+		return printAgents(redis.NewCoordinator(*redis_url, "", 0, report))
+	}
+	prometheus_client := prometheus.NewReportExportClient(
+		*listenAddress, *metricPath, report, prometheus.MetricLabels{})
+	go prometheus_client.Run()
+	coordinator := redis.NewCoordinator(*redis_url, "", 0, report)
+	defer coordinator.Close()
+	if err := coordinator.Start(
+		utils.GetUUID(), *model_count, *client_count); err != nil {
+		log.Printf("Start test ERROR: %s", err.Error())
+		return 1
+	}
+	stop := make(chan struct{})
+	collected := make(chan struct{})
+	go func() {
+		coordinator.Run(redis.DEFAULT_HEARTBEAT_INTERVAL, stop)
+		close(collected)
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-time.After(*duration):
+	case <-interrupt:
+		log.Print("test interrupted")
+	}
+	close(stop)
+	<-collected
+	final_report := report.Snapshot()
+	if err := coordinator.Stop(); err != nil {
+		log.Printf("Stop test ERROR: %s", err.Error())
+	}
+	jsn, err := json.MarshalIndent(final_report, "", "  ")
+	if err != nil {
+		log.Printf("Marshal report ERROR: %s", err.Error())
+		return 1
+	}
+	fmt.Println(string(jsn))
+	return 0
+}
+
+// Prints registrations of live agents as JSON and returns exit code.
+//
+// param: coordinator *redis.Coordinator   Coordinator of distributed test.
+func printAgents(coordinator *redis.Coordinator) int {
+	defer coordinator.Close()
+	agents, err := coordinator.LiveAgents()
+	if err != nil {
+		log.Printf("List agents ERROR: %s", err.Error())
+		return 1
+	}
+	jsn, err := json.MarshalIndent(agents, "", "  ")
+	if err != nil {
+		log.Printf("Marshal agents ERROR: %s", err.Error())
+		return 1
+	}
+	fmt.Println(string(jsn))
+	return 0
+}
@@ -5,14 +5,21 @@ import (
 	"github.com/instrumentisto/go-rtmp-bot"
 	"github.com/instrumentisto/go-rtmp-bot/model"
 	"github.com/instrumentisto/go-rtmp-bot/prometheus"
+	"github.com/instrumentisto/go-rtmp-bot/publisher"
 	"log"
 
 	"github.com/instrumentisto/go-rtmp-bot/controller"
 	"github.com/instrumentisto/go-rtmp-bot/redis"
+	"github.com/instrumentisto/go-rtmp-bot/results"
+	"github.com/instrumentisto/go-rtmp-bot/transport"
 	"github.com/instrumentisto/go-rtmp-bot/utils"
 	"os"
 )
 
+// Bot version announced in agent registration,
+// set by -ldflags "-X main.version=...".
+var version = "dev"
+
 var (
 	test_launcher *rtmp_bot.Launcher // The application is a stress tester for rtmp media servers.
 	report        *model.Report      // Test report value object.
@@ -24,12 +31,74 @@ var (
 		"web.telemetry-path",
 		"/metrics",
 		"Path under which to expose metrics.")
+	roleLabels = flag.Bool("metrics.role-labels", false,
+		"Label clients metrics by client role.")
+	streamLabels = flag.Bool("metrics.stream-labels", false,
+		"Label clients metrics by stream key.")
 	redis_url = flag.String("redis", "localhost:6379", "redis url")
-	flvPath   = flag.String("flv_file", "", "Test flv file path")
+	flvPath   = flag.String("flv_file", "", "Test flv file path or synthetic media source")
 	server    = flag.String("server", "stress_test", "Media server name")
 	rtmp_url  = flag.String("rtmp_url",
 		"rtmp://rtmp_server:1935/live",
 		"RTMP Server application URL")
+	rtmpTransport = flag.String("transport", transport.DEFAULT_TRANSPORT,
+		"RTMP client implementation")
+	frameQueueSize = flag.Int("frame_queue_size",
+		publisher.DEFAULT_FRAME_QUEUE_SIZE, "Capacity of publisher frame queue")
+	overflowPolicy = flag.String("overflow_policy", publisher.OVERFLOW_DROP,
+		"Policy of full publisher frame queue: drop, block or disconnect")
+	flvFiles = flag.String("flv_files", "",
+		"Comma separated test flv files or globs, defaults to flv_file")
+	independentSources = flag.Bool("independent_sources", false,
+		"Each publisher reads its own copy of test flv file")
+	randomOffset = flag.Bool("random_offset", false,
+		"Publishers start from random key frame of own test flv file")
+	stallThreshold = flag.Int("stall_threshold", model.DEFAULT_STALL_THRESHOLD,
+		"Video gap or lag of player considered as stall in ms")
+	verifyIntegrity = flag.Bool("verify_integrity", false,
+		"Publishers tag frames, so players verify loss, order and checksums")
+	sweep = flag.String("sweep", "",
+		"Swept parameter of synthetic media: bitrate or fps, empty disables sweep")
+	sweepValues = flag.String("sweep_values", "",
+		"Comma separated swept parameter values, e.g. 500k,1m,2m")
+	sweepStep = flag.Int("sweep_step", 0,
+		"Duration of each sweep step in seconds")
+	pacing = flag.String("pacing", model.PACING_REALTIME,
+		"Pacing of test media: realtime, fast or speed")
+	pacingSpeed = flag.Float64("pacing_speed", 1,
+		"Speed factor of realtime in speed pacing mode")
+	pacingLead = flag.Int("pacing_lead", model.DEFAULT_PACING_LEAD,
+		"Allowed lead of frames sending schedule in ms")
+	pacingLag = flag.Int("pacing_lag", model.DEFAULT_PACING_LAG,
+		"Lag of frames sending schedule caught up by burst in ms")
+	reconnectAttempts = flag.Int("reconnect_attempts", 0,
+		"Max consecutive reconnect attempts, 0 disables, -1 is unlimited")
+	reconnectBackoff = flag.Int("reconnect_backoff",
+		model.DEFAULT_RECONNECT_BACKOFF, "Backoff before the first reconnect in ms")
+	reconnectMaxBackoff = flag.Int("reconnect_max_backoff",
+		model.DEFAULT_RECONNECT_MAX_BACKOFF, "Maximal reconnect backoff in ms")
+	reconnectJitter = flag.Float64("reconnect_jitter", 0.2,
+		"Random part of reconnect backoff from 0 to 1")
+	tlsCA = flag.String("tls_ca", "",
+		"PEM CA bundle file to verify RTMPS server certificate")
+	tlsCert = flag.String("tls_cert", "",
+		"PEM client certificate file of RTMPS connections")
+	tlsKey = flag.String("tls_key", "",
+		"PEM client private key file of RTMPS connections")
+	tlsServerName = flag.String("tls_server_name", "",
+		"SNI server name of RTMPS connections, defaults to URL host")
+	tlsInsecure = flag.Bool("tls_insecure", false,
+		"Skip RTMPS server certificate verification")
+	reportDir = flag.String("report_dir", "",
+		"Directory to save final test reports, empty disables saving")
+	agentMode = flag.Bool("agent", false,
+		"Run load assigned by distributed test coordinator")
+	agentName = flag.String("agent_name", "",
+		"Agent name of distributed test, defaults to host name")
+	agentTTL = flag.Duration("agent_ttl", redis.DEFAULT_AGENT_TTL,
+		"Lifetime of agent registration without heartbeats")
+	maxClients = flag.Int("max_clients", 0,
+		"Maximal count of RTMP clients announced by agent, 0 is unlimited")
 )
 
 // Starts web interface for run stress tests.
@@ -37,13 +106,12 @@ var (
 func main() {
 	flag.Parse()
 	defer os.Exit(1)
-	if *flvPath == "" {
-		log.Fatal("flv file not specified!")
-		return
-	}
 	report = model.NewReport(*server)
 	prometheus_client := prometheus.NewReportExportClient(
-		*listenAddress, *metricPath, report)
+		*listenAddress, *metricPath, report, prometheus.MetricLabels{
+			Role:   *roleLabels,
+			Stream: *streamLabels,
+		})
 	go prometheus_client.Run()
 	log.Printf("listen redis: %v", *redis_url)
 	app_handler := controller.AppHandler{
@@ -58,45 +126,156 @@ func main() {
 	defer listener.Close()
 	defer listener.WriteToMap("stress_test:status", *server, "down")
 	go listener.Listen()
+	// Registration of crashed bot expires without heartbeats.
+	test_agent := newAgent()
+	defer test_agent.Close()
+	go test_agent.Run(report, redis.DEFAULT_HEARTBEAT_INTERVAL, nil)
 
 	for {
 		select {
 		case signal := <-app_handler.Signal_chan:
 			if signal.SignalType == redis.START_COMMAND {
 				log.Println("HANDLE start test!!!")
-				model_count, err := listener.Read("stress-test:model_count")
-				if err != nil {
-					log.Printf("Can not read models from readis: %v", err)
+				start_request := new(model.StartRequest)
+				test_id := utils.GetUUID()
+				if *agentMode {
+					assignment, err := test_agent.Assignment()
+					if err != nil {
+						log.Printf("Can not read assignment from readis: %v", err)
+						continue
+					}
+					test_id = assignment.TestId
+					start_request.ModelCount = assignment.ModelCount
+					start_request.ClientCount = assignment.ClientCount
+					start_request.StreamOffset = assignment.StreamOffset
+				} else {
+					model_count, err := listener.Read("stress-test:model_count")
+					if err != nil {
+						log.Printf("Can not read models from readis: %v", err)
+					}
+					client_count, err := listener.Read("stress-test:client_count")
+					if err != nil {
+						log.Printf("Can not read clients from readis: %v", err)
+					}
+					start_request.ModelCount = int(model_count)
+					start_request.ClientCount = int(client_count)
+				}
+				start_request.Transport = *rtmpTransport
+				start_request.FrameQueueSize = *frameQueueSize
+				start_request.OverflowPolicy = *overflowPolicy
+				start_request.FlvFiles = *flvFiles
+				start_request.Independent = *independentSources
+				start_request.RandomOffset = *randomOffset
+				start_request.StallThreshold = *stallThreshold
+				start_request.VerifyIntegrity = *verifyIntegrity
+				start_request.Sweep = model.Sweep{
+					SweepParameter: *sweep,
+					SweepValues:    *sweepValues,
+					SweepStepTime:  *sweepStep,
+				}
+				start_request.Pacing = model.Pacing{
+					PacingMode:  *pacing,
+					PacingSpeed: *pacingSpeed,
+					PacingLead:  *pacingLead,
+					PacingLag:   *pacingLag,
 				}
-				client_count, err := listener.Read("stress-test:client_count")
+				start_request.ReconnectPolicy = model.ReconnectPolicy{
+					ReconnectAttempts:   *reconnectAttempts,
+					ReconnectBackoff:    *reconnectBackoff,
+					ReconnectMaxBackoff: *reconnectMaxBackoff,
+					ReconnectJitter:     *reconnectJitter,
+				}
+				start_request.TLSOptions = model.TLSOptions{
+					TLSCAFile:             *tlsCA,
+					TLSCertFile:           *tlsCert,
+					TLSKeyFile:            *tlsKey,
+					TLSServerName:         *tlsServerName,
+					TLSInsecureSkipVerify: *tlsInsecure,
+				}
+				// Scenario counts would override load assigned to agent.
+				if !*agentMode {
+					var err error
+					start_request.ScenarioFile, err = listener.ReadString(
+						"stress-test:scenario")
+					if err != nil {
+						start_request.ScenarioFile = ""
+					}
+				}
+				err := start_request.LoadScenario()
 				if err != nil {
-					log.Printf("Can not read clients from readis: %v", err)
+					log.Printf("Can not load scenario: %v", err)
+					continue
+				}
+				if start_request.ServerURL == "" {
+					start_request.ServerURL = *rtmp_url
 				}
-
-				start_request := new(model.StartRequest)
-				start_request.ServerURL = *rtmp_url
-				start_request.ModelCount = int(model_count)
-				start_request.ClientCount = int(client_count)
 
 				report.ResetReport(
-					utils.GetUUID(),
+					test_id,
 					start_request.ModelCount, start_request.ClientCount)
 				test_launcher = rtmp_bot.NewLauncher(
 					start_request, report, *flvPath)
-				go test_launcher.Start()
+				test_launcher.Observer = prometheus_client
+				go startLauncher(test_launcher)
 				listener.WriteToMap("stress_test:status", *server, "started")
+				test_agent.SetStatus(redis.AGENT_STARTED)
 
 			} else if signal.SignalType == redis.STOP_COMMAND {
 				log.Println("HANDLE stop test")
 				if test_launcher != nil {
+					saveResult(test_launcher.Result())
 					test_launcher.Stop()
 					test_launcher = nil
 					log.Println("test launcer stopped")
 				}
 				report.ResetReport("", 0, 0)
 				listener.WriteToMap("stress_test:status", *server, "ready")
+				test_agent.SetStatus(redis.AGENT_READY)
 			}
 		}
 	}
 
 }
+
+// Returns agent of distributed test named by flag or host name.
+func newAgent() *redis.Agent {
+	host, err := os.Hostname()
+	if err != nil {
+		host = utils.GetUUID()
+	}
+	name := *agentName
+	if name == "" {
+		name = host
+	}
+	log.Printf("agent: %s", name)
+	return redis.NewAgent(*redis_url, "", 0, redis.AgentInfo{
+		Name:       name,
+		Host:       host,
+		Version:    version,
+		MaxClients: *maxClients,
+	}, *agentTTL)
+}
+
+// Saves final test report files if reports directory is specified.
+//
+// param: result *model.TestResult   Test result.
+func saveResult(result *model.TestResult) {
+	if *reportDir == "" {
+		return
+	}
+	paths, err := results.Save(result, *reportDir)
+	if err != nil {
+		log.Printf("Save report ERROR: %s", err.Error())
+		return
+	}
+	log.Printf("Report saved: %v", paths)
+}
+
+// Runs stress test and logs error if the test can not be started.
+//
+// param: launcher *rtmp_bot.Launcher   Stress test launcher.
+func startLauncher(launcher *rtmp_bot.Launcher) {
+	if err := launcher.Start(); err != nil {
+		log.Printf("Start test ERROR: %s", err.Error())
+	}
+}
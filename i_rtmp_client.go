@@ -1,22 +1,20 @@
 package rtmp_bot
 
 import (
-	rtmp "github.com/zhangpeihao/gortmp"
-
 	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/transport"
 )
 
 // RTMP client interface (publisher or player).
 type IRTMPClient interface {
-	SetStatus(status uint)                    // Sets RTMP connection status.
-	SetStream(stream rtmp.OutboundStream)     // Sets reference to RTMP stream.
-	PublishStream(stream rtmp.OutboundStream) //Publishes stream.
-	PlayStream(message *rtmp.Message)         // Plays RTMP stream.
-	Stop()                                    // Stops RTMP client.
-	GetID() string                            // Returns RTMP client ID.
-	Run()                                     // Runs RTMP connection.
-	GetStreamKey() string                     // Returns RTMP stream key.
-	GetStat() *model.StatItem                 // Returns statistic instance.
-	AddFrame(frame *model.FlvFrame)           // Adds rtmp frame
-	UpdateStat()                              // Updates statistics.
+	SetStatus(status uint)                 // Sets RTMP connection status.
+	SetStream(stream transport.Stream)     // Sets reference to RTMP stream.
+	PublishStream(stream transport.Stream) //Publishes stream.
+	PlayStream(message *transport.Message) // Plays RTMP stream.
+	Stop()                                 // Stops RTMP client.
+	GetID() string                         // Returns RTMP client ID.
+	Run()                                  // Runs RTMP connection.
+	GetStreamKey() string                  // Returns RTMP stream key.
+	GetStat() *model.StatItem              // Returns statistic instance.
+	UpdateStat()                           // Updates statistics.
 }
@@ -1,13 +1,15 @@
 package rtmp_bot
 
 import (
+	"fmt"
 	"github.com/instrumentisto/go-rtmp-bot/controller"
 	"github.com/instrumentisto/go-rtmp-bot/model"
 	"github.com/instrumentisto/go-rtmp-bot/player"
 	"github.com/instrumentisto/go-rtmp-bot/publisher"
-	"github.com/zhangpeihao/gortmp"
 	"log"
+	"math/rand"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -17,17 +19,48 @@ import (
 // Makes test report.
 const (
 	log_filename = "stress_test.log"
+	profile_tick = 250 * time.Millisecond // Load profile scheduling interval.
 )
 
 // RTMP media server stress test launcher.
 // Starts requested count of publishers and players.
 type Launcher struct {
-	Data       *model.StartRequest // Stress test requested parameters.
-	TestReport *model.Report
-	rtmp_path  string
-	clients    map[string]IRTMPClient // Map of RTMP clients.
-	handler    *controller.AppHandler // Application signals handler.
-	stop_chan  chan bool              // Stop channel.
+	Data          *model.StartRequest // Stress test requested parameters.
+	TestReport    *model.Report
+	Observer      model.SampleObserver // Observer of clients samples, may be nil.
+	rtmp_path     string
+	clients       *clientRegistry                  // Registry of RTMP clients.
+	streams       []*stream                        // Started streams in start order.
+	sources       map[string]publisher.MediaSource // Shared test media sources by path.
+	files         []string                         // Test flv files paths of publishers.
+	handler       *controller.AppHandler           // Application signals handler.
+	stop_chan     chan bool                        // Stop channel, closed once by Stop.
+	stop_once     sync.Once                        // Closes stop channel once.
+	started_at    time.Time                        // Test start time.
+	client_target int                              // Players per stream of current load step.
+	phase         int                              // Index of current scenario phase.
+	result        *model.TestResult                // Test result time series.
+	dialer        *controller.Dialer               // RTMP connections dialer.
+	events        *controller.EventBus             // RTMP clients events bus.
+	sweep         *model.SweepTracker              // Sweep steps results, nil without sweep.
+	sweep_values  []int                            // Swept parameter values of steps.
+	sweep_step    int                              // Index of applied sweep step.
+}
+
+// RTMP stream started by launcher.
+type stream struct {
+	key       string                // RTMP stream key.
+	source    string                // Test flv file path.
+	publisher IRTMPClient           // Stream publisher.
+	players   []IRTMPClient         // Stream players in start order.
+	published bool                  // Publisher starts publishing.
+	own_flv   publisher.MediaSource // Own test media source of independent source.
+}
+
+// Requested state of RTMP stream.
+type streamTarget struct {
+	key    string // RTMP stream key.
+	source string // Test flv file path.
 }
 
 // Constructs new stress test launcher.
@@ -39,7 +72,9 @@ func NewLauncher(data *model.StartRequest, report *model.Report, rtmp_file_path
 		Data:       data,
 		TestReport: report,
 		rtmp_path:  rtmp_file_path,
-		clients:    make(map[string]IRTMPClient),
+		clients:    newClientRegistry(),
+		sources:    make(map[string]publisher.MediaSource),
+		result:     model.NewTestResult(report),
 		stop_chan:  make(chan bool),
 		handler: &controller.AppHandler{
 			Signal_chan: make(chan *model.Signal),
@@ -48,119 +83,434 @@ func NewLauncher(data *model.StartRequest, report *model.Report, rtmp_file_path
 }
 
 // Starts stress test.
-func (l *Launcher) Start() {
+// Runs phases of test scenario if the scenario is requested,
+// otherwise follows requested load profile.
+// Returns error if the test can not be started, nil after the test is
+// stopped.
+func (l *Launcher) Start() error {
 	defer l.cleanMap()
 	l.cleanMap()
-	l.stop_chan = make(chan bool)
-	flv_chan := make(chan *model.FlvFrame)
-	flv_stream, err := publisher.NewFlvFile(
-		l.rtmp_path,
-		l.handler)
+	if err := publisher.CheckOverflowPolicy(l.Data.OverflowPolicy); err != nil {
+		return fmt.Errorf("frame queue: %s", err.Error())
+	}
+	if err := l.Data.Pacing.Validate(); err != nil {
+		return fmt.Errorf("pacing: %s", err.Error())
+	}
+	dialer, err := controller.NewDialer(l.Data.Transport, &l.Data.TLSOptions)
 	if err != nil {
-		return
+		return fmt.Errorf("RTMP dialer: %s", err.Error())
 	}
-	go l.startStat()
-	defer flv_stream.CloseFile()
-	for i := 0; i < l.Data.ModelCount; i++ {
-		stream_key := "model" + strconv.Itoa(i+1)
-		pub := publisher.NewPublisher(
-			l.Data.ServerURL, stream_key, l.handler, flv_chan)
-		go pub.Run()
-		l.clients[pub.GetID()] = pub
+	if err := dialer.Check(l.Data.ServerURL); err != nil {
+		return fmt.Errorf("RTMP server %s: %s", l.Data.ServerURL, err.Error())
+	}
+	l.dialer = dialer
+	if err := l.resolveFiles(); err != nil {
+		return fmt.Errorf("flv files: %s", err.Error())
 	}
-	go flv_stream.PlayFile()
+	if err := l.initSweep(); err != nil {
+		return fmt.Errorf("sweep: %s", err.Error())
+	}
+	l.events = controller.NewEventBus(controller.DEFAULT_EVENT_SHARDS)
+	defer l.events.Close()
+	defer l.closeSources()
+	if err := l.openSources(); err != nil {
+		return fmt.Errorf("open flv file: %s", err.Error())
+	}
+	// Start time is set before statistics loop which reads it.
+	l.started_at = time.Now()
+	go l.startStat()
+	l.phase = -1
+	l.applyProfile()
+	profile_ticker := time.NewTicker(profile_tick)
+	defer profile_ticker.Stop()
 	for {
 		select {
 		case signal, ok := <-l.handler.Signal_chan:
 			if ok {
 				switch signal.SignalType {
-				case model.STATUS:
-					if l.clients == nil || len(l.clients) == 0 {
-						continue
-					}
-					client, ok := l.clients[signal.Target]
-					if !ok {
-						log.Printf("STATUS client not found: %v", signal.Target)
-						continue
-					}
-
-					client.SetStatus(signal.Data.(uint))
-				case model.CLOSED:
-					if l.clients == nil || len(l.clients) == 0 {
-						continue
-					}
-					_, ok := l.clients[signal.Target]
-					if !ok {
-						log.Printf("CLOSED client not found: %v", signal.Target)
-						continue
-					}
-					break
-				case model.STREAM_CREATE:
-					if l.clients == nil || len(l.clients) == 0 {
-						continue
-					}
-					client, ok := l.clients[signal.Target]
-					if !ok {
-						log.Printf("STREAM CREATE client not found: %v", signal.Target)
-						continue
-					}
-					client.SetStream(
-						signal.Data.(gortmp.OutboundStream))
 				case model.PUBLISH_START:
-					if l.clients == nil || len(l.clients) == 0 {
-						continue
-					}
-					client, ok := l.clients[signal.Target]
+					client, ok := l.clients.Get(signal.Target)
 					if !ok {
 						log.Printf("PUBLISH START client not found: %v", signal.Target)
 						continue
 					}
-
-					client.PublishStream(
-						signal.Data.(gortmp.OutboundStream))
 					l.startClients(client.GetStreamKey())
-				case model.PLAY_STREAM:
-					if l.clients == nil || len(l.clients) == 0 {
-						continue
-					}
-					client, ok := l.clients[signal.Target]
+				case model.CLOSED:
+					client, ok := l.clients.Get(signal.Target)
 					if !ok {
-						log.Printf("PLAY STREAM client not found: %v", signal.Target)
-						continue
-					}
-					go client.PlayStream(
-						signal.Data.(*gortmp.Message))
-				case model.ADD_FRAME:
-					if l.clients == nil || len(l.clients) == 0 {
 						continue
 					}
-					for _, c := range l.clients {
-						if c.GetStat().Role == model.ROLE_PUBLISHER {
-							c.AddFrame(signal.Data.(*model.FlvFrame))
-						}
-					}
+					l.clientClosed(client)
 				}
 			}
+		case <-profile_ticker.C:
+			l.applyProfile()
 		case <-l.stop_chan:
-			return
+			return nil
+		}
+	}
+}
+
+// Resolves requested test flv files.
+// Default test flv file is used if no files are requested,
+// synthetic media is used without default test flv file.
+func (l *Launcher) resolveFiles() error {
+	l.files = []string{l.rtmp_path}
+	if l.rtmp_path == "" {
+		l.files = []string{model.SYNTHETIC_SOURCE}
+	}
+	if l.Data.FlvFiles == "" {
+		return nil
+	}
+	files, err := publisher.ResolveFlvFiles(l.Data.FlvFiles)
+	if err != nil {
+		return err
+	}
+	l.files = files
+	return nil
+}
+
+// Initializes sweep of publishers media parameter if it is requested.
+func (l *Launcher) initSweep() error {
+	l.sweep = nil
+	l.sweep_step = -1
+	if !l.Data.Sweep.Enabled() {
+		return nil
+	}
+	values, err := l.Data.Sweep.Steps()
+	if err != nil {
+		return err
+	}
+	l.sweep_values = values
+	l.sweep = model.NewSweepTracker(l.Data.SweepParameter)
+	for _, path := range l.files {
+		if !model.IsSyntheticSource(path) {
+			log.Printf("Sweep does not change test flv file %s", path)
+		}
+	}
+	return nil
+}
+
+// Returns true if each publisher reads its own test flv file.
+func (l *Launcher) independentSources() bool {
+	return l.Data.Independent || l.Data.RandomOffset
+}
+
+// Opens shared test media sources of all scenario phases or requested files.
+// Starts playing of opened sources.
+// Does nothing if publishers use independent sources.
+func (l *Launcher) openSources() error {
+	if l.independentSources() {
+		return nil
+	}
+	paths := l.files
+	if l.Data.Scenario != nil {
+		paths = nil
+		for _, phase := range l.Data.Scenario.Phases {
+			if phase.FlvFile != "" {
+				paths = append(paths, phase.FlvFile)
+			} else {
+				paths = append(paths, l.files...)
+			}
+		}
+	}
+	for _, path := range paths {
+		if _, ok := l.sources[path]; ok {
+			continue
+		}
+		source, err := publisher.OpenSource(path, &l.Data.Pacing)
+		if err != nil {
+			return err
+		}
+		l.sources[path] = source
+		go source.PlayFile()
+	}
+	return nil
+}
+
+// Closes all opened test media sources.
+func (l *Launcher) closeSources() {
+	for path, flv_stream := range l.sources {
+		flv_stream.CloseFile()
+		delete(l.sources, path)
+	}
+}
+
+// Opens own test media source of publisher.
+// Skips random part of flv file if random offset is requested.
+//
+// param: path string   Test flv file path or synthetic media source.
+func (l *Launcher) openOwnSource(path string) (publisher.MediaSource, error) {
+	if model.IsSyntheticSource(path) {
+		return publisher.NewSyntheticStream(path, &l.Data.Pacing)
+	}
+	flv_stream, err := publisher.NewFlvFile(path, &l.Data.Pacing)
+	if err != nil {
+		return nil, err
+	}
+	if l.Data.RandomOffset {
+		if err := flv_stream.Seek(rand.Float64()); err != nil {
+			flv_stream.FlvFile.Close()
+			return nil, err
+		}
+	}
+	return flv_stream, nil
+}
+
+// Returns test flv file path of stream.
+// Streams are distributed over test flv files in round-robin order.
+//
+// param: index int   Stream index.
+func (l *Launcher) streamSource(index int) string {
+	return l.files[index%len(l.files)]
+}
+
+// Returns test flv file path of scenario phase stream.
+//
+// params: phase *model.Phase   Scenario phase.
+//         index int            Stream index.
+func (l *Launcher) phaseSource(phase *model.Phase, index int) string {
+	if phase.FlvFile != "" {
+		return phase.FlvFile
+	}
+	return l.streamSource(index)
+}
+
+// Scales RTMP clients to the current scenario phase or to the current step
+// of the load profile.
+func (l *Launcher) applyProfile() {
+	elapsed := time.Since(l.started_at)
+	var targets []streamTarget
+	if l.Data.Scenario != nil {
+		index, phase := l.Data.Scenario.PhaseAt(elapsed)
+		if index != l.phase {
+			l.phase = index
+			if phase != nil {
+				log.Printf("Scenario phase %d %s started", index+1, phase.Name)
+			} else {
+				log.Printf("Scenario finished")
+			}
+		}
+		l.client_target = 0
+		if phase != nil {
+			for i, key := range phase.StreamKeys() {
+				targets = append(targets, streamTarget{
+					key:    key,
+					source: l.phaseSource(phase, i),
+				})
+			}
+			l.client_target = phase.ClientCount
+		}
+	} else {
+		// Sweep holds the requested counts of clients.
+		factor := 1.0
+		if l.sweep == nil {
+			factor = l.Data.LoadAt(elapsed)
+		}
+		model_target := model.ScaleCount(l.Data.ModelCount, factor)
+		l.client_target = model.ScaleCount(l.Data.ClientCount, factor)
+		for i := 0; i < model_target; i++ {
+			targets = append(targets, streamTarget{
+				key:    "model" + strconv.Itoa(l.Data.StreamOffset+i+1),
+				source: l.streamSource(i),
+			})
+		}
+	}
+	l.scaleStreams(targets)
+	l.TestReport.SetTarget(len(l.streams), len(l.streams)*l.client_target)
+	l.applySweep(elapsed)
+}
+
+// Applies sweep step at the time elapsed since test start to synthetic
+// media sources. The last step is held after the sweep is finished.
+//
+// param: elapsed time.Duration   Time elapsed since test start.
+func (l *Launcher) applySweep(elapsed time.Duration) {
+	if l.sweep == nil {
+		return
+	}
+	index := l.Data.StepAt(elapsed)
+	if index < 0 || index == l.sweep_step {
+		return
+	}
+	l.sweep_step = index
+	log.Printf("Sweep step %d started: %s %d",
+		index+1, l.Data.SweepParameter, l.sweep_values[index])
+	for _, source := range l.sources {
+		l.setSweepRate(source)
+	}
+	for _, s := range l.streams {
+		if s.own_flv != nil {
+			l.setSweepRate(s.own_flv)
+		}
+	}
+}
+
+// Sets swept parameter value of the current step to synthetic media source.
+// Other sources are not changed.
+//
+// param: source publisher.MediaSource   Test media source.
+func (l *Launcher) setSweepRate(source publisher.MediaSource) {
+	synthetic, ok := source.(*publisher.SyntheticStream)
+	if !ok || l.sweep == nil || l.sweep_step < 0 {
+		return
+	}
+	value := l.sweep_values[l.sweep_step]
+	switch l.Data.SweepParameter {
+	case model.SWEEP_BITRATE:
+		synthetic.SetVideoRate(value, 0)
+	case model.SWEEP_FPS:
+		synthetic.SetVideoRate(0, value)
+	}
+}
+
+// Starts requested streams and stops not requested ones.
+// Scales players of published streams to requested count.
+//
+// param: targets []streamTarget   Requested streams.
+func (l *Launcher) scaleStreams(targets []streamTarget) {
+	requested := make(map[streamTarget]bool)
+	for _, target := range targets {
+		requested[target] = true
+	}
+	running := make(map[streamTarget]bool)
+	streams := l.streams[:0]
+	for _, s := range l.streams {
+		target := streamTarget{key: s.key, source: s.source}
+		if !requested[target] {
+			l.stopStream(s)
+			continue
+		}
+		running[target] = true
+		streams = append(streams, s)
+	}
+	l.streams = streams
+	for _, target := range targets {
+		if running[target] {
+			continue
+		}
+		source := l.sources[target.source]
+		var own_flv publisher.MediaSource
+		if l.independentSources() {
+			own_source, err := l.openOwnSource(target.source)
+			if err != nil {
+				log.Printf("Open flv file ERROR: %s", err.Error())
+				continue
+			}
+			source, own_flv = own_source, own_source
+			l.setSweepRate(own_source)
+		}
+		frames := source.Subscribe(
+			l.Data.FrameQueueSize, l.Data.OverflowPolicy)
+		pub := publisher.NewPublisher(
+			l.Data.ServerURL, target.key, l.events, frames, l.dialer,
+			&l.Data.ReconnectPolicy, l.Data.VerifyIntegrity)
+		l.addClient(pub)
+		l.streams = append(l.streams, &stream{
+			key:       target.key,
+			source:    target.source,
+			publisher: pub,
+			own_flv:   own_flv,
+		})
+		if own_flv != nil {
+			go own_flv.PlayFile()
+		}
+		go pub.Run()
+	}
+	for _, s := range l.streams {
+		if s.published {
+			l.scalePlayers(s, l.client_target)
 		}
 	}
 }
 
-// Starts RTMP players.
+// Starts RTMP players of published stream.
 //
 // param: stream_key string   RTMP stream key.
 func (l *Launcher) startClients(stream_key string) {
-	for i := 0; i < l.Data.ClientCount; i++ {
-		player := player.NewPlayer(l.Data.ServerURL, stream_key, l.handler)
-		l.clients[player.GetID()] = player
+	for _, s := range l.streams {
+		if s.key == stream_key {
+			s.published = true
+			l.scalePlayers(s, l.client_target)
+			return
+		}
+	}
+}
+
+// Handles closed connection of RTMP client.
+// Stream of closed publisher is not published until the publisher
+// publishes it again, so no players are started for it.
+// Lifecycle state of client is kept in its statistic.
+//
+// param: client IRTMPClient   RTMP client with closed connection.
+func (l *Launcher) clientClosed(client IRTMPClient) {
+	for _, s := range l.streams {
+		if s.publisher == client {
+			log.Printf("Publisher of stream %s closed connection", s.key)
+			s.published = false
+			return
+		}
+	}
+}
+
+// Starts or stops stream players to reach requested count.
+// The latest started players are stopped first.
+//
+// params: s     *stream   Started RTMP stream.
+//         count int       Requested count of stream players.
+func (l *Launcher) scalePlayers(s *stream, count int) {
+	for len(s.players) < count {
+		player := player.NewPlayer(
+			l.Data.ServerURL, s.key, l.events, l.dialer,
+			&l.Data.ReconnectPolicy,
+			time.Duration(l.Data.StallThreshold)*time.Millisecond,
+			l.Observer)
+		l.addClient(player)
+		s.players = append(s.players, player)
 		go player.Run()
 	}
+	for len(s.players) > count {
+		last := len(s.players) - 1
+		l.stopClient(s.players[last])
+		s.players = s.players[:last]
+	}
+}
+
+// Stops the stream publisher and all its players.
+//
+// param: s *stream   Started RTMP stream.
+func (l *Launcher) stopStream(s *stream) {
+	l.scalePlayers(s, 0)
+	l.stopClient(s.publisher)
+	if s.own_flv != nil {
+		s.own_flv.CloseFile()
+	}
+}
+
+// Adds RTMP client to clients map and subscribes it to its events.
+//
+// param: client IRTMPClient   RTMP client.
+func (l *Launcher) addClient(client IRTMPClient) {
+	l.clients.Add(client)
+	l.events.Subscribe(client.GetID(), &clientListener{
+		client:    client,
+		control:   l.handler,
+		stop_chan: l.stop_chan,
+	})
+}
+
+// Stops RTMP client and removes it from clients map.
+//
+// param: client IRTMPClient   RTMP client.
+func (l *Launcher) stopClient(client IRTMPClient) {
+	l.events.Unsubscribe(client.GetID())
+	l.clients.Remove(client.GetID())
+	go client.Stop()
 }
 
 // Starts statistics.
+// Clients are cleaned by the test loop, so statistics loop only reads them.
 func (l *Launcher) startStat() {
-	defer l.cleanMap()
 	for {
 		select {
 		case <-l.stop_chan:
@@ -172,28 +522,55 @@ func (l *Launcher) startStat() {
 }
 
 // Writes publishers statistic to log file.
+// Statistic items are snapshots, so clients may update them concurrently.
 func (l *Launcher) makeStat() {
 	client_map := make(map[string]*model.StatItem)
-	for _, client := range l.clients {
+	for _, client := range l.clients.List() {
 		client.UpdateStat()
-		stat_item := client.GetStat()
-		if stat_item.Role == model.ROLE_PUBLISHER {
-			l.publisherAddPlayers(stat_item)
-		}
-		client_map[client.GetID()] = stat_item
+		client_map[client.GetID()] = client.GetStat()
 	}
+	l.publisherAddPlayers(client_map)
 	l.TestReport.UpdateReport(client_map)
+	l.updateSweep()
+	l.result.AddSnapshot(l.TestReport, client_map)
+}
+
+// Adds updated report to result of the current sweep step.
+func (l *Launcher) updateSweep() {
+	if l.sweep == nil {
+		return
+	}
+	elapsed := time.Since(l.started_at)
+	index := l.Data.StepAt(elapsed)
+	if index < 0 {
+		return
+	}
+	value := l.sweep_values[index]
+	l.sweep.Update(l.TestReport.Snapshot(), index, value, elapsed)
+	l.TestReport.SetSweep(int64(index+1), int64(value), l.sweep.Steps())
+}
+
+// Returns copy of test result with report snapshots collected so far.
+func (l *Launcher) Result() *model.TestResult {
+	return l.result.Copy()
 }
 
-// Writes players statistic to log file.
+// Adds players statistic items to publishers of their streams.
 //
-// param: item *model.StatItem   Publisher statistic item.
-func (l *Launcher) publisherAddPlayers(item *model.StatItem) {
-	for _, client := range l.clients {
-		if client.GetStreamKey() == item.StreamID {
-			if client.GetStat().Role == model.ROLE_PLAYER {
-				item.Receivers[client.GetID()] = client.GetStat()
-			}
+// param: items map[string]*model.StatItem   Clients statistic items by ID.
+func (l *Launcher) publisherAddPlayers(items map[string]*model.StatItem) {
+	publishers := make(map[string]*model.StatItem)
+	for _, item := range items {
+		if item.Role == model.ROLE_PUBLISHER {
+			publishers[item.StreamID] = item
+		}
+	}
+	for id, item := range items {
+		if item.Role != model.ROLE_PLAYER {
+			continue
+		}
+		if publisher, ok := publishers[item.StreamID]; ok {
+			publisher.Receivers[id] = item
 		}
 	}
 }
@@ -201,7 +578,7 @@ func (l *Launcher) publisherAddPlayers(item *model.StatItem) {
 // Returns test report.
 func (l *Launcher) updateReport() *model.Report {
 	publisher_map := make(map[string]*model.StatItem)
-	for _, client := range l.clients {
+	for _, client := range l.clients.List() {
 		stat_item := client.GetStat()
 		publisher_map[client.GetID()] = stat_item
 	}
@@ -210,24 +587,21 @@ func (l *Launcher) updateReport() *model.Report {
 }
 
 // Stops stress test.
+// Closes stop channel to stop both test and statistics loops.
+// Test stopped before start does not start, repeated stops do nothing.
 func (l *Launcher) Stop() {
-	for _, client := range l.clients {
+	for _, client := range l.clients.List() {
 		if client != nil {
 			go client.Stop()
 		}
 	}
-	l.stop_chan <- true
+	l.stop_once.Do(func() {
+		close(l.stop_chan)
+	})
 }
 
 // Cleans RTMP clients map.
 func (l *Launcher) cleanMap() {
-	l.clients = make(map[string]IRTMPClient)
-}
-
-// Check any panic.
-func (l *Launcher) onClose() {
-	if r := recover(); r != nil {
-		log.Printf("RECOVER on Launcer %s", r)
-		l.stop_chan <- true
-	}
+	l.clients.Clear()
+	l.streams = nil
 }
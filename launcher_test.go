@@ -0,0 +1,206 @@
+package rtmp_bot
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/rtmptest"
+)
+
+// Light synthetic media of test publishers.
+const TEST_SOURCE = "synthetic:width=64&height=36&bitrate=100000&fps=25&gop=25"
+
+// Max time of waiting for test condition.
+const TEST_TIMEOUT = 15 * time.Second
+
+// Starts test RTMP server with injected faults.
+// Server is closed on test cleanup.
+//
+// params: t      *testing.T        Test.
+//         faults rtmptest.Faults   Faults injected by server.
+func startTestServer(t *testing.T, faults rtmptest.Faults) *rtmptest.Server {
+	server, err := rtmptest.NewServer(rtmptest.DEFAULT_ADDRESS)
+	if err != nil {
+		t.Fatalf("can not start RTMP server: %s", err)
+	}
+	server.SetFaults(faults)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Starts launcher of request against test RTMP server.
+// Launcher is stopped on test cleanup.
+//
+// params: t      *testing.T            Test.
+//         server *rtmptest.Server      Test RTMP server.
+//         data   *model.StartRequest   Stress test requested parameters.
+func startTestLauncher(t *testing.T, server *rtmptest.Server,
+	data *model.StartRequest) *Launcher {
+	data.ServerURL = server.URL
+	if data.FlvFiles == "" {
+		data.FlvFiles = TEST_SOURCE
+	}
+	report := model.NewReport("test")
+	report.ResetReport("test", data.ModelCount, data.ClientCount)
+	launcher := NewLauncher(data, report, "")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := launcher.Start(); err != nil {
+			t.Errorf("can not start test: %s", err)
+		}
+	}()
+	t.Cleanup(func() {
+		launcher.Stop()
+		<-done
+	})
+	return launcher
+}
+
+// Waits until report snapshot satisfies condition.
+// Returns the last taken snapshot.
+//
+// params: t         *testing.T                 Test.
+//         report    *model.Report              Shared test report.
+//         condition func(*model.Report) bool   Awaited condition.
+func waitReport(t *testing.T, report *model.Report,
+	condition func(*model.Report) bool) *model.Report {
+	deadline := time.Now().Add(TEST_TIMEOUT)
+	for {
+		snapshot := report.Snapshot()
+		if condition(snapshot) {
+			return snapshot
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("report condition is not met in %s: %+v",
+				TEST_TIMEOUT, snapshot.Scalars())
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Test against not reachable server is not started.
+func TestLauncherUnreachableServer(t *testing.T) {
+	server := startTestServer(t, rtmptest.Faults{})
+	server.Close()
+	data := &model.StartRequest{
+		ServerURL:   server.URL,
+		FlvFiles:    TEST_SOURCE,
+		ModelCount:  1,
+		ClientCount: 1,
+	}
+	launcher := NewLauncher(data, model.NewReport("test"), "")
+	started := make(chan error, 1)
+	go func() {
+		started <- launcher.Start()
+	}()
+	select {
+	case err := <-started:
+		if err == nil {
+			t.Error("test is started against not reachable server")
+		}
+	case <-time.After(TEST_TIMEOUT):
+		launcher.Stop()
+		t.Fatal("launcher does not report not reachable server")
+	}
+}
+
+// Readers of report and result run concurrently with statistic of
+// connecting, playing and dropped clients.
+func TestLauncherConcurrentReaders(t *testing.T) {
+	server := startTestServer(t, rtmptest.Faults{})
+	launcher := startTestLauncher(t, server, &model.StartRequest{
+		ModelCount:  2,
+		ClientCount: 3,
+	})
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				snapshot := launcher.TestReport.Snapshot()
+				if _, err := json.Marshal(snapshot); err != nil {
+					t.Errorf("can not marshal report: %s", err)
+					return
+				}
+				launcher.Result().Final()
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	waitReport(t, launcher.TestReport, func(r *model.Report) bool {
+		return r.ConnectedModelsCount == 2 && r.ConnectedClientsCount == 6
+	})
+	server.DropStream("model1")
+	waitReport(t, launcher.TestReport, func(r *model.Report) bool {
+		for _, count := range r.Disconnects {
+			if count > 0 {
+				return true
+			}
+		}
+		return r.Reconnects > 0
+	})
+	close(stop)
+	readers.Wait()
+}
+
+// Snapshot of report does not share maps with shared report.
+func TestReportSnapshotIsolated(t *testing.T) {
+	server := startTestServer(t, rtmptest.Faults{})
+	launcher := startTestLauncher(t, server, &model.StartRequest{
+		ModelCount:  1,
+		ClientCount: 1,
+	})
+	snapshot := waitReport(t, launcher.TestReport, func(r *model.Report) bool {
+		return r.ConnectedClientsCount == 1
+	})
+	for key := range snapshot.ClientStates {
+		snapshot.ClientStates[key] = -1
+	}
+	snapshot.LatencyHistogram.Add(1)
+	time.Sleep(1500 * time.Millisecond)
+	fresh := launcher.TestReport.Snapshot()
+	for key, count := range fresh.ClientStates {
+		if count < 0 {
+			t.Errorf("client state %s is changed by snapshot", key)
+		}
+	}
+}
+
+// Sweep steps are measured from test start by statistics loop.
+func TestLauncherSweepSteps(t *testing.T) {
+	server := startTestServer(t, rtmptest.Faults{})
+	launcher := startTestLauncher(t, server, &model.StartRequest{
+		ModelCount:  1,
+		ClientCount: 1,
+		Sweep: model.Sweep{
+			SweepParameter: model.SWEEP_FPS,
+			SweepValues:    "10,20",
+			SweepStepTime:  2,
+		},
+	})
+	report := waitReport(t, launcher.TestReport, func(r *model.Report) bool {
+		return r.SweepStep == 2
+	})
+	if len(report.SweepSteps) != 2 {
+		t.Fatalf("sweep steps are %+v, want 2 steps", report.SweepSteps)
+	}
+	first := report.SweepSteps[0]
+	if first.Value != 10 || first.StartTime > 1 || first.Samples == 0 {
+		t.Errorf("the first sweep step is %+v", first)
+	}
+	if second := report.SweepSteps[1]; second.Value != 20 ||
+		second.StartTime < 2 || second.StartTime > 3 {
+		t.Errorf("the second sweep step is %+v", second)
+	}
+}
@@ -0,0 +1,198 @@
+package media
+
+// FLV video codec identifiers.
+const (
+	CODEC_AVC byte = 7 // H.264 video codec.
+)
+
+// AVC packet types of FLV video tag.
+const (
+	AVC_SEQUENCE_HEADER byte = 0 // AVC decoder configuration record.
+	AVC_NALU            byte = 1 // AVC NAL units.
+	AVC_END_OF_SEQUENCE byte = 2 // AVC end of sequence.
+)
+
+// Size of FLV AVC video tag header:
+// frame type and codec, AVC packet type and composition time.
+const AVC_HEADER_SIZE = 5
+
+// Returns true if FLV video tag data contains AVC NAL units.
+//
+// param: tag []byte   FLV video tag data.
+func IsAVCNalus(tag []byte) bool {
+	return len(tag) > AVC_HEADER_SIZE &&
+		tag[0]&0x0f == CODEC_AVC && tag[1] == AVC_NALU
+}
+
+// FLV video frame types.
+const (
+	FRAME_KEY   byte = 1 // Key frame.
+	FRAME_INTER byte = 2 // Inter frame.
+)
+
+// FLV audio codec identifiers.
+const (
+	SOUND_AAC byte = 10 // AAC audio codec.
+)
+
+// AAC packet types of FLV audio tag.
+const (
+	AAC_SEQUENCE_HEADER byte = 0 // AAC audio specific config.
+	AAC_RAW             byte = 1 // AAC raw frame.
+)
+
+// Returns true if FLV video tag data is key frame.
+//
+// param: tag []byte   FLV video tag data.
+func IsKeyFrame(tag []byte) bool {
+	return len(tag) > 0 && tag[0]>>4 == FRAME_KEY
+}
+
+// Returns true if FLV video tag data is AVC sequence header.
+//
+// param: tag []byte   FLV video tag data.
+func IsAVCSequenceHeader(tag []byte) bool {
+	return len(tag) > 1 &&
+		tag[0]&0x0f == CODEC_AVC && tag[1] == AVC_SEQUENCE_HEADER
+}
+
+// Returns true if FLV audio tag data is AAC sequence header.
+//
+// param: tag []byte   FLV audio tag data.
+func IsAACSequenceHeader(tag []byte) bool {
+	return len(tag) > 1 &&
+		tag[0]>>4 == SOUND_AAC && tag[1] == AAC_SEQUENCE_HEADER
+}
+
+// FLV tag types.
+const (
+	TAG_AUDIO  byte = 8  // Audio tag.
+	TAG_VIDEO  byte = 9  // Video tag.
+	TAG_SCRIPT byte = 18 // Script data tag.
+)
+
+// Other FLV video codec identifiers.
+const (
+	CODEC_H263    byte = 2  // Sorenson H.263 video codec.
+	CODEC_SCREEN  byte = 3  // Screen video codec.
+	CODEC_VP6     byte = 4  // On2 VP6 video codec.
+	CODEC_VP6A    byte = 5  // On2 VP6 with alpha channel video codec.
+	CODEC_SCREEN2 byte = 6  // Screen video version 2 codec.
+	CODEC_HEVC    byte = 12 // H.265 video codec of enhanced FLV.
+)
+
+// Other FLV audio sound formats.
+const (
+	SOUND_PCM        byte = 0  // Linear PCM, platform endian.
+	SOUND_ADPCM      byte = 1  // ADPCM.
+	SOUND_MP3        byte = 2  // MP3.
+	SOUND_PCM_LE     byte = 3  // Linear PCM, little endian.
+	SOUND_NELLYMOSER byte = 6  // Nellymoser.
+	SOUND_G711A      byte = 7  // G.711 A-law.
+	SOUND_G711U      byte = 8  // G.711 mu-law.
+	SOUND_SPEEX      byte = 11 // Speex.
+	SOUND_MP3_8K     byte = 14 // MP3 8 kHz.
+)
+
+// Names of FLV video codecs.
+var VIDEO_CODEC_NAMES = map[byte]string{
+	CODEC_H263:    "h263",
+	CODEC_SCREEN:  "screen",
+	CODEC_VP6:     "vp6",
+	CODEC_VP6A:    "vp6a",
+	CODEC_SCREEN2: "screen2",
+	CODEC_AVC:     "avc",
+	CODEC_HEVC:    "hevc",
+}
+
+// Names of FLV audio sound formats.
+var SOUND_FORMAT_NAMES = map[byte]string{
+	SOUND_PCM:        "pcm",
+	SOUND_ADPCM:      "adpcm",
+	SOUND_MP3:        "mp3",
+	SOUND_PCM_LE:     "pcm_le",
+	SOUND_NELLYMOSER: "nellymoser",
+	SOUND_G711A:      "g711a",
+	SOUND_G711U:      "g711u",
+	SOUND_AAC:        "aac",
+	SOUND_SPEEX:      "speex",
+	SOUND_MP3_8K:     "mp3_8k",
+}
+
+// Parsed header of FLV audio or video tag data.
+type TagInfo struct {
+	Type            byte  // FLV tag type.
+	Codec           byte  // Video codec ID or audio sound format.
+	FrameType       byte  // Video frame type.
+	PacketType      byte  // AVC or AAC packet type.
+	CompositionTime int32 // AVC composition time offset in milliseconds.
+	KeyFrame        bool  // Video key frame with media.
+	SequenceHeader  bool  // AVC or AAC sequence header.
+	Media           bool  // Tag carries audio or video media.
+}
+
+// Returns parsed header of FLV tag data.
+// Tag of other type or without header has only its type.
+//
+// params: tag_type byte     FLV tag type.
+//         tag      []byte   FLV tag data.
+func ParseTag(tag_type byte, tag []byte) TagInfo {
+	info := TagInfo{Type: tag_type}
+	if len(tag) == 0 {
+		return info
+	}
+	switch tag_type {
+	case TAG_VIDEO:
+		info.FrameType = tag[0] >> 4
+		info.Codec = tag[0] & 0x0f
+		info.Media = true
+		if info.Codec == CODEC_AVC || info.Codec == CODEC_HEVC {
+			if len(tag) < AVC_HEADER_SIZE {
+				info.Media = false
+				return info
+			}
+			info.PacketType = tag[1]
+			// Composition time is signed 24 bit value.
+			info.CompositionTime = int32(uint32(tag[2])<<24|
+				uint32(tag[3])<<16|uint32(tag[4])<<8) >> 8
+			info.SequenceHeader = info.PacketType == AVC_SEQUENCE_HEADER
+			info.Media = info.PacketType == AVC_NALU
+		}
+		info.KeyFrame = info.Media && info.FrameType == FRAME_KEY
+	case TAG_AUDIO:
+		info.Codec = tag[0] >> 4
+		info.Media = true
+		if info.Codec == SOUND_AAC {
+			if len(tag) < 2 {
+				info.Media = false
+				return info
+			}
+			info.PacketType = tag[1]
+			info.SequenceHeader = info.PacketType == AAC_SEQUENCE_HEADER
+			info.Media = info.PacketType == AAC_RAW
+		}
+	}
+	return info
+}
+
+// Returns true if decoding of tag codec needs sequence header.
+func (t TagInfo) NeedsSequenceHeader() bool {
+	switch t.Type {
+	case TAG_VIDEO:
+		return t.Codec == CODEC_AVC || t.Codec == CODEC_HEVC
+	case TAG_AUDIO:
+		return t.Codec == SOUND_AAC
+	}
+	return false
+}
+
+// Returns name of tag codec or empty string for unknown codec.
+func (t TagInfo) CodecName() string {
+	switch t.Type {
+	case TAG_VIDEO:
+		return VIDEO_CODEC_NAMES[t.Codec]
+	case TAG_AUDIO:
+		return SOUND_FORMAT_NAMES[t.Codec]
+	}
+	return ""
+}
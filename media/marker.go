@@ -0,0 +1,107 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"strconv"
+	"time"
+)
+
+// Separator of marker fields.
+const MARKER_SEPARATOR = ':'
+
+// Wall clock marker embedded by publishers into video stream.
+// Marker is encoded as ASCII digits, so it never needs emulation
+// prevention inside of SEI message.
+// In integrity verification mode marker also carries frame sequence
+// number, timestamp and checksum.
+type Marker struct {
+	Time      int64  // Publish wall clock time in milliseconds.
+	Integrity bool   // Marker has integrity fields.
+	Seq       uint64 // Sequence number of frame from 1.
+	Timestamp uint32 // Published timestamp of frame in milliseconds.
+	Checksum  uint32 // CRC-32 of frame tag data without marker.
+}
+
+// Returns new marker of time.
+//
+// param: t time.Time   Marker time.
+func NewMarker(t time.Time) *Marker {
+	return &Marker{
+		Time: t.UnixNano() / int64(time.Millisecond),
+	}
+}
+
+// Sets integrity fields of frame.
+//
+// params: seq       uint64   Sequence number of frame.
+//         timestamp uint32   Published timestamp of frame.
+//         tag       []byte   FLV video tag data without marker.
+func (m *Marker) SetIntegrity(seq uint64, timestamp uint32, tag []byte) {
+	m.Integrity = true
+	m.Seq = seq
+	m.Timestamp = timestamp
+	m.Checksum = FrameChecksum(tag)
+}
+
+// Returns encoded marker.
+func (m *Marker) Encode() []byte {
+	encoded := strconv.AppendInt(nil, m.Time, 10)
+	if m.Integrity {
+		encoded = append(encoded, MARKER_SEPARATOR)
+		encoded = strconv.AppendUint(encoded, m.Seq, 10)
+		encoded = append(encoded, MARKER_SEPARATOR)
+		encoded = strconv.AppendUint(encoded, uint64(m.Timestamp), 10)
+		encoded = append(encoded, MARKER_SEPARATOR)
+		encoded = strconv.AppendUint(encoded, uint64(m.Checksum), 10)
+	}
+	return encoded
+}
+
+// Returns decoded marker.
+//
+// param: payload []byte   Encoded marker.
+func DecodeMarker(payload []byte) (*Marker, error) {
+	fields := bytes.Split(payload, []byte{MARKER_SEPARATOR})
+	if len(fields) != 1 && len(fields) != 4 {
+		return nil, errors.New("invalid count of marker fields")
+	}
+	ms, err := strconv.ParseInt(string(fields[0]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	marker := &Marker{Time: ms}
+	if len(fields) == 1 {
+		return marker, nil
+	}
+	if marker.Seq, err = strconv.ParseUint(string(fields[1]), 10, 64); err != nil {
+		return nil, err
+	}
+	timestamp, err := strconv.ParseUint(string(fields[2]), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := strconv.ParseUint(string(fields[3]), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	marker.Integrity = true
+	marker.Timestamp = uint32(timestamp)
+	marker.Checksum = uint32(checksum)
+	return marker, nil
+}
+
+// Returns milliseconds passed from marker time till t.
+//
+// param: t time.Time   Receive time.
+func (m *Marker) Latency(t time.Time) int64 {
+	return t.UnixNano()/int64(time.Millisecond) - m.Time
+}
+
+// Returns CRC-32 checksum of frame tag data.
+//
+// param: tag []byte   FLV tag data.
+func FrameChecksum(tag []byte) uint32 {
+	return crc32.ChecksumIEEE(tag)
+}
@@ -0,0 +1,85 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Name of FLV metadata script data.
+const METADATA_NAME = "onMetaData"
+
+// AMF0 markers of metadata values.
+const (
+	AMF0_NUMBER     byte = 0x00 // Number marker.
+	AMF0_BOOLEAN    byte = 0x01 // Boolean marker.
+	AMF0_STRING     byte = 0x02 // String marker.
+	AMF0_ECMA_ARRAY byte = 0x08 // ECMA array marker.
+	AMF0_OBJECT_END byte = 0x09 // Object end marker.
+)
+
+// Property of FLV metadata.
+type MetadataProperty struct {
+	Name  string      // Property name.
+	Value interface{} // Number as float64 or int, bool or string value.
+}
+
+// Returns FLV script tag data with onMetaData of properties.
+// Properties are encoded in the given order, values of other types
+// are skipped.
+//
+// param: properties []MetadataProperty   Metadata properties.
+func NewMetadata(properties []MetadataProperty) []byte {
+	var buf bytes.Buffer
+	writeAMF0String(&buf, METADATA_NAME)
+	var body bytes.Buffer
+	count := uint32(0)
+	for _, property := range properties {
+		var value bytes.Buffer
+		switch v := property.Value.(type) {
+		case float64:
+			writeAMF0Number(&value, v)
+		case int:
+			writeAMF0Number(&value, float64(v))
+		case bool:
+			value.WriteByte(AMF0_BOOLEAN)
+			if v {
+				value.WriteByte(1)
+			} else {
+				value.WriteByte(0)
+			}
+		case string:
+			writeAMF0String(&value, v)
+		default:
+			continue
+		}
+		binary.Write(&body, binary.BigEndian, uint16(len(property.Name)))
+		body.WriteString(property.Name)
+		body.Write(value.Bytes())
+		count++
+	}
+	buf.WriteByte(AMF0_ECMA_ARRAY)
+	binary.Write(&buf, binary.BigEndian, count)
+	buf.Write(body.Bytes())
+	buf.Write([]byte{0, 0, AMF0_OBJECT_END})
+	return buf.Bytes()
+}
+
+// Writes AMF0 number.
+//
+// params: buf   *bytes.Buffer   Output buffer.
+//         value float64         Number value.
+func writeAMF0Number(buf *bytes.Buffer, value float64) {
+	buf.WriteByte(AMF0_NUMBER)
+	binary.Write(buf, binary.BigEndian, math.Float64bits(value))
+}
+
+// Writes AMF0 string.
+//
+// params: buf   *bytes.Buffer   Output buffer.
+//         value string          String value shorter than 64 KB.
+func writeAMF0String(buf *bytes.Buffer, value string) {
+	buf.WriteByte(AMF0_STRING)
+	binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	buf.WriteString(value)
+}
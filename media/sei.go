@@ -0,0 +1,261 @@
+package media
+
+import "bytes"
+
+// H.264 NAL unit types.
+const (
+	NALU_SLICE     byte = 1    // Coded slice of a non-IDR picture.
+	NALU_IDR       byte = 5    // Coded slice of an IDR picture.
+	NALU_SEI       byte = 6    // Supplemental enhancement information.
+	NALU_SPS       byte = 7    // Sequence parameter set.
+	NALU_PPS       byte = 8    // Picture parameter set.
+	NALU_FILLER    byte = 12   // Filler data.
+	NALU_TYPE_MASK      = 0x1f // Mask of NAL unit type in header byte.
+)
+
+// SEI payload type of user data unregistered message.
+const SEI_USER_DATA_UNREGISTERED = 5
+
+// Default size of NAL unit length field in FLV AVC video tags.
+// It is used when AVC sequence header is unknown.
+const NALU_LENGTH_SIZE = 4
+
+// Offset of lengthSizeMinusOne byte in FLV AVC sequence header tag.
+const NALU_LENGTH_SIZE_OFFSET = AVC_HEADER_SIZE + 4
+
+// Returns size of NAL unit length field declared by
+// AVCDecoderConfigurationRecord of FLV AVC sequence header tag.
+// Returns NALU_LENGTH_SIZE if the tag is not valid sequence header.
+//
+// param: tag []byte   FLV video tag data.
+func NaluLengthSize(tag []byte) int {
+	if !IsAVCSequenceHeader(tag) || len(tag) <= NALU_LENGTH_SIZE_OFFSET {
+		return NALU_LENGTH_SIZE
+	}
+	if size := int(tag[NALU_LENGTH_SIZE_OFFSET]&0x03) + 1; size != 3 {
+		return size
+	}
+	return NALU_LENGTH_SIZE
+}
+
+// Reads big endian NAL unit length field.
+//
+// params: data        []byte   Data starting with length field.
+//         length_size int      Size of length field.
+func readNaluLength(data []byte, length_size int) int {
+	size := 0
+	for _, b := range data[:length_size] {
+		size = size<<8 | int(b)
+	}
+	return size
+}
+
+// Appends big endian NAL unit length field to data.
+//
+// params: data        []byte   Destination data.
+//         size        int      NAL unit size.
+//         length_size int      Size of length field.
+func appendNaluLength(data []byte, size int, length_size int) []byte {
+	for i := length_size - 1; i >= 0; i-- {
+		data = append(data, byte(size>>(8*uint(i))))
+	}
+	return data
+}
+
+// UUID of bot SEI messages.
+// The UUID has no zero bytes, so it never needs emulation prevention.
+var BOT_SEI_UUID = []byte{
+	0x72, 0x74, 0x6d, 0x70, 0x2d, 0x62, 0x6f, 0x74,
+	0xa1, 0x5e, 0x1c, 0xd3, 0x9b, 0x47, 0x86, 0xe2,
+}
+
+// Returns SEI NAL unit with bot user data unregistered message.
+//
+// param: payload []byte   Message payload.
+func NewBotSEI(payload []byte) []byte {
+	var rbsp bytes.Buffer
+	size := len(BOT_SEI_UUID) + len(payload)
+	rbsp.WriteByte(SEI_USER_DATA_UNREGISTERED)
+	for ; size >= 0xff; size -= 0xff {
+		rbsp.WriteByte(0xff)
+	}
+	rbsp.WriteByte(byte(size))
+	rbsp.Write(BOT_SEI_UUID)
+	rbsp.Write(payload)
+	rbsp.WriteByte(0x80)
+	return append([]byte{NALU_SEI}, EscapeRBSP(rbsp.Bytes())...)
+}
+
+// Returns copy of FLV AVC video tag data with bot SEI message
+// inserted before the first NAL unit.
+// Returns false if the tag is not AVC NAL units tag
+// or the SEI message does not fit the length field.
+//
+// params: tag         []byte   FLV video tag data.
+//         payload     []byte   SEI message payload.
+//         length_size int      Size of NAL unit length field.
+func InsertBotSEI(tag []byte, payload []byte, length_size int) ([]byte, bool) {
+	if !IsAVCNalus(tag) {
+		return nil, false
+	}
+	sei := NewBotSEI(payload)
+	if length_size < NALU_LENGTH_SIZE && len(sei) >= 1<<(8*uint(length_size)) {
+		return nil, false
+	}
+	data := make([]byte, 0, len(tag)+length_size+len(sei))
+	data = append(data, tag[:AVC_HEADER_SIZE]...)
+	data = appendNaluLength(data, len(sei), length_size)
+	data = append(data, sei...)
+	return append(data, tag[AVC_HEADER_SIZE:]...), true
+}
+
+// Returns payload of the first bot SEI message of FLV AVC video tag.
+// Returns false if the tag has no bot SEI message.
+//
+// params: tag         []byte   FLV video tag data.
+//         length_size int      Size of NAL unit length field.
+func FindBotSEI(tag []byte, length_size int) ([]byte, bool) {
+	if !IsAVCNalus(tag) {
+		return nil, false
+	}
+	var found []byte
+	ForEachNalu(tag[AVC_HEADER_SIZE:], length_size, func(nalu []byte) bool {
+		if nalu[0]&NALU_TYPE_MASK != NALU_SEI {
+			return true
+		}
+		found = parseBotSEI(UnescapeRBSP(nalu[1:]))
+		return found == nil
+	})
+	return found, found != nil
+}
+
+// Returns FLV AVC video tag data without the first bot SEI message.
+// Returns the tag itself if it has no bot SEI message.
+//
+// params: tag         []byte   FLV video tag data.
+//         length_size int      Size of NAL unit length field.
+func RemoveBotSEI(tag []byte, length_size int) []byte {
+	if !IsAVCNalus(tag) {
+		return tag
+	}
+	data := tag[AVC_HEADER_SIZE:]
+	for offset := 0; len(data)-offset >= length_size; {
+		size := readNaluLength(data[offset:], length_size)
+		start := offset + length_size
+		if size <= 0 || size > len(data)-start {
+			break
+		}
+		nalu := data[start : start+size]
+		if nalu[0]&NALU_TYPE_MASK == NALU_SEI &&
+			parseBotSEI(UnescapeRBSP(nalu[1:])) != nil {
+			removed := make([]byte, 0, len(tag)-length_size-size)
+			removed = append(removed, tag[:AVC_HEADER_SIZE+offset]...)
+			return append(removed, data[start+size:]...)
+		}
+		offset = start + size
+	}
+	return tag
+}
+
+// Calls callback for each NAL unit of AVC NAL units data.
+// Stops if the callback returns false.
+//
+// params: data        []byte                NAL units with length prefixes.
+//         length_size int                   Size of NAL unit length field.
+//         callback    func([]byte) bool     NAL unit callback.
+func ForEachNalu(
+	data []byte, length_size int, callback func(nalu []byte) bool) {
+	for len(data) >= length_size {
+		size := readNaluLength(data, length_size)
+		data = data[length_size:]
+		if size <= 0 || size > len(data) {
+			return
+		}
+		if !callback(data[:size]) {
+			return
+		}
+		data = data[size:]
+	}
+}
+
+// Returns bot message payload of SEI RBSP or nil.
+//
+// param: rbsp []byte   SEI RBSP data.
+func parseBotSEI(rbsp []byte) []byte {
+	for len(rbsp) > 1 && rbsp[0] != 0x80 {
+		payload_type, n := readSEIValue(rbsp)
+		rbsp = rbsp[n:]
+		payload_size, n := readSEIValue(rbsp)
+		rbsp = rbsp[n:]
+		if payload_size > len(rbsp) {
+			return nil
+		}
+		payload := rbsp[:payload_size]
+		rbsp = rbsp[payload_size:]
+		if payload_type == SEI_USER_DATA_UNREGISTERED &&
+			bytes.HasPrefix(payload, BOT_SEI_UUID) {
+			return payload[len(BOT_SEI_UUID):]
+		}
+	}
+	return nil
+}
+
+// Reads 0xff coded SEI payload type or size.
+// Returns value and count of read bytes.
+//
+// param: data []byte   SEI RBSP data.
+func readSEIValue(data []byte) (int, int) {
+	value := 0
+	for i, b := range data {
+		value += int(b)
+		if b != 0xff {
+			return value, i + 1
+		}
+	}
+	return value, len(data)
+}
+
+// Inserts emulation prevention bytes into RBSP.
+//
+// param: rbsp []byte   Raw byte sequence payload.
+func EscapeRBSP(rbsp []byte) []byte {
+	escaped := make([]byte, 0, len(rbsp))
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 3 {
+			escaped = append(escaped, 3)
+			zeros = 0
+		}
+		escaped = append(escaped, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return escaped
+}
+
+// Removes emulation prevention bytes from NAL unit payload.
+//
+// param: data []byte   Escaped NAL unit payload.
+func UnescapeRBSP(data []byte) []byte {
+	if !bytes.Contains(data, []byte{0, 0, 3}) {
+		return data
+	}
+	rbsp := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros == 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		rbsp = append(rbsp, b)
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+	return rbsp
+}
@@ -0,0 +1,396 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// H.264 parameters of synthetic video.
+const (
+	AVC_PROFILE_BASELINE   byte = 66   // Baseline profile.
+	AVC_CONSTRAINT_FLAGS   byte = 0xc0 // Baseline and main profiles constraints.
+	SYNTHETIC_FRAME_BITS        = 16   // Bits of slice frame number.
+	SYNTHETIC_FRAME_NUMBER      = 1 << SYNTHETIC_FRAME_BITS
+)
+
+// Samples of single AAC frame.
+const AAC_FRAME_SAMPLES = 1024
+
+// AAC low complexity audio object type.
+const AAC_OBJECT_LC = 2
+
+// Sample rates of AAC sampling frequency indexes.
+var AAC_SAMPLE_RATES = []int{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000,
+	22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// H.264 level limits: level, frame size and processing rate in macroblocks.
+var avc_levels = []struct {
+	level      byte
+	frame_size int
+	mb_rate    int
+}{
+	{30, 1620, 40500},
+	{31, 3600, 108000},
+	{32, 5120, 216000},
+	{40, 8192, 245760},
+	{42, 8704, 522240},
+	{50, 22080, 589824},
+	{51, 36864, 983040},
+	{52, 36864, 2073600},
+}
+
+// Returns AAC sampling frequency index of sample rate.
+// Returns false if the rate has no index.
+//
+// param: rate int   Sample rate in Hz.
+func AACSampleRateIndex(rate int) (byte, bool) {
+	for i, r := range AAC_SAMPLE_RATES {
+		if r == rate {
+			return byte(i), true
+		}
+	}
+	return 0, false
+}
+
+// Writer of bit strings of H.264 and AAC syntax elements.
+type bitWriter struct {
+	data []byte // Written bytes.
+	bits uint   // Count of written bits.
+}
+
+// Writes the lowest bits of value, the highest bit first.
+//
+// params: value uint32   Written value.
+//         count uint     Count of bits.
+func (w *bitWriter) writeBits(value uint32, count uint) {
+	for i := count; i > 0; i-- {
+		if w.bits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if value>>(i-1)&1 == 1 {
+			w.data[len(w.data)-1] |= 0x80 >> (w.bits % 8)
+		}
+		w.bits++
+	}
+}
+
+// Writes unsigned Exp-Golomb code.
+//
+// param: value uint32   Written value.
+func (w *bitWriter) writeUE(value uint32) {
+	code := value + 1
+	size := uint(0)
+	for c := code; c > 1; c >>= 1 {
+		size++
+	}
+	w.writeBits(0, size)
+	w.writeBits(code, size+1)
+}
+
+// Writes signed Exp-Golomb code.
+//
+// param: value int32   Written value.
+func (w *bitWriter) writeSE(value int32) {
+	if value > 0 {
+		w.writeUE(uint32(2*value - 1))
+	} else {
+		w.writeUE(uint32(-2 * value))
+	}
+}
+
+// Writes stop bit and aligns writer to byte.
+func (w *bitWriter) writeTrailingBits() {
+	w.writeBits(1, 1)
+	w.bits = uint(len(w.data)) * 8
+}
+
+// Generator of synthetic H.264 video.
+// Intra frames are flat gray pictures of DC predicted macroblocks,
+// inter frames skip all macroblocks. Frames are padded by filler data
+// up to size of requested bitrate, so every frame is decodable and
+// the stream has exact bitrate.
+type SyntheticVideo struct {
+	width      int    // Picture width in pixels.
+	height     int    // Picture height in pixels.
+	mb_width   int    // Picture width in macroblocks.
+	mb_height  int    // Picture height in macroblocks.
+	frame_size int    // Size of FLV tag data of frame in bytes.
+	sps        []byte // Sequence parameter set NAL unit.
+	pps        []byte // Picture parameter set NAL unit.
+	frame_num  uint32 // Frame number of the latest slice.
+	idr_pic_id uint32 // Identifier of the latest IDR picture.
+}
+
+// Returns new synthetic video generator.
+//
+// params: width   int   Even picture width in pixels.
+//         height  int   Even picture height in pixels.
+//         bitrate int   Video bitrate in bits per second.
+//         fps     int   Frame rate.
+func NewSyntheticVideo(width, height, bitrate, fps int) *SyntheticVideo {
+	v := &SyntheticVideo{
+		width:      width,
+		height:     height,
+		mb_width:   (width + 15) / 16,
+		mb_height:  (height + 15) / 16,
+		frame_size: bitrate / 8 / fps,
+	}
+	v.sps = v.newSPS(fps)
+	v.pps = v.newPPS()
+	return v
+}
+
+// Changes bitrate and frame rate of the next frames.
+// Returns true if sequence parameter set is changed, so the new
+// sequence header and key frame should be sent.
+//
+// params: bitrate int   Video bitrate in bits per second.
+//         fps     int   Frame rate.
+func (v *SyntheticVideo) SetRate(bitrate int, fps int) bool {
+	v.frame_size = bitrate / 8 / fps
+	sps := v.newSPS(fps)
+	if bytes.Equal(sps, v.sps) {
+		return false
+	}
+	v.sps = sps
+	return true
+}
+
+// Returns H.264 level of picture size and frame rate.
+//
+// param: fps int   Frame rate.
+func (v *SyntheticVideo) level(fps int) byte {
+	frame_size := v.mb_width * v.mb_height
+	for _, l := range avc_levels {
+		if frame_size <= l.frame_size && frame_size*fps <= l.mb_rate {
+			return l.level
+		}
+	}
+	return avc_levels[len(avc_levels)-1].level
+}
+
+// Returns sequence parameter set NAL unit.
+//
+// param: fps int   Frame rate.
+func (v *SyntheticVideo) newSPS(fps int) []byte {
+	w := &bitWriter{}
+	w.writeBits(uint32(AVC_PROFILE_BASELINE), 8)
+	w.writeBits(uint32(AVC_CONSTRAINT_FLAGS), 8)
+	w.writeBits(uint32(v.level(fps)), 8)
+	w.writeUE(0)                        // seq_parameter_set_id
+	w.writeUE(SYNTHETIC_FRAME_BITS - 4) // log2_max_frame_num_minus4
+	w.writeUE(2)                        // pic_order_cnt_type
+	w.writeUE(1)                        // max_num_ref_frames
+	w.writeBits(0, 1)                   // gaps_in_frame_num_value_allowed_flag
+	w.writeUE(uint32(v.mb_width - 1))   // pic_width_in_mbs_minus1
+	w.writeUE(uint32(v.mb_height - 1))  // pic_height_in_map_units_minus1
+	w.writeBits(1, 1)                   // frame_mbs_only_flag
+	w.writeBits(1, 1)                   // direct_8x8_inference_flag
+	crop_right := (v.mb_width*16 - v.width) / 2
+	crop_bottom := (v.mb_height*16 - v.height) / 2
+	if crop_right > 0 || crop_bottom > 0 {
+		w.writeBits(1, 1) // frame_cropping_flag
+		w.writeUE(0)
+		w.writeUE(uint32(crop_right))
+		w.writeUE(0)
+		w.writeUE(uint32(crop_bottom))
+	} else {
+		w.writeBits(0, 1)
+	}
+	w.writeBits(0, 1) // vui_parameters_present_flag
+	w.writeTrailingBits()
+	return append([]byte{3<<5 | NALU_SPS}, EscapeRBSP(w.data)...)
+}
+
+// Returns picture parameter set NAL unit.
+func (v *SyntheticVideo) newPPS() []byte {
+	w := &bitWriter{}
+	w.writeUE(0)      // pic_parameter_set_id
+	w.writeUE(0)      // seq_parameter_set_id
+	w.writeBits(0, 1) // entropy_coding_mode_flag
+	w.writeBits(0, 1) // bottom_field_pic_order_in_frame_present_flag
+	w.writeUE(0)      // num_slice_groups_minus1
+	w.writeUE(0)      // num_ref_idx_l0_default_active_minus1
+	w.writeUE(0)      // num_ref_idx_l1_default_active_minus1
+	w.writeBits(0, 1) // weighted_pred_flag
+	w.writeBits(0, 2) // weighted_bipred_idc
+	w.writeSE(0)      // pic_init_qp_minus26
+	w.writeSE(0)      // pic_init_qs_minus26
+	w.writeSE(0)      // chroma_qp_index_offset
+	w.writeBits(1, 1) // deblocking_filter_control_present_flag
+	w.writeBits(0, 1) // constrained_intra_pred_flag
+	w.writeBits(0, 1) // redundant_pic_cnt_present_flag
+	w.writeTrailingBits()
+	return append([]byte{3<<5 | NALU_PPS}, EscapeRBSP(w.data)...)
+}
+
+// Returns FLV video tag data with AVC decoder configuration record.
+func (v *SyntheticVideo) SequenceHeader() []byte {
+	data := []byte{
+		FRAME_KEY<<4 | CODEC_AVC, AVC_SEQUENCE_HEADER, 0, 0, 0,
+		1, v.sps[1], v.sps[2], v.sps[3], 0xfc | (NALU_LENGTH_SIZE - 1),
+		0xe0 | 1,
+	}
+	data = appendUint16(data, len(v.sps))
+	data = append(data, v.sps...)
+	data = append(data, 1)
+	data = appendUint16(data, len(v.pps))
+	return append(data, v.pps...)
+}
+
+// Returns FLV video tag data of the next frame.
+// Key frame starts new group of pictures.
+//
+// param: key bool   Frame is key frame.
+func (v *SyntheticVideo) Frame(key bool) []byte {
+	frame_type := FRAME_INTER
+	var slice []byte
+	if key {
+		frame_type = FRAME_KEY
+		v.frame_num = 0
+		v.idr_pic_id = (v.idr_pic_id + 1) % 2
+		slice = v.newIDRSlice()
+	} else {
+		v.frame_num = (v.frame_num + 1) % SYNTHETIC_FRAME_NUMBER
+		slice = v.newSkipSlice()
+	}
+	data := []byte{frame_type<<4 | CODEC_AVC, AVC_NALU, 0, 0, 0}
+	data = appendNalu(data, slice)
+	// Filler data NAL unit has header byte, filler bytes and stop bit.
+	if filler := v.frame_size - len(data) - NALU_LENGTH_SIZE - 2; filler > 0 {
+		nalu := make([]byte, filler+2)
+		nalu[0] = NALU_FILLER
+		for i := 1; i <= filler; i++ {
+			nalu[i] = 0xff
+		}
+		nalu[filler+1] = 0x80
+		data = appendNalu(data, nalu)
+	}
+	return data
+}
+
+// Returns IDR slice NAL unit of flat gray picture.
+func (v *SyntheticVideo) newIDRSlice() []byte {
+	w := &bitWriter{}
+	w.writeUE(0)                         // first_mb_in_slice
+	w.writeUE(7)                         // slice_type, I
+	w.writeUE(0)                         // pic_parameter_set_id
+	w.writeBits(0, SYNTHETIC_FRAME_BITS) // frame_num
+	w.writeUE(v.idr_pic_id)              // idr_pic_id
+	w.writeBits(0, 1)                    // no_output_of_prior_pics_flag
+	w.writeBits(0, 1)                    // long_term_reference_flag
+	w.writeSE(0)                         // slice_qp_delta
+	w.writeUE(1)                         // disable_deblocking_filter_idc
+	for i := 0; i < v.mb_width*v.mb_height; i++ {
+		w.writeUE(3)      // mb_type, I_16x16 with DC prediction and no coefficients
+		w.writeUE(0)      // intra_chroma_pred_mode, DC
+		w.writeSE(0)      // mb_qp_delta
+		w.writeBits(1, 1) // coeff_token of empty luma DC block
+	}
+	w.writeTrailingBits()
+	return append([]byte{3<<5 | NALU_IDR}, EscapeRBSP(w.data)...)
+}
+
+// Returns inter slice NAL unit skipping all macroblocks.
+func (v *SyntheticVideo) newSkipSlice() []byte {
+	w := &bitWriter{}
+	w.writeUE(0)                                   // first_mb_in_slice
+	w.writeUE(5)                                   // slice_type, P
+	w.writeUE(0)                                   // pic_parameter_set_id
+	w.writeBits(v.frame_num, SYNTHETIC_FRAME_BITS) // frame_num
+	w.writeBits(0, 1)                              // num_ref_idx_active_override_flag
+	w.writeBits(0, 1)                              // ref_pic_list_modification_flag_l0
+	w.writeBits(0, 1)                              // adaptive_ref_pic_marking_mode_flag
+	w.writeSE(0)                                   // slice_qp_delta
+	w.writeUE(1)                                   // disable_deblocking_filter_idc
+	w.writeUE(uint32(v.mb_width * v.mb_height))    // mb_skip_run
+	w.writeTrailingBits()
+	return append([]byte{2<<5 | NALU_SLICE}, EscapeRBSP(w.data)...)
+}
+
+// Generator of synthetic AAC silence.
+type SyntheticAudio struct {
+	config []byte // Audio specific config.
+	frame  []byte // Raw data block of silence.
+}
+
+// Returns new synthetic audio generator.
+//
+// params: rate     int   Sample rate with AAC sampling frequency index.
+//         channels int   Count of channels, 1 or 2.
+func NewSyntheticAudio(rate int, channels int) *SyntheticAudio {
+	index, _ := AACSampleRateIndex(rate)
+	config := &bitWriter{}
+	config.writeBits(AAC_OBJECT_LC, 5)
+	config.writeBits(uint32(index), 4)
+	config.writeBits(uint32(channels), 4)
+	config.writeBits(0, 3) // frame length, core coder and extension flags
+	frame := &bitWriter{}
+	if channels == 1 {
+		frame.writeBits(0, 3) // single channel element
+		frame.writeBits(0, 4) // element_instance_tag
+		writeSilentICS(frame)
+	} else {
+		frame.writeBits(1, 3) // channel pair element
+		frame.writeBits(0, 4) // element_instance_tag
+		frame.writeBits(0, 1) // common_window
+		writeSilentICS(frame)
+		writeSilentICS(frame)
+	}
+	frame.writeBits(7, 3) // end element
+	return &SyntheticAudio{
+		config: config.data,
+		frame:  frame.data,
+	}
+}
+
+// Writes individual channel stream without spectral data.
+//
+// param: w *bitWriter   Writer of raw data block.
+func writeSilentICS(w *bitWriter) {
+	w.writeBits(0, 8) // global_gain
+	w.writeBits(0, 1) // ics_reserved_bit
+	w.writeBits(0, 2) // window_sequence, only long
+	w.writeBits(0, 1) // window_shape
+	w.writeBits(0, 6) // max_sfb
+	w.writeBits(0, 1) // predictor_data_present
+	w.writeBits(0, 1) // pulse_data_present
+	w.writeBits(0, 1) // tns_data_present
+	w.writeBits(0, 1) // gain_control_data_present
+}
+
+// Returns FLV audio tag header byte of AAC.
+func (a *SyntheticAudio) soundHeader() byte {
+	// AAC is always marked as 44 kHz 16 bit stereo.
+	return SOUND_AAC<<4 | 3<<2 | 1<<1 | 1
+}
+
+// Returns FLV audio tag data with AAC audio specific config.
+func (a *SyntheticAudio) SequenceHeader() []byte {
+	return append([]byte{a.soundHeader(), AAC_SEQUENCE_HEADER}, a.config...)
+}
+
+// Returns FLV audio tag data of silence frame.
+func (a *SyntheticAudio) Frame() []byte {
+	return append([]byte{a.soundHeader(), AAC_RAW}, a.frame...)
+}
+
+// Appends NAL unit with length prefix.
+//
+// params: data []byte   AVC NAL units data.
+//         nalu []byte   Appended NAL unit.
+func appendNalu(data []byte, nalu []byte) []byte {
+	length := make([]byte, NALU_LENGTH_SIZE)
+	binary.BigEndian.PutUint32(length, uint32(len(nalu)))
+	return append(append(data, length...), nalu...)
+}
+
+// Appends 16 bit big endian value.
+//
+// params: data  []byte   Data.
+//         value int      Appended value.
+func appendUint16(data []byte, value int) []byte {
+	return append(data, byte(value>>8), byte(value))
+}
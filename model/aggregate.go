@@ -0,0 +1,205 @@
+package model
+
+// Weighted mean of agents report values.
+type weightedMean struct {
+	sum    int64 // Sum of weighted values.
+	weight int64 // Sum of weights.
+}
+
+// Adds value of agent report.
+//
+// params: value  int64   Report value.
+//         weight int64   Weight of value.
+func (m *weightedMean) add(value int64, weight int64) {
+	m.sum += value * weight
+	m.weight += weight
+}
+
+// Returns weighted mean, 0 without weights.
+func (m *weightedMean) mean() int64 {
+	if m.weight == 0 {
+		return 0
+	}
+	return m.sum / m.weight
+}
+
+// Aggregates reports of distributed test agents into the report.
+// Counts are summed, averages are weighted by connected clients of
+// agents, maximal values and percentiles are maximal of agents.
+// Latency percentiles are taken from merged latency histograms.
+// Test ID and metric prefix of the report are kept, sweep steps
+// results are kept by agents.
+//
+// params: reports     []*Report   Reports of live agents.
+//         dead_agents int         Count of dead agents.
+func (r *Report) Aggregate(reports []*Report, dead_agents int) {
+	var model_fps, model_bitrate, audio_sends, video_sends weightedMean
+	var published_time, model_startup, send_jitter weightedMean
+	var client_fps, client_bitrate, audio_received, video_received weightedMean
+	var played_time, client_startup, keyframe_interval, gop_size weightedMean
+	var rebuffer_sum float64
+	r.lock()
+	defer r.unlock()
+	*r = Report{
+		mutex:           r.mutex,
+		TestId:          r.TestId,
+		MetricPrefix:    r.MetricPrefix,
+		StartTime:       r.StartTime,
+		LiveAgents:      int64(len(reports)),
+		DeadAgents:      int64(dead_agents),
+		StreamIntegrity: make(map[string]Integrity),
+		SequenceHeaders: countsOf(sequenceHeaderKeys()),
+		PublisherPhases: make(map[string]Summary),
+		PlayerPhases:    make(map[string]Summary),
+		Disconnects:     countsOf(DISCONNECT_CAUSES),
+		ClientStates:    countsOf(CLIENT_STATES),
+		Clients:         make(map[string]*StatItem),
+	}
+	for _, report := range reports {
+		if report.StartTime < r.StartTime {
+			r.StartTime = report.StartTime
+		}
+		if report.TotalTime > r.TotalTime {
+			r.TotalTime = report.TotalTime
+		}
+		r.TotalClients += report.TotalClients
+		r.RequestedModelsCount += report.RequestedModelsCount
+		r.RequestedClientsCount += report.RequestedClientsCount
+		r.TargetModelsCount += report.TargetModelsCount
+		r.TargetClientsCount += report.TargetClientsCount
+		r.ConnectedModelsCount += report.ConnectedModelsCount
+		r.ConnectedClientsCount += report.ConnectedClientsCount
+		r.ConnectedModelCountLag += report.ConnectedModelCountLag
+		r.ConnectedClientCountLag += report.ConnectedClientCountLag
+		r.DroppedFrames += report.DroppedFrames
+		r.Reconnects += report.Reconnects
+		r.TotalDowntime += report.TotalDowntime
+		r.Stalls += report.Stalls
+		r.TotalStallTime += report.TotalStallTime
+
+		models := report.ConnectedModelsCount
+		model_fps.add(report.AverageModelFPS, models)
+		model_bitrate.add(report.AverageModelBitrate, models)
+		audio_sends.add(report.AverageAudioBytesSends, models)
+		video_sends.add(report.AverageVideoBytesSends, models)
+		published_time.add(report.TotalVideoPublished, models)
+		model_startup.add(report.AverageModelStartUpTime, models)
+		send_jitter.add(report.AverageSendJitter, models)
+
+		clients := report.ConnectedClientsCount
+		client_fps.add(report.AverageClientFPS, clients)
+		client_bitrate.add(report.AverageClientBitrate, clients)
+		audio_received.add(report.AverageAudioBytesReceived, clients)
+		video_received.add(report.AverageVideoBytesReceived, clients)
+		played_time.add(report.TotalVideoPlayed, clients)
+		client_startup.add(report.AverageClientStartUpTime, clients)
+		keyframe_interval.add(report.AverageKeyframeInterval, clients)
+		gop_size.add(report.AverageGopSize, clients)
+		rebuffer_sum += report.RebufferRatio * float64(clients)
+
+		r.MaxKeyframeInterval = maxOf(r.MaxKeyframeInterval, report.MaxKeyframeInterval)
+		r.SendJitterP95 = maxOf(r.SendJitterP95, report.SendJitterP95)
+		r.SweepStep = maxOf(r.SweepStep, report.SweepStep)
+		r.SweepValue = maxOf(r.SweepValue, report.SweepValue)
+
+		r.LatencyHistogram.Merge(report.LatencyHistogram)
+		r.Integrity.Add(report.Integrity)
+		for key, integrity := range report.StreamIntegrity {
+			stream := r.StreamIntegrity[key]
+			stream.Add(integrity)
+			r.StreamIntegrity[key] = stream
+		}
+		addCounts(r.SequenceHeaders, report.SequenceHeaders)
+		addCounts(r.Disconnects, report.Disconnects)
+		addCounts(r.ClientStates, report.ClientStates)
+		addSummaries(r.PublisherPhases, report.PublisherPhases)
+		addSummaries(r.PlayerPhases, report.PlayerPhases)
+	}
+	r.AverageModelFPS = model_fps.mean()
+	r.AverageModelBitrate = model_bitrate.mean()
+	r.AverageAudioBytesSends = audio_sends.mean()
+	r.AverageVideoBytesSends = video_sends.mean()
+	r.TotalVideoPublished = published_time.mean()
+	r.AverageModelStartUpTime = model_startup.mean()
+	r.AverageSendJitter = send_jitter.mean()
+	r.AverageClientFPS = client_fps.mean()
+	r.AverageClientBitrate = client_bitrate.mean()
+	r.AverageAudioBytesReceived = audio_received.mean()
+	r.AverageVideoBytesReceived = video_received.mean()
+	r.TotalVideoPlayed = played_time.mean()
+	r.AverageClientStartUpTime = client_startup.mean()
+	r.AverageKeyframeInterval = keyframe_interval.mean()
+	r.AverageGopSize = gop_size.mean()
+	r.LatencyP50 = r.LatencyHistogram.Percentile(50)
+	r.LatencyP95 = r.LatencyHistogram.Percentile(95)
+	r.LatencyP99 = r.LatencyHistogram.Percentile(99)
+	if client_fps.weight > 0 {
+		r.RebufferRatio = rebuffer_sum / float64(client_fps.weight)
+	}
+	r.Integrity.UpdateRates()
+	for key, stream := range r.StreamIntegrity {
+		stream.UpdateRates()
+		r.StreamIntegrity[key] = stream
+	}
+}
+
+// Returns greater of values.
+//
+// params: a int64   The first value.
+//         b int64   The second value.
+func maxOf(a int64, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Adds counts to counts by key.
+//
+// params: counts map   Summed counts by key.
+//         other  map   Added counts by key.
+func addCounts(counts map[string]int64, other map[string]int64) {
+	for key, count := range other {
+		counts[key] += count
+	}
+}
+
+// Adds summaries to summaries by key.
+// Minimum and maximum are exact, mean is weighted by counts,
+// percentiles are maximal of summaries.
+//
+// params: summaries map   Merged summaries by key.
+//         other     map   Added summaries by key.
+func addSummaries(summaries map[string]Summary, other map[string]Summary) {
+	for key, added := range other {
+		summary, ok := summaries[key]
+		if !ok || summary.Count == 0 {
+			summaries[key] = added
+			continue
+		}
+		if added.Count == 0 {
+			continue
+		}
+		count := summary.Count + added.Count
+		summaries[key] = Summary{
+			Count: count,
+			Min:   minOf(summary.Min, added.Min),
+			Max:   maxOf(summary.Max, added.Max),
+			Mean:  (summary.Mean*summary.Count + added.Mean*added.Count) / count,
+			P50:   maxOf(summary.P50, added.P50),
+			P95:   maxOf(summary.P95, added.P95),
+			P99:   maxOf(summary.P99, added.P99),
+		}
+	}
+}
+
+// Returns lesser of values.
+//
+// params: a int64   The first value.
+//         b int64   The second value.
+func minOf(a int64, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
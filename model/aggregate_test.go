@@ -0,0 +1,68 @@
+package model
+
+import "testing"
+
+// Returns report with latency histogram of samples.
+//
+// params: samples []int64   Latency samples in ms.
+//         clients int64     Connected players count.
+func latencyReport(samples []int64, clients int64) *Report {
+	report := NewReport("test")
+	for _, sample := range samples {
+		report.LatencyHistogram.Add(sample)
+	}
+	report.ConnectedClientsCount = clients
+	report.LatencyP50 = report.LatencyHistogram.Percentile(50)
+	report.LatencyP95 = report.LatencyHistogram.Percentile(95)
+	report.LatencyP99 = report.LatencyHistogram.Percentile(99)
+	return report
+}
+
+// Latency percentiles of agents are merged by histograms buckets.
+func TestAggregateLatencyPercentiles(t *testing.T) {
+	var fast []int64
+	for i := 0; i < 99; i++ {
+		fast = append(fast, 10)
+	}
+	slow := latencyReport([]int64{5000}, 1)
+	if slow.LatencyP50 != 5000 {
+		t.Fatalf("slow agent P50 is %d, want 5000", slow.LatencyP50)
+	}
+	report := NewReport("test")
+	report.Aggregate([]*Report{latencyReport(fast, 99), slow}, 0)
+	if report.LatencyHistogram.Count != 100 {
+		t.Errorf("merged latency count is %d, want 100",
+			report.LatencyHistogram.Count)
+	}
+	if report.LatencyHistogram.Max != 5000 {
+		t.Errorf("merged latency max is %d, want 5000",
+			report.LatencyHistogram.Max)
+	}
+	for p, value := range map[int]int64{
+		50: report.LatencyP50,
+		95: report.LatencyP95,
+		99: report.LatencyP99,
+	} {
+		if value != 10 {
+			t.Errorf("P%d is %d, want 10 of 99 fast samples", p, value)
+		}
+	}
+}
+
+// Aggregated report keeps its own mutex and test identity.
+func TestAggregateKeepsIdentity(t *testing.T) {
+	report := NewReport("prefix")
+	report.ResetReport("test", 1, 1)
+	report.Aggregate(nil, 2)
+	snapshot := report.Snapshot()
+	if snapshot.TestId != "test" || snapshot.MetricPrefix != "prefix" {
+		t.Errorf("identity is %s/%s, want test/prefix",
+			snapshot.TestId, snapshot.MetricPrefix)
+	}
+	if snapshot.DeadAgents != 2 || snapshot.LatencyP99 != 0 {
+		t.Errorf("empty aggregate is %+v", snapshot.Scalars())
+	}
+	if report.mutex == nil {
+		t.Error("aggregate drops report mutex")
+	}
+}
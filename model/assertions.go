@@ -0,0 +1,64 @@
+package model
+
+import "fmt"
+
+// Service level assertions checked against stress test report.
+// Not set assertion is not checked.
+type Assertions struct {
+	MinModelFPS    *int64 `json:"min_model_fps" yaml:"min_model_fps"`       // Minimal average publisher FPS.
+	MinClientFPS   *int64 `json:"min_client_fps" yaml:"min_client_fps"`     // Minimal average player FPS.
+	MaxModelLag    *int64 `json:"max_model_lag" yaml:"max_model_lag"`       // Maximal count of not connected publishers.
+	MaxClientLag   *int64 `json:"max_client_lag" yaml:"max_client_lag"`     // Maximal count of not connected players.
+	MaxStartUpTime *int64 `json:"max_startup_time" yaml:"max_startup_time"` // Maximal average player startup time in ms.
+}
+
+// Checks stress test report.
+// Returns descriptions of failed assertions.
+//
+// param: report *Report   Stress test report.
+func (a *Assertions) Check(report *Report) []string {
+	var failures []string
+	check := func(name string, limit *int64, value int64, min bool) {
+		if limit == nil {
+			return
+		}
+		if min && value < *limit {
+			failures = append(failures, fmt.Sprintf(
+				"%s %d is less than %d", name, value, *limit))
+		}
+		if !min && value > *limit {
+			failures = append(failures, fmt.Sprintf(
+				"%s %d is greater than %d", name, value, *limit))
+		}
+	}
+	check("AverageModelFPS", a.MinModelFPS, report.AverageModelFPS, true)
+	check("AverageClientFPS", a.MinClientFPS, report.AverageClientFPS, true)
+	check("ConnectedModelCountLag", a.MaxModelLag,
+		report.ConnectedModelCountLag, false)
+	check("ConnectedClientCountLag", a.MaxClientLag,
+		report.ConnectedClientCountLag, false)
+	check("AverageClientStartUpTime", a.MaxStartUpTime,
+		report.AverageClientStartUpTime, false)
+	return failures
+}
+
+// Overrides assertions with assertions which are set in other.
+//
+// param: other *Assertions   Overriding assertions.
+func (a *Assertions) Merge(other *Assertions) {
+	if other.MinModelFPS != nil {
+		a.MinModelFPS = other.MinModelFPS
+	}
+	if other.MinClientFPS != nil {
+		a.MinClientFPS = other.MinClientFPS
+	}
+	if other.MaxModelLag != nil {
+		a.MaxModelLag = other.MaxModelLag
+	}
+	if other.MaxClientLag != nil {
+		a.MaxClientLag = other.MaxClientLag
+	}
+	if other.MaxStartUpTime != nil {
+		a.MaxStartUpTime = other.MaxStartUpTime
+	}
+}
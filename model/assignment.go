@@ -0,0 +1,52 @@
+package model
+
+import "sort"
+
+// Load assigned to agent of distributed stress test.
+// Streams of agents do not overlap: agent publishes streams from
+// StreamOffset+1 to StreamOffset+ModelCount.
+type Assignment struct {
+	TestId       string // Test ID shared by all agents.
+	Agent        string // Agent name.
+	ModelCount   int    // Count of agent publishers.
+	ClientCount  int    // Count of players per publisher.
+	StreamOffset int    // Count of streams assigned to previous agents.
+}
+
+// Splits publishers evenly across agents.
+// Agents are ordered by name, the first agents get the remainder.
+// Agents without publishers get no assignment.
+//
+// params: test_id      string     Test ID shared by all agents.
+//         agents       []string   Names of live agents.
+//         model_count  int        Total count of publishers.
+//         client_count int        Count of players per publisher.
+func SplitLoad(
+	test_id string, agents []string,
+	model_count int, client_count int) []Assignment {
+	if len(agents) == 0 {
+		return nil
+	}
+	names := append([]string(nil), agents...)
+	sort.Strings(names)
+	var assignments []Assignment
+	offset := 0
+	for i, name := range names {
+		count := model_count / len(names)
+		if i < model_count%len(names) {
+			count++
+		}
+		if count == 0 {
+			continue
+		}
+		assignments = append(assignments, Assignment{
+			TestId:       test_id,
+			Agent:        name,
+			ModelCount:   count,
+			ClientCount:  client_count,
+			StreamOffset: offset,
+		})
+		offset += count
+	}
+	return assignments
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"sort"
+	"sync"
+)
+
+// Default count of samples kept by distribution.
+const DISTRIBUTION_SIZE = 1024
+
+// Distribution of measured values.
+// Keeps the latest samples for percentiles calculation.
+type Distribution struct {
+	mutex   sync.Mutex
+	samples []int64 // Ring buffer of the latest samples.
+	next    int     // Next sample position.
+	count   int64   // Total count of samples.
+}
+
+// Summary of distribution.
+type Summary struct {
+	Count int64 // Total count of samples.
+	Min   int64 // Minimal sample.
+	Max   int64 // Maximal sample.
+	Mean  int64 // Average of samples.
+	P50   int64 // 50th percentile.
+	P95   int64 // 95th percentile.
+	P99   int64 // 99th percentile.
+}
+
+// Returns new distribution instance.
+//
+// param: size int   Count of kept samples.
+func NewDistribution(size int) *Distribution {
+	return &Distribution{
+		samples: make([]int64, 0, size),
+	}
+}
+
+// Adds sample to distribution.
+//
+// param: value int64   Measured value.
+func (d *Distribution) Add(value int64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if len(d.samples) < cap(d.samples) {
+		d.samples = append(d.samples, value)
+	} else {
+		d.samples[d.next] = value
+		d.next = (d.next + 1) % len(d.samples)
+	}
+	d.count++
+}
+
+// Returns copy of kept samples.
+func (d *Distribution) Samples() []int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	samples := make([]int64, len(d.samples))
+	copy(samples, d.samples)
+	return samples
+}
+
+// Returns total count of samples.
+func (d *Distribution) Count() int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.count
+}
+
+// Returns summary of kept samples.
+func (d *Distribution) Summary() Summary {
+	summary := NewSummary(d.Samples())
+	summary.Count = d.Count()
+	return summary
+}
+
+// Returns summary of samples.
+//
+// param: samples []int64   Measured values.
+func NewSummary(samples []int64) Summary {
+	summary := Summary{Count: int64(len(samples))}
+	if len(samples) == 0 {
+		return summary
+	}
+	sorted := make([]int64, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum int64
+	for _, sample := range sorted {
+		sum += sample
+	}
+	summary.Min = sorted[0]
+	summary.Max = sorted[len(sorted)-1]
+	summary.Mean = sum / int64(len(sorted))
+	summary.P50 = percentile(sorted, 50)
+	summary.P95 = percentile(sorted, 95)
+	summary.P99 = percentile(sorted, 99)
+	return summary
+}
+
+// Returns nearest-rank percentile of sorted samples.
+//
+// params: sorted []int64   Sorted samples.
+//         p      int       Percentile.
+func percentile(sorted []int64, p int) int64 {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
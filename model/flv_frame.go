@@ -1,10 +1,17 @@
 package model
 
-import "github.com/zhangpeihao/goflv"
+import (
+	"github.com/instrumentisto/go-rtmp-bot/media"
+	"github.com/zhangpeihao/goflv"
+	"time"
+)
 
 // Flv frame data
 type FlvFrame struct {
 	Header         *flv.TagHeader // Flv frame header.
 	Frame          []byte         // Flv frame content.
-	DeltaTimestamp uint32         // Flv frame delta constant.
+	DeltaTimestamp uint32         // Frame timestamp from the stream start in ms.
+	Tag            media.TagInfo  // Parsed header of frame content.
+	SendAt         time.Time      // Scheduled send time, zero without pacing.
+	SendDelay      time.Duration  // Deviation of sending from schedule, negative ahead of it.
 }
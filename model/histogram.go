@@ -0,0 +1,153 @@
+package model
+
+// Histogram bounds parameters in milliseconds.
+const (
+	HISTOGRAM_MAX    = 60000 // Upper bound of the last finite bucket.
+	HISTOGRAM_GROWTH = 1.1   // Growth factor of bucket bounds.
+)
+
+// Upper bounds of histogram buckets in milliseconds.
+// Bounds grow exponentially, so percentiles have at most 10% error.
+var HISTOGRAM_BOUNDS = histogramBounds(HISTOGRAM_MAX, HISTOGRAM_GROWTH)
+
+// Histogram of measured values with fixed buckets.
+// Histograms of different clients and agents are merged by buckets,
+// so percentiles of many clients need no raw samples.
+type Histogram struct {
+	Counts []int64 // Count of samples by HISTOGRAM_BOUNDS, the last one is overflow.
+	Count  int64   // Total count of samples.
+	Sum    int64   // Sum of samples.
+	Min    int64   // Minimal sample.
+	Max    int64   // Maximal sample.
+}
+
+// Returns new histogram instance.
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+// Adds sample to histogram.
+//
+// param: value int64   Measured value.
+func (h *Histogram) Add(value int64) {
+	if h.Counts == nil {
+		h.Counts = make([]int64, len(HISTOGRAM_BOUNDS)+1)
+	}
+	h.Counts[bucketOf(value)]++
+	if h.Count == 0 || value < h.Min {
+		h.Min = value
+	}
+	if h.Count == 0 || value > h.Max {
+		h.Max = value
+	}
+	h.Count++
+	h.Sum += value
+}
+
+// Adds samples of other histogram.
+//
+// param: other Histogram   Merged histogram.
+func (h *Histogram) Merge(other Histogram) {
+	if other.Count == 0 {
+		return
+	}
+	if h.Counts == nil {
+		h.Counts = make([]int64, len(HISTOGRAM_BOUNDS)+1)
+	}
+	for i, count := range other.Counts {
+		if i < len(h.Counts) {
+			h.Counts[i] += count
+		}
+	}
+	if h.Count == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if h.Count == 0 || other.Max > h.Max {
+		h.Max = other.Max
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+}
+
+// Returns copy of histogram which does not share buckets.
+func (h *Histogram) Copy() Histogram {
+	histogram := *h
+	histogram.Counts = append([]int64(nil), h.Counts...)
+	return histogram
+}
+
+// Returns nearest-rank percentile estimated by upper bound of bucket.
+//
+// param: p int   Percentile.
+func (h *Histogram) Percentile(p int) int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := (int64(p)*h.Count + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, count := range h.Counts {
+		seen += count
+		if seen < rank {
+			continue
+		}
+		if i == len(HISTOGRAM_BOUNDS) || HISTOGRAM_BOUNDS[i] > h.Max {
+			return h.Max
+		}
+		if HISTOGRAM_BOUNDS[i] < h.Min {
+			return h.Min
+		}
+		return HISTOGRAM_BOUNDS[i]
+	}
+	return h.Max
+}
+
+// Returns summary of histogram.
+func (h *Histogram) Summary() Summary {
+	summary := Summary{Count: h.Count}
+	if h.Count == 0 {
+		return summary
+	}
+	summary.Min = h.Min
+	summary.Max = h.Max
+	summary.Mean = h.Sum / h.Count
+	summary.P50 = h.Percentile(50)
+	summary.P95 = h.Percentile(95)
+	summary.P99 = h.Percentile(99)
+	return summary
+}
+
+// Returns index of bucket of value.
+//
+// param: value int64   Measured value.
+func bucketOf(value int64) int {
+	low, high := 0, len(HISTOGRAM_BOUNDS)
+	for low < high {
+		middle := (low + high) / 2
+		if HISTOGRAM_BOUNDS[middle] < value {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+	return low
+}
+
+// Returns exponentially growing bucket bounds from 1.
+//
+// params: limit  int64     Upper bound of the last bucket.
+//         growth float64   Growth factor of bounds.
+func histogramBounds(limit int64, growth float64) []int64 {
+	bounds := []int64{1}
+	for last := int64(1); last < limit; {
+		next := int64(float64(last) * growth)
+		if next <= last {
+			next = last + 1
+		}
+		bounds = append(bounds, next)
+		last = next
+	}
+	return bounds
+}
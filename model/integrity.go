@@ -0,0 +1,117 @@
+package model
+
+// Count of the latest sequence numbers checked for duplicates
+// and reordered frames.
+const INTEGRITY_WINDOW = 1024
+
+// Stream integrity statistic.
+type Integrity struct {
+	Frames         int64   // Verified frames.
+	Lost           int64   // Missing frames.
+	Duplicated     int64   // Duplicated frames.
+	Reordered      int64   // Frames received after frames with greater sequence number.
+	Corrupted      int64   // Frames with wrong checksum.
+	TimestampJumps int64   // Discontinuities of received timestamps.
+	LossRate       float64 // Part of missing frames.
+	ReorderRate    float64 // Part of reordered frames.
+}
+
+// Adds counts of other integrity statistic.
+// Rates are not updated.
+//
+// param: other Integrity   Added statistic.
+func (i *Integrity) Add(other Integrity) {
+	i.Frames += other.Frames
+	i.Lost += other.Lost
+	i.Duplicated += other.Duplicated
+	i.Reordered += other.Reordered
+	i.Corrupted += other.Corrupted
+	i.TimestampJumps += other.TimestampJumps
+}
+
+// Updates rates by counts.
+func (i *Integrity) UpdateRates() {
+	i.LossRate = 0
+	i.ReorderRate = 0
+	if expected := i.Frames + i.Lost; expected > 0 {
+		i.LossRate = float64(i.Lost) / float64(expected)
+	}
+	if i.Frames > 0 {
+		i.ReorderRate = float64(i.Reordered) / float64(i.Frames)
+	}
+}
+
+// Checker of received frames integrity.
+// Frames are checked by sequence numbers, checksums and timestamps
+// embedded by publisher. Checking starts from the first frame
+// of each connection.
+// Checker is not guarded, player calls it under its own lock.
+type IntegrityChecker struct {
+	stats     Integrity                // Integrity statistic.
+	started   bool                     // The first frame of connection is checked.
+	first_seq uint64                   // Sequence number of the first frame of connection.
+	max_seq   uint64                   // The greatest received sequence number.
+	seen      [INTEGRITY_WINDOW]uint64 // The latest received sequence numbers.
+	ts_offset int64                    // Offset of received timestamps from published ones.
+}
+
+// Returns new integrity checker.
+func NewIntegrityChecker() *IntegrityChecker {
+	return &IntegrityChecker{}
+}
+
+// Checks received frame.
+//
+// params: seq         uint64   Frame sequence number.
+//         published   uint32   Published frame timestamp.
+//         received    uint32   Received frame timestamp.
+//         checksum_ok bool     Frame checksum is valid.
+func (c *IntegrityChecker) OnFrame(
+	seq uint64, published uint32, received uint32, checksum_ok bool) {
+	offset := int64(received) - int64(published)
+	if !c.started {
+		c.started = true
+		c.first_seq = seq
+		c.max_seq = seq
+		c.ts_offset = offset
+	} else {
+		switch {
+		case seq > c.max_seq:
+			c.stats.Lost += int64(seq - c.max_seq - 1)
+			c.max_seq = seq
+			if offset != c.ts_offset {
+				c.stats.TimestampJumps++
+				c.ts_offset = offset
+			}
+		case c.seen[seq%INTEGRITY_WINDOW] == seq:
+			c.stats.Duplicated++
+			return
+		case seq < c.first_seq:
+			// The frame is older than checking start.
+			return
+		default:
+			// The frame is counted as lost before.
+			c.stats.Reordered++
+			c.stats.Lost--
+		}
+	}
+	c.seen[seq%INTEGRITY_WINDOW] = seq
+	c.stats.Frames++
+	if !checksum_ok {
+		c.stats.Corrupted++
+	}
+}
+
+// Finishes checking of lost connection.
+// Checking of the next connection starts from its first frame.
+func (c *IntegrityChecker) Interrupt() {
+	c.started = false
+	c.seen = [INTEGRITY_WINDOW]uint64{}
+}
+
+// Returns integrity statistic with updated rates.
+func (c *IntegrityChecker) Stats() Integrity {
+	stats := c.stats
+	stats.UpdateRates()
+	return stats
+}
@@ -0,0 +1,154 @@
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// RTMP client lifecycle states.
+const (
+	STATE_CONNECTING string = "connecting" // RTMP connection is being established.
+	STATE_CONNECTED  string = "connected"  // RTMP connect command succeeded.
+	STATE_PUBLISHING string = "publishing" // Publisher publishes stream.
+	STATE_PLAYING    string = "playing"    // Player receives stream.
+	STATE_STALLED    string = "stalled"    // Stream has no media.
+	STATE_CLOSED     string = "closed"     // Connection is closed.
+	STATE_FAILED     string = "failed"     // Connection failed.
+)
+
+// Reasons of lifecycle transitions.
+// Reasons of transitions into closed and failed states are disconnect causes.
+const (
+	REASON_START          string = "start"           // Client is started.
+	REASON_RECONNECT      string = "reconnect"       // Client reconnects.
+	REASON_CONNECT_OK     string = "connect_ok"      // Connect command succeeded.
+	REASON_PUBLISH_START  string = "publish_start"   // Publishing started.
+	REASON_FIRST_VIDEO    string = "first_video"     // Connection received video.
+	REASON_NO_MEDIA       string = "no_media"        // No frames in statistic interval.
+	REASON_MEDIA_RESUMED  string = "media_resumed"   // Frames are resumed.
+	CAUSE_DIAL_ERROR      string = "dial_error"      // Connection dial failed.
+	CAUSE_CONNECT_ERROR   string = "connect_error"   // Connect command failed.
+	CAUSE_STREAM_ERROR    string = "stream_error"    // Publish or play command failed.
+	CAUSE_SEND_ERROR      string = "send_error"      // Sending of media failed.
+	CAUSE_CONNECTION_LOST string = "connection_lost" // Connection closed by peer.
+	CAUSE_QUEUE_OVERFLOW  string = "queue_overflow"  // Publisher frames queue overflowed.
+	CAUSE_STOPPED         string = "stopped"         // Client stopped by test.
+)
+
+// Disconnect causes reported by test report.
+var DISCONNECT_CAUSES = []string{
+	CAUSE_DIAL_ERROR, CAUSE_CONNECT_ERROR, CAUSE_STREAM_ERROR, CAUSE_SEND_ERROR,
+	CAUSE_CONNECTION_LOST, CAUSE_QUEUE_OVERFLOW, CAUSE_STOPPED,
+}
+
+// Lifecycle states reported by test report.
+var CLIENT_STATES = []string{
+	STATE_CONNECTING, STATE_CONNECTED, STATE_PUBLISHING, STATE_PLAYING,
+	STATE_STALLED, STATE_CLOSED, STATE_FAILED,
+}
+
+// Count of the latest transitions kept by lifecycle.
+const MAX_TRANSITIONS = 32
+
+// Allowed transitions by source state.
+// Not started client has empty state.
+var state_transitions = map[string][]string{
+	"":               {STATE_CONNECTING, STATE_CLOSED},
+	STATE_CONNECTING: {STATE_CONNECTED, STATE_CLOSED, STATE_FAILED},
+	STATE_CONNECTED:  {STATE_PUBLISHING, STATE_PLAYING, STATE_CLOSED, STATE_FAILED},
+	STATE_PUBLISHING: {STATE_STALLED, STATE_CLOSED, STATE_FAILED},
+	STATE_PLAYING:    {STATE_STALLED, STATE_CLOSED, STATE_FAILED},
+	STATE_STALLED:    {STATE_PUBLISHING, STATE_PLAYING, STATE_CLOSED, STATE_FAILED},
+	STATE_CLOSED:     {STATE_CONNECTING},
+	STATE_FAILED:     {STATE_CONNECTING},
+}
+
+// Transition of RTMP client lifecycle.
+type Transition struct {
+	From   string    // Source state.
+	To     string    // Target state.
+	Reason string    // Transition reason.
+	Time   time.Time // Transition time.
+}
+
+// State machine of RTMP client lifecycle.
+// Counts disconnects by cause.
+type ClientLifecycle struct {
+	mutex       sync.Mutex
+	state       string           // Current state.
+	transitions []Transition     // The latest transitions.
+	disconnects map[string]int64 // Count of disconnects by cause.
+}
+
+// Returns new not started client lifecycle.
+func NewClientLifecycle() *ClientLifecycle {
+	return &ClientLifecycle{
+		disconnects: make(map[string]int64),
+	}
+}
+
+// Moves lifecycle to state.
+// Returns false if the transition is not allowed from the current state.
+//
+// params: state  string   Target state.
+//         reason string   Transition reason.
+func (l *ClientLifecycle) Transit(state string, reason string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	allowed := false
+	for _, target := range state_transitions[l.state] {
+		if target == state {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return false
+	}
+	if len(l.transitions) == MAX_TRANSITIONS {
+		l.transitions = append(l.transitions[:0], l.transitions[1:]...)
+	}
+	l.transitions = append(l.transitions, Transition{
+		From:   l.state,
+		To:     state,
+		Reason: reason,
+		Time:   time.Now(),
+	})
+	l.state = state
+	if state == STATE_CLOSED || state == STATE_FAILED {
+		l.disconnects[reason]++
+	}
+	return true
+}
+
+// Returns current state.
+func (l *ClientLifecycle) State() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.state
+}
+
+// Returns copy of the latest transitions.
+func (l *ClientLifecycle) Transitions() []Transition {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]Transition(nil), l.transitions...)
+}
+
+// Returns copy of disconnects counts by cause.
+func (l *ClientLifecycle) Disconnects() map[string]int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	disconnects := make(map[string]int64)
+	for cause, count := range l.disconnects {
+		disconnects[cause] = count
+	}
+	return disconnects
+}
+
+// Returns true if client in state publishes or plays stream.
+//
+// param: state string   Lifecycle state.
+func IsStreaming(state string) bool {
+	return state == STATE_PUBLISHING || state == STATE_PLAYING
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"math"
+	"time"
+)
+
+// Load profile types.
+const (
+	PROFILE_SPIKE  string = "spike"  // All clients start at once.
+	PROFILE_LINEAR string = "linear" // Clients are added linearly.
+	PROFILE_STEP   string = "step"   // Clients are added by equal steps.
+)
+
+// Load profile of stress test.
+// Describes how the requested count of clients is reached, held and
+// released.
+type LoadProfile struct {
+	ProfileType  string `schema:"profile"`    // Ramp-up type.
+	RampUpTime   int    `schema:"ramp_up"`    // Ramp-up duration in seconds.
+	StepCount    int    `schema:"step_count"` // Count of ramp-up steps.
+	PlateauTime  int    `schema:"plateau"`    // Plateau duration in seconds.
+	RampDownTime int    `schema:"ramp_down"`  // Ramp-down duration in seconds.
+}
+
+// Returns load factor of the profile at the time elapsed since test start.
+// Load factor is a share of requested clients from 0 to 1.
+// Plateau time 0 holds the requested load until the test is stopped.
+//
+// param: elapsed time.Duration   Time elapsed since test start.
+func (p *LoadProfile) LoadAt(elapsed time.Duration) float64 {
+	ramp_up := time.Duration(p.RampUpTime) * time.Second
+	if elapsed < ramp_up {
+		switch p.ProfileType {
+		case PROFILE_LINEAR:
+			return float64(elapsed) / float64(ramp_up)
+		case PROFILE_STEP:
+			steps := p.StepCount
+			if steps < 1 {
+				steps = 1
+			}
+			step_time := ramp_up / time.Duration(steps)
+			if step_time == 0 {
+				return 1
+			}
+			step := int(elapsed / step_time)
+			return math.Min(float64(step+1), float64(steps)) / float64(steps)
+		}
+		return 1
+	}
+	if p.PlateauTime <= 0 {
+		return 1
+	}
+	ramp_down_at := ramp_up + time.Duration(p.PlateauTime)*time.Second
+	if elapsed < ramp_down_at {
+		return 1
+	}
+	ramp_down := time.Duration(p.RampDownTime) * time.Second
+	if elapsed >= ramp_down_at+ramp_down {
+		return 0
+	}
+	return 1 - float64(elapsed-ramp_down_at)/float64(ramp_down)
+}
+
+// Returns true if the profile is played to the end and all clients
+// should be released.
+//
+// param: elapsed time.Duration   Time elapsed since test start.
+func (p *LoadProfile) IsFinished(elapsed time.Duration) bool {
+	duration := p.Duration()
+	return duration > 0 && elapsed >= duration
+}
+
+// Returns total duration of the profile.
+// Returns 0 if the profile lasts until the test is stopped.
+func (p *LoadProfile) Duration() time.Duration {
+	if p.PlateauTime <= 0 {
+		return 0
+	}
+	return time.Duration(
+		p.RampUpTime+p.PlateauTime+p.RampDownTime) * time.Second
+}
+
+// Returns count of clients for load factor.
+//
+// params: count  int       Requested count of clients.
+//         factor float64   Load factor.
+func ScaleCount(count int, factor float64) int {
+	return int(math.Ceil(float64(count) * factor))
+}
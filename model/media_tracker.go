@@ -0,0 +1,123 @@
+package model
+
+import "github.com/instrumentisto/go-rtmp-bot/media"
+
+// Arrival statuses of AVC and AAC sequence headers.
+const (
+	HEADER_NONE    string = ""        // No media needing header is received.
+	HEADER_OK      string = "ok"      // Header arrived before media.
+	HEADER_LATE    string = "late"    // Header arrived after media.
+	HEADER_MISSING string = "missing" // Media is received without header.
+)
+
+// Arrival of sequence header on connection.
+type headerArrival struct {
+	seen   bool   // Header is received.
+	status string // Arrival status.
+}
+
+// Handles sequence header.
+func (h *headerArrival) onHeader() {
+	h.seen = true
+	if h.status == HEADER_MISSING {
+		h.status = HEADER_LATE
+	}
+}
+
+// Handles media which needs sequence header.
+func (h *headerArrival) onMedia() {
+	if h.status != HEADER_NONE {
+		return
+	}
+	if h.seen {
+		h.status = HEADER_OK
+	} else {
+		h.status = HEADER_MISSING
+	}
+}
+
+// Tracker of sent or received media.
+// Detects codecs, keyframe intervals, GOP sizes and arrival of
+// sequence headers. Keyframes and headers are tracked per connection.
+// Tracker is not guarded, client calls it under its own lock.
+type MediaTracker struct {
+	video_codec   string        // Video codec name.
+	audio_codec   string        // Audio codec name.
+	has_key       bool          // Keyframe of connection is received.
+	key_timestamp uint32        // Timestamp of the latest keyframe.
+	gop_frames    int64         // Video frames of the current GOP.
+	key_intervals *Distribution // Keyframe intervals in milliseconds.
+	gop_sizes     *Distribution // GOP sizes in frames.
+	video_header  headerArrival // AVC sequence header arrival.
+	audio_header  headerArrival // AAC sequence header arrival.
+}
+
+// Returns new media tracker.
+func NewMediaTracker() *MediaTracker {
+	return &MediaTracker{
+		key_intervals: NewDistribution(DISTRIBUTION_SIZE),
+		gop_sizes:     NewDistribution(DISTRIBUTION_SIZE),
+	}
+}
+
+// Handles sent or received tag.
+// Returns true for the first keyframe of connection.
+//
+// params: tag       media.TagInfo   Parsed tag header.
+//         timestamp uint32          Tag timestamp in milliseconds.
+func (t *MediaTracker) OnTag(tag media.TagInfo, timestamp uint32) bool {
+	var header *headerArrival
+	switch tag.Type {
+	case media.TAG_VIDEO:
+		t.video_codec = tag.CodecName()
+		header = &t.video_header
+	case media.TAG_AUDIO:
+		t.audio_codec = tag.CodecName()
+		header = &t.audio_header
+	default:
+		return false
+	}
+	if tag.NeedsSequenceHeader() {
+		if tag.SequenceHeader {
+			header.onHeader()
+		} else if tag.Media {
+			header.onMedia()
+		}
+	}
+	if tag.Type != media.TAG_VIDEO || !tag.Media {
+		return false
+	}
+	if !tag.KeyFrame {
+		t.gop_frames++
+		return false
+	}
+	first := !t.has_key
+	if t.has_key {
+		t.key_intervals.Add(int64(timestamp - t.key_timestamp))
+		t.gop_sizes.Add(t.gop_frames)
+	}
+	t.has_key = true
+	t.key_timestamp = timestamp
+	t.gop_frames = 1
+	return first
+}
+
+// Finishes tracking of lost connection.
+func (t *MediaTracker) Interrupt() {
+	t.has_key = false
+	t.gop_frames = 0
+	t.video_header = headerArrival{}
+	t.audio_header = headerArrival{}
+}
+
+// Updates media statistic of client.
+//
+// param: stat *StatItem   Client statistic.
+func (t *MediaTracker) Update(stat *StatItem) {
+	stat.VideoCodec = t.video_codec
+	stat.AudioCodec = t.audio_codec
+	stat.KeyframeInterval = t.key_intervals.Summary()
+	stat.GopSize = t.gop_sizes.Summary()
+	stat.VideoHeader = t.video_header.status
+	stat.AudioHeader = t.audio_header.status
+}
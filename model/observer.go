@@ -0,0 +1,12 @@
+package model
+
+// Observer of RTMP clients samples.
+// Samples are observed once when they are measured.
+type SampleObserver interface {
+	// Observes end-to-end latency of player.
+	//
+	// params: role      string   Role of RTMP client.
+	//         stream_id string   Stream key.
+	//         latency   int64    End-to-end latency in milliseconds.
+	ObserveLatency(role string, stream_id string, latency int64)
+}
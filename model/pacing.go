@@ -0,0 +1,52 @@
+package model
+
+import "fmt"
+
+// Pacing modes of test media sources.
+const (
+	PACING_REALTIME string = "realtime" // Frames are sent at their timestamps.
+	PACING_FAST     string = "fast"     // Frames are sent as fast as possible.
+	PACING_SPEED    string = "speed"    // Frames are sent at speed factor of realtime.
+)
+
+// Default pacing tolerances in milliseconds.
+const (
+	DEFAULT_PACING_LEAD = 0    // Frames are not sent ahead of schedule.
+	DEFAULT_PACING_LAG  = 1000 // Lag of schedule caught up by burst.
+)
+
+// Pacing of test media sources.
+// Frames are sent by schedule of their timestamps. Frames may be sent
+// ahead of schedule up to the lead. Lag of schedule up to the lag is
+// caught up by burst of frames, greater lag shifts the schedule.
+type Pacing struct {
+	PacingMode  string  `schema:"pacing"`       // Pacing mode, realtime by default.
+	PacingSpeed float64 `schema:"pacing_speed"` // Speed factor of speed mode.
+	PacingLead  int     `schema:"pacing_lead"`  // Allowed lead of schedule in ms.
+	PacingLag   int     `schema:"pacing_lag"`   // Lag of schedule caught up by burst in ms.
+}
+
+// Checks pacing mode and speed.
+func (p *Pacing) Validate() error {
+	switch p.PacingMode {
+	case "", PACING_REALTIME, PACING_FAST:
+		return nil
+	case PACING_SPEED:
+		if p.PacingSpeed <= 0 {
+			return fmt.Errorf("pacing speed %g is not positive", p.PacingSpeed)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown pacing mode %s", p.PacingMode)
+}
+
+// Returns speed factor of schedule, 0 if frames are not scheduled.
+func (p *Pacing) Speed() float64 {
+	switch p.PacingMode {
+	case PACING_FAST:
+		return 0
+	case PACING_SPEED:
+		return p.PacingSpeed
+	}
+	return 1
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Default reconnect backoff in milliseconds.
+const (
+	DEFAULT_RECONNECT_BACKOFF     = 500   // Backoff before the first attempt.
+	DEFAULT_RECONNECT_MAX_BACKOFF = 30000 // Maximal backoff.
+)
+
+// Reconnect policy of RTMP clients.
+// Backoff grows exponentially with consecutive failed attempts and is
+// randomized by jitter. Reconnect is disabled with zero attempts.
+type ReconnectPolicy struct {
+	ReconnectAttempts   int     `schema:"reconnect_attempts"`    // Max consecutive attempts, -1 is unlimited.
+	ReconnectBackoff    int     `schema:"reconnect_backoff"`     // Backoff before the first attempt in ms.
+	ReconnectMaxBackoff int     `schema:"reconnect_max_backoff"` // Maximal backoff in ms.
+	ReconnectJitter     float64 `schema:"reconnect_jitter"`      // Random part of backoff from 0 to 1.
+}
+
+// Returns true if clients reconnect after connection loss.
+func (p *ReconnectPolicy) Enabled() bool {
+	return p != nil && p.ReconnectAttempts != 0
+}
+
+// Returns true if the consecutive attempt is allowed.
+//
+// param: attempt int   Number of consecutive attempt from 1.
+func (p *ReconnectPolicy) CanRetry(attempt int) bool {
+	if !p.Enabled() {
+		return false
+	}
+	return p.ReconnectAttempts < 0 || attempt <= p.ReconnectAttempts
+}
+
+// Returns backoff before the consecutive attempt.
+//
+// param: attempt int   Number of consecutive attempt from 1.
+func (p *ReconnectPolicy) Backoff(attempt int) time.Duration {
+	initial := p.ReconnectBackoff
+	if initial <= 0 {
+		initial = DEFAULT_RECONNECT_BACKOFF
+	}
+	max_backoff := p.ReconnectMaxBackoff
+	if max_backoff <= 0 {
+		max_backoff = DEFAULT_RECONNECT_MAX_BACKOFF
+	}
+	backoff := math.Min(
+		float64(initial)*math.Pow(2, float64(attempt-1)), float64(max_backoff))
+	jitter := math.Max(0, math.Min(p.ReconnectJitter, 1))
+	backoff *= 1 + jitter*(2*rand.Float64()-1)
+	return time.Duration(backoff * float64(time.Millisecond))
+}
@@ -1,10 +1,18 @@
 package model
 
-import "time"
+import (
+	"strings"
+	"sync"
+	"time"
+)
 
 
 // Stress test report.
+// Report shared by statistic and readers is guarded by its mutex,
+// readers take snapshots of it. Report copies are not guarded.
 type Report struct {
+	mutex *sync.RWMutex // Guards shared report, nil in copies.
+
 	TestId                 string // Test ID.
 	MetricPrefix           string // Prefix fo prometheus metrics
 	StartTime              int64  // Test starts time.
@@ -12,6 +20,8 @@ type Report struct {
 	TotalClients           int    // Total RTMP clients count.
 	RequestedModelsCount   int    // Requested publishers count.
 	RequestedClientsCount  int    // Requested players count.
+	TargetModelsCount      int    // Publishers count of current load step.
+	TargetClientsCount     int    // Players count of current load step.
 	ConnectedModelsCount   int64  // Connected publishers count.
 	ConnectedClientsCount  int64  // Connected players count.
 	ConnectedModelCountLag int64  // Lag with requested and connected
@@ -20,17 +30,50 @@ type Report struct {
 	// players.
 	AverageModelFPS           int64 // Average publisher FPS value.
 	AverageClientFPS          int64 // Average player FPS value.
+	AverageModelBitrate       int64 // Average publisher bitrate in bits per second.
+	AverageClientBitrate      int64 // Average player bitrate in bits per second.
 	AverageAudioBytesSends    int64 // Average audio bytes published.
 	AverageVideoBytesSends    int64 // Average video bytes published.
 	AverageAudioBytesReceived int64 // Average audio bytes received.
 	AverageVideoBytesReceived int64 // Average video bytes received.
 	TotalVideoPublished       int64 // Video data published in bytes.
 	TotalVideoPlayed          int64 // Video data received in bytes.
+	DroppedFrames             int64 // Frames dropped by publishers frame queues.
+	Reconnects                int64 // Reconnect attempts of all clients.
+	TotalDowntime             int64 // Downtime of all clients in ms.
+	Stalls                    int64 // Playback stalls of all players.
+	TotalStallTime            int64 // Stalls duration of all players in ms.
+	AverageKeyframeInterval   int64 // Average keyframe interval of players in ms.
+	MaxKeyframeInterval       int64 // Maximal keyframe interval of players in ms.
+	AverageGopSize            int64 // Average GOP size of players in frames.
+	AverageModelStartUpTime   int64 // Average publisher startup time in ms.
+	AverageClientStartUpTime  int64 // Average player startup time in ms.
+	LatencyP50                int64 // 50th percentile of players latency in ms.
+	LatencyP95                int64 // 95th percentile of players latency in ms.
+	LatencyP99                int64 // 99th percentile of players latency in ms.
+	AverageSendJitter         int64 // Average send jitter of publishers in ms.
+	SendJitterP95             int64 // Maximal 95th percentile of publishers send jitter in ms.
+	SweepStep                 int64 // Current sweep step from 1, 0 without sweep.
+	SweepValue                int64 // Swept parameter value of current step.
+	LiveAgents                int64 // Live agents of distributed test, 0 without agents.
+	DeadAgents                int64 // Dead agents of distributed test.
+
+	RebufferRatio    float64              // Part of players playback time spent in stalls.
+	LatencyHistogram Histogram            // Merged latency histogram of players in ms.
+	Integrity        Integrity            // Received frames integrity of all players.
+	StreamIntegrity  map[string]Integrity // Received frames integrity by stream key.
+	SequenceHeaders  map[string]int64     // Count of clients by sequence headers arrival.
+	PublisherPhases  map[string]Summary   // Publisher connection phases in ns.
+	PlayerPhases     map[string]Summary   // Player connection phases in ns.
+	Disconnects      map[string]int64     // Disconnects of clients by cause.
+	ClientStates     map[string]int64     // Count of clients by lifecycle state.
+	SweepSteps       []SweepStep          // Results of sweep steps.
+	Clients          map[string]*StatItem `json:"-"` // The latest clients statistic.
 }
 
 // Returns new stress test report instance.
 func NewReport(prefix string) *Report {
-	report := &Report{}
+	report := &Report{mutex: &sync.RWMutex{}}
 	report.MetricPrefix = prefix
 	report.ResetReport("", 0, 0)
 	return report
@@ -42,32 +85,131 @@ func NewReport(prefix string) *Report {
 //         model_count  int       Count of models.
 //         client_count int       Count of clients.
 func (r *Report) ResetReport(test_id string, model_count int, client_count int) {
+	r.lock()
+	defer r.unlock()
 	r.TestId = test_id
 	r.StartTime = time.Now().Unix()
 	r.TotalTime = time.Unix(0, 0).Unix()
 	r.TotalClients = model_count*client_count + model_count
 	r.RequestedModelsCount = model_count
 	r.RequestedClientsCount = client_count * model_count
+	r.TargetModelsCount = r.RequestedModelsCount
+	r.TargetClientsCount = r.RequestedClientsCount
 	r.ConnectedModelsCount = 0
 	r.ConnectedClientsCount = 0
 	r.ConnectedModelCountLag = 0
 	r.ConnectedClientCountLag = 0
 	r.AverageModelFPS = 0
 	r.AverageClientFPS = 0
+	r.AverageModelBitrate = 0
+	r.AverageClientBitrate = 0
 	r.AverageAudioBytesSends = 0
 	r.AverageVideoBytesSends = 0
 	r.AverageAudioBytesReceived = 0
 	r.AverageVideoBytesReceived = 0
 	r.TotalVideoPublished = r.TotalTime
 	r.TotalVideoPlayed = r.TotalTime
+	r.DroppedFrames = 0
+	r.Reconnects = 0
+	r.TotalDowntime = 0
+	r.Stalls = 0
+	r.TotalStallTime = 0
+	r.AverageKeyframeInterval = 0
+	r.MaxKeyframeInterval = 0
+	r.AverageGopSize = 0
+	r.RebufferRatio = 0
+	r.Integrity = Integrity{}
+	r.StreamIntegrity = make(map[string]Integrity)
+	r.SequenceHeaders = countsOf(sequenceHeaderKeys())
+	r.AverageModelStartUpTime = 0
+	r.AverageClientStartUpTime = 0
+	r.LatencyP50 = 0
+	r.LatencyP95 = 0
+	r.LatencyP99 = 0
+	r.LatencyHistogram = Histogram{}
+	r.AverageSendJitter = 0
+	r.SendJitterP95 = 0
+	r.SweepStep = 0
+	r.SweepValue = 0
+	r.LiveAgents = 0
+	r.DeadAgents = 0
+	r.SweepSteps = nil
+	r.PublisherPhases = make(map[string]Summary)
+	r.PlayerPhases = make(map[string]Summary)
+	r.Disconnects = countsOf(DISCONNECT_CAUSES)
+	r.ClientStates = countsOf(CLIENT_STATES)
+	r.Clients = make(map[string]*StatItem)
+}
+
+// Returns copy of report taken under lock.
+// Maps, sweep steps and latency buckets of the copy are not shared
+// with the report. Clients statistic items are shared, they are
+// snapshots which are not changed after update.
+func (r *Report) Snapshot() *Report {
+	r.rlock()
+	defer r.runlock()
+	snapshot := *r
+	snapshot.mutex = nil
+	snapshot.LatencyHistogram = r.LatencyHistogram.Copy()
+	snapshot.StreamIntegrity = make(map[string]Integrity)
+	for key, integrity := range r.StreamIntegrity {
+		snapshot.StreamIntegrity[key] = integrity
+	}
+	snapshot.SequenceHeaders = copyCounts(r.SequenceHeaders)
+	snapshot.PublisherPhases = copySummaries(r.PublisherPhases)
+	snapshot.PlayerPhases = copySummaries(r.PlayerPhases)
+	snapshot.Disconnects = copyCounts(r.Disconnects)
+	snapshot.ClientStates = copyCounts(r.ClientStates)
+	snapshot.SweepSteps = append([]SweepStep(nil), r.SweepSteps...)
+	snapshot.Clients = make(map[string]*StatItem)
+	for id, client := range r.Clients {
+		snapshot.Clients[id] = client
+	}
+	return &snapshot
+}
+
+// Returns copy of report with scalar fields and counts by fixed keys.
+// Disconnects, sequence headers, client states and connection phases
+// are kept for comparison of test runs. Per-stream integrity, sweep steps,
+// clients statistic and latency buckets are dropped.
+func (r *Report) Scalars() Report {
+	r.rlock()
+	defer r.runlock()
+	scalars := *r
+	scalars.mutex = nil
+	scalars.LatencyHistogram.Counts = nil
+	scalars.StreamIntegrity = nil
+	scalars.SequenceHeaders = copyCounts(r.SequenceHeaders)
+	scalars.PublisherPhases = copySummaries(r.PublisherPhases)
+	scalars.PlayerPhases = copySummaries(r.PlayerPhases)
+	scalars.Disconnects = copyCounts(r.Disconnects)
+	scalars.ClientStates = copyCounts(r.ClientStates)
+	scalars.SweepSteps = nil
+	scalars.Clients = nil
+	return scalars
+}
+
+// Sets count of clients requested by current step of load profile.
+//
+// params: model_count  int   Count of models.
+//         client_count int   Total count of clients.
+func (r *Report) SetTarget(model_count int, client_count int) {
+	r.lock()
+	defer r.unlock()
+	r.TargetModelsCount = model_count
+	r.TargetClientsCount = client_count
 }
 
 // Updates stress test report.
 //
 // param: clients map   RTMP clients statistic map.
 func (r *Report) UpdateReport(clients map[string]*StatItem) {
+	r.lock()
+	defer r.unlock()
 	var total_model_fps int64 = 0
 	var total_client_fps int64 = 0
+	var total_model_bitrate int64 = 0
+	var total_client_bitrate int64 = 0
 	var publisher_video_start_delay_sum int64 = 0
 	var player_video_start_delay_sum int64 = 0
 	var publisher_audio_start_delay_sum int64 = 0
@@ -78,13 +220,77 @@ func (r *Report) UpdateReport(clients map[string]*StatItem) {
 	var audio_bytes_received int64 = 0
 	var published_total_time int64 = 0
 	var played_total_time int64 = 0
+	latency := Histogram{}
+	var total_play_time int64 = 0
+	integrity := Integrity{}
+	stream_integrity := make(map[string]Integrity)
+	sequence_headers := countsOf(sequenceHeaderKeys())
+	var keyframe_interval_sum int64 = 0
+	var gop_size_sum int64 = 0
+	var gop_players int64 = 0
+	var send_jitter_sum int64 = 0
+	var jitter_publishers int64 = 0
+	r.Clients = clients
 	r.ConnectedModelsCount = 0
 	r.ConnectedClientsCount = 0
+	r.DroppedFrames = 0
+	r.Reconnects = 0
+	r.TotalDowntime = 0
+	r.Stalls = 0
+	r.TotalStallTime = 0
+	r.MaxKeyframeInterval = 0
+	r.SendJitterP95 = 0
+	disconnects := countsOf(DISCONNECT_CAUSES)
+	client_states := countsOf(CLIENT_STATES)
 	for _, client := range clients {
+		r.DroppedFrames += client.DroppedFrames
+		r.Reconnects += client.Reconnects
+		r.TotalDowntime += client.Downtime
+		if client.Role == ROLE_PLAYER {
+			r.Stalls += client.Stalls
+			r.TotalStallTime += client.StallTime
+			total_play_time += client.PlayTime
+			if client.KeyframeInterval.Count > 0 {
+				gop_players++
+				keyframe_interval_sum += client.KeyframeInterval.Mean
+				gop_size_sum += client.GopSize.Mean
+				if client.KeyframeInterval.Max > r.MaxKeyframeInterval {
+					r.MaxKeyframeInterval = client.KeyframeInterval.Max
+				}
+			}
+			if client.Integrity.Frames > 0 {
+				integrity.Add(client.Integrity)
+				stream := stream_integrity[client.StreamID]
+				stream.Add(client.Integrity)
+				stream_integrity[client.StreamID] = stream
+			}
+		}
+		if client.Role == ROLE_PUBLISHER && client.SendJitter.Count > 0 {
+			jitter_publishers++
+			send_jitter_sum += client.SendJitter.Mean
+			if client.SendJitter.P95 > r.SendJitterP95 {
+				r.SendJitterP95 = client.SendJitter.P95
+			}
+		}
+		for cause, count := range client.Disconnects {
+			disconnects[cause] += count
+		}
+		if client.State != "" {
+			client_states[client.State]++
+		}
+		if client.VideoHeader != HEADER_NONE {
+			sequence_headers[sequenceHeaderKey(
+				client.Role, "video", client.VideoHeader)]++
+		}
+		if client.AudioHeader != HEADER_NONE {
+			sequence_headers[sequenceHeaderKey(
+				client.Role, "audio", client.AudioHeader)]++
+		}
 		if client.Role == ROLE_PUBLISHER &&
-			client.Status == STATUS_DESCRIPTIONS[5] && client.FPS > 0 {
+			IsStreaming(client.State) && client.FPS > 0 {
 			r.ConnectedModelsCount += 1
 			total_model_fps += client.FPS
+			total_model_bitrate += client.Bitrate
 			publisher_video_start_delay_sum += client.VideoStartUpTime
 			publisher_audio_start_delay_sum += client.AudioStartUpTime
 			video_bytes_sends += client.VideoBytes
@@ -92,33 +298,200 @@ func (r *Report) UpdateReport(clients map[string]*StatItem) {
 			published_total_time += client.TotalTime
 		}
 		if client.Role == ROLE_PLAYER &&
-			client.Status == STATUS_DESCRIPTIONS[5] && client.FPS > 0 {
+			IsStreaming(client.State) && client.FPS > 0 {
 			r.ConnectedClientsCount += 1
 			total_client_fps += client.FPS
+			total_client_bitrate += client.Bitrate
 			player_video_start_delay_sum += client.VideoStartUpTime
 			player_audio_start_delay_sum += client.AudioStartUpTime
 			video_bytes_received += client.VideoBytes
 			audio_bytes_received += client.AudioBytes
 			played_total_time += client.TotalTime
+			latency.Merge(client.LatencyHistogram)
 		}
-		r.ConnectedModelCountLag = int64(
-			r.RequestedModelsCount) - r.ConnectedModelsCount
-		r.ConnectedClientCountLag = int64(
-			r.RequestedClientsCount) - r.ConnectedClientsCount
-		connectionModelCount64 := int64(r.ConnectedModelsCount)
-		if connectionModelCount64 != 0 {
-			r.AverageModelFPS = total_model_fps / connectionModelCount64
-			r.AverageAudioBytesSends = audio_bytes_sends / connectionModelCount64 / 1024
-			r.AverageVideoBytesSends = video_bytes_sends / connectionModelCount64 / 1024
-			r.TotalVideoPublished = published_total_time / connectionModelCount64
+	}
+	r.ConnectedModelCountLag = int64(
+		r.TargetModelsCount) - r.ConnectedModelsCount
+	r.ConnectedClientCountLag = int64(
+		r.TargetClientsCount) - r.ConnectedClientsCount
+	r.AverageModelFPS = 0
+	r.AverageModelBitrate = 0
+	r.AverageAudioBytesSends = 0
+	r.AverageVideoBytesSends = 0
+	r.TotalVideoPublished = 0
+	r.AverageModelStartUpTime = 0
+	connectionModelCount64 := int64(r.ConnectedModelsCount)
+	if connectionModelCount64 != 0 {
+		r.AverageModelFPS = total_model_fps / connectionModelCount64
+		r.AverageModelBitrate = total_model_bitrate / connectionModelCount64
+		r.AverageAudioBytesSends = audio_bytes_sends / connectionModelCount64 / 1024
+		r.AverageVideoBytesSends = video_bytes_sends / connectionModelCount64 / 1024
+		r.TotalVideoPublished = published_total_time / connectionModelCount64
+		r.AverageModelStartUpTime = publisher_video_start_delay_sum / connectionModelCount64
+	}
+	r.AverageClientFPS = 0
+	r.AverageClientBitrate = 0
+	r.AverageAudioBytesReceived = 0
+	r.AverageVideoBytesReceived = 0
+	r.TotalVideoPlayed = 0
+	r.AverageClientStartUpTime = 0
+	connectedClientsCount64 := int64(r.ConnectedClientsCount)
+	if connectedClientsCount64 != 0 {
+		r.AverageClientFPS = total_client_fps / connectedClientsCount64
+		r.AverageClientBitrate = total_client_bitrate / connectedClientsCount64
+		r.AverageAudioBytesReceived = audio_bytes_received / connectedClientsCount64 / 1024
+		r.AverageVideoBytesReceived = video_bytes_received / connectedClientsCount64 / 1024
+		r.TotalVideoPlayed = played_total_time / connectedClientsCount64
+		r.AverageClientStartUpTime = player_video_start_delay_sum / connectedClientsCount64
+	}
+	r.TotalTime = time.Now().Unix() - r.StartTime
+	r.RebufferRatio = 0
+	if total_play_time > 0 {
+		r.RebufferRatio = float64(r.TotalStallTime) / float64(total_play_time)
+	}
+	r.AverageKeyframeInterval = 0
+	r.AverageGopSize = 0
+	if gop_players > 0 {
+		r.AverageKeyframeInterval = keyframe_interval_sum / gop_players
+		r.AverageGopSize = gop_size_sum / gop_players
+	}
+	r.AverageSendJitter = 0
+	if jitter_publishers > 0 {
+		r.AverageSendJitter = send_jitter_sum / jitter_publishers
+	}
+	r.SequenceHeaders = sequence_headers
+	integrity.UpdateRates()
+	r.Integrity = integrity
+	for key, stream := range stream_integrity {
+		stream.UpdateRates()
+		stream_integrity[key] = stream
+	}
+	r.StreamIntegrity = stream_integrity
+	r.LatencyHistogram = latency
+	r.LatencyP50 = latency.Percentile(50)
+	r.LatencyP95 = latency.Percentile(95)
+	r.LatencyP99 = latency.Percentile(99)
+	r.PublisherPhases = phaseSummaries(clients, ROLE_PUBLISHER, PUBLISHER_PHASES)
+	r.PlayerPhases = phaseSummaries(clients, ROLE_PLAYER, PLAYER_PHASES)
+	r.Disconnects = disconnects
+	r.ClientStates = client_states
+}
+
+// Sets current sweep step and results of sweep steps.
+//
+// params: step  int64         Current sweep step from 1.
+//         value int64         Swept parameter value of current step.
+//         steps []SweepStep   Results of sweep steps.
+func (r *Report) SetSweep(step int64, value int64, steps []SweepStep) {
+	r.lock()
+	defer r.unlock()
+	r.SweepStep = step
+	r.SweepValue = value
+	r.SweepSteps = steps
+}
+
+// Locks shared report for update.
+func (r *Report) lock() {
+	if r.mutex != nil {
+		r.mutex.Lock()
+	}
+}
+
+// Unlocks shared report after update.
+func (r *Report) unlock() {
+	if r.mutex != nil {
+		r.mutex.Unlock()
+	}
+}
+
+// Locks shared report for reading.
+func (r *Report) rlock() {
+	if r.mutex != nil {
+		r.mutex.RLock()
+	}
+}
+
+// Unlocks shared report after reading.
+func (r *Report) runlock() {
+	if r.mutex != nil {
+		r.mutex.RUnlock()
+	}
+}
+
+// Returns copy of counts.
+//
+// param: counts map[string]int64   Counts by key.
+func copyCounts(counts map[string]int64) map[string]int64 {
+	copied := make(map[string]int64)
+	for key, count := range counts {
+		copied[key] = count
+	}
+	return copied
+}
+
+// Returns copy of summaries.
+//
+// param: summaries map[string]Summary   Summaries by key.
+func copySummaries(summaries map[string]Summary) map[string]Summary {
+	copied := make(map[string]Summary)
+	for key, summary := range summaries {
+		copied[key] = summary
+	}
+	return copied
+}
+
+// Returns key of sequence headers arrival count.
+//
+// params: role   string   Role of RTMP client.
+//         kind   string   Header kind, video or audio.
+//         status string   Header arrival status.
+func sequenceHeaderKey(role string, kind string, status string) string {
+	return strings.TrimPrefix(role, "role_") + "_" + kind + "_" + status
+}
+
+// Returns all keys of sequence headers arrival counts.
+func sequenceHeaderKeys() []string {
+	var keys []string
+	for _, role := range []string{ROLE_PUBLISHER, ROLE_PLAYER} {
+		for _, kind := range []string{"video", "audio"} {
+			for _, status := range []string{HEADER_OK, HEADER_LATE, HEADER_MISSING} {
+				keys = append(keys, sequenceHeaderKey(role, kind, status))
+			}
 		}
-		connectedClientsCount64 := int64(r.ConnectedClientsCount)
-		if connectedClientsCount64 != 0 {
-			r.AverageClientFPS = total_client_fps / connectedClientsCount64
-			r.AverageAudioBytesReceived = audio_bytes_received / connectedClientsCount64 / 1024
-			r.AverageVideoBytesReceived = video_bytes_received / connectedClientsCount64 / 1024
-			r.TotalVideoPlayed = played_total_time / connectedClientsCount64
+	}
+	return keys
+}
+
+// Returns zero counts of keys.
+//
+// param: keys []string   Counted keys.
+func countsOf(keys []string) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, key := range keys {
+		counts[key] = 0
+	}
+	return counts
+}
+
+// Returns summaries of connection phases durations of clients with role.
+//
+// params: clients map        RTMP clients statistic map.
+//         role    string     Role of RTMP clients.
+//         phases  []string   Connection phases of role.
+func phaseSummaries(
+	clients map[string]*StatItem, role string, phases []string) map[string]Summary {
+	samples := make(map[string][]int64)
+	for _, client := range clients {
+		if client.Role != role {
+			continue
 		}
-		r.TotalTime = time.Now().Unix() - r.StartTime
+		for phase, duration := range client.Timings {
+			samples[phase] = append(samples[phase], duration)
+		}
+	}
+	summaries := make(map[string]Summary)
+	for _, phase := range phases {
+		summaries[phase] = NewSummary(samples[phase])
 	}
+	return summaries
 }
@@ -0,0 +1,34 @@
+package model
+
+import "testing"
+
+// Averages and lags are updated when all clients are gone.
+func TestUpdateReportWithoutClients(t *testing.T) {
+	report := NewReport("test")
+	report.ResetReport("test", 1, 2)
+	report.UpdateReport(map[string]*StatItem{
+		"publisher": {Role: ROLE_PUBLISHER, State: STATE_PUBLISHING,
+			FPS: 25, Bitrate: 1000},
+		"player": {Role: ROLE_PLAYER, State: STATE_PLAYING,
+			FPS: 20, Bitrate: 800},
+		"stalled": {Role: ROLE_PLAYER, State: STATE_STALLED, FPS: 20},
+	})
+	if report.ConnectedClientCountLag != 1 || report.AverageClientFPS != 20 ||
+		report.AverageModelFPS != 25 {
+		t.Fatalf("lag and averages of streaming clients are %d, %d/%d",
+			report.ConnectedClientCountLag, report.AverageModelFPS,
+			report.AverageClientFPS)
+	}
+
+	report.UpdateReport(map[string]*StatItem{})
+	if report.ConnectedModelCountLag != 1 || report.ConnectedClientCountLag != 2 {
+		t.Errorf("lags without clients are %d/%d, want 1/2",
+			report.ConnectedModelCountLag, report.ConnectedClientCountLag)
+	}
+	if report.AverageModelFPS != 0 || report.AverageModelBitrate != 0 ||
+		report.AverageClientFPS != 0 || report.AverageClientBitrate != 0 {
+		t.Errorf("averages without clients are %d/%d fps, %d/%d bps",
+			report.AverageModelFPS, report.AverageClientFPS,
+			report.AverageModelBitrate, report.AverageClientBitrate)
+	}
+}
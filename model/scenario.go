@@ -0,0 +1,149 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Placeholder of publisher number in stream key pattern.
+const STREAM_NUMBER_PLACEHOLDER = "{n}"
+
+// Default stream key pattern.
+const DEFAULT_STREAM_KEY = "model" + STREAM_NUMBER_PLACEHOLDER
+
+// Multi-phase stress test scenario.
+// Scenario is read from YAML or JSON file.
+type Scenario struct {
+	Name       string     `json:"name" yaml:"name"`             // Scenario name.
+	ServerURL  string     `json:"server" yaml:"server"`         // RTMP media server URL.
+	Phases     []*Phase   `json:"phases" yaml:"phases"`         // Test phases in run order.
+	Assertions Assertions `json:"assertions" yaml:"assertions"` // Test report assertions.
+}
+
+// Phase of stress test scenario.
+type Phase struct {
+	Name        string `json:"name" yaml:"name"`                 // Phase name.
+	Duration    int    `json:"duration" yaml:"duration"`         // Phase duration in seconds.
+	ModelCount  int    `json:"model_count" yaml:"model_count"`   // Count of model bots.
+	ClientCount int    `json:"client_count" yaml:"client_count"` // Count of client bots per model.
+	StreamKey   string `json:"stream_key" yaml:"stream_key"`     // Stream key pattern.
+	FlvFile     string `json:"flv_file" yaml:"flv_file"`         // Test flv file path or synthetic media source.
+}
+
+// Reads scenario from file.
+// Files with .yaml and .yml extensions are read as YAML,
+// any other files are read as JSON.
+//
+// param: file_name string   Scenario file path.
+func LoadScenario(file_name string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(file_name)
+	if err != nil {
+		return nil, err
+	}
+	scenario := &Scenario{}
+	switch strings.ToLower(filepath.Ext(file_name)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, scenario)
+	default:
+		err = json.Unmarshal(data, scenario)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not parse scenario %s: %s", file_name, err)
+	}
+	if err = scenario.Validate(); err != nil {
+		return nil, err
+	}
+	return scenario, nil
+}
+
+// Checks scenario phases.
+// Only the last phase may have no duration, in that case the phase lasts
+// until the test is stopped.
+func (s *Scenario) Validate() error {
+	if len(s.Phases) == 0 {
+		return errors.New("scenario has no phases")
+	}
+	for i, phase := range s.Phases {
+		if phase.ModelCount < 0 || phase.ClientCount < 0 {
+			return fmt.Errorf("phase %d: negative clients count", i+1)
+		}
+		if phase.Duration < 0 || phase.Duration == 0 && i != len(s.Phases)-1 {
+			return fmt.Errorf("phase %d: invalid duration %d", i+1, phase.Duration)
+		}
+		if phase.StreamKey != "" && phase.ModelCount > 1 &&
+			!strings.Contains(phase.StreamKey, STREAM_NUMBER_PLACEHOLDER) {
+			return fmt.Errorf(
+				"phase %d: stream key %s has no %s placeholder",
+				i+1, phase.StreamKey, STREAM_NUMBER_PLACEHOLDER)
+		}
+	}
+	return nil
+}
+
+// Returns phase running at the time elapsed since test start.
+// Returns -1 and nil when all phases are finished.
+//
+// param: elapsed time.Duration   Time elapsed since test start.
+func (s *Scenario) PhaseAt(elapsed time.Duration) (int, *Phase) {
+	var ends_at time.Duration
+	for i, phase := range s.Phases {
+		if phase.Duration == 0 {
+			return i, phase
+		}
+		ends_at += time.Duration(phase.Duration) * time.Second
+		if elapsed < ends_at {
+			return i, phase
+		}
+	}
+	return -1, nil
+}
+
+// Returns total duration of scenario.
+// Returns 0 if the last phase lasts until the test is stopped.
+func (s *Scenario) Duration() time.Duration {
+	var duration time.Duration
+	for _, phase := range s.Phases {
+		if phase.Duration == 0 {
+			return 0
+		}
+		duration += time.Duration(phase.Duration) * time.Second
+	}
+	return duration
+}
+
+// Returns the maximal counts of models and clients per model
+// over all phases.
+func (s *Scenario) MaxCounts() (int, int) {
+	model_count, client_count := 0, 0
+	for _, phase := range s.Phases {
+		if phase.ModelCount > model_count {
+			model_count = phase.ModelCount
+		}
+		if phase.ClientCount > client_count {
+			client_count = phase.ClientCount
+		}
+	}
+	return model_count, client_count
+}
+
+// Returns stream keys of phase publishers.
+func (p *Phase) StreamKeys() []string {
+	pattern := p.StreamKey
+	if pattern == "" {
+		pattern = DEFAULT_STREAM_KEY
+	}
+	keys := make([]string, p.ModelCount)
+	for i := range keys {
+		keys[i] = strings.Replace(
+			pattern, STREAM_NUMBER_PLACEHOLDER, strconv.Itoa(i+1), -1)
+	}
+	return keys
+}
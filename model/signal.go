@@ -7,7 +7,6 @@ const (
 	STREAM_CREATE string = "stream_create"
 	PUBLISH_START string = "publish_start"
 	PLAY_STREAM   string = "play_stream"
-	ADD_FRAME     string = "add_frame"
 )
 
 // Relation of signals model.
@@ -0,0 +1,133 @@
+package model
+
+import "time"
+
+// Default video gap or lag of player considered as stall in milliseconds.
+const DEFAULT_STALL_THRESHOLD = 1000
+
+// Detector of player stalls.
+// Player stalls if it receives no video longer than threshold or
+// if video timestamps fall behind wall clock more than threshold.
+// Stalls are detected while connection plays, time without connection
+// is downtime instead.
+// Detector is not guarded, player calls it under its own lock.
+type StallDetector struct {
+	threshold     time.Duration // Video gap or lag considered as stall.
+	started       time.Time     // Start of the current connection playback.
+	first_ts      uint32        // The first video timestamp of connection.
+	last_video    time.Time     // The latest video receiving time.
+	base_lag      time.Duration // Lag of timestamps behind wall clock after the latest rebuffer.
+	stalled       bool          // Player waits for video.
+	stall_started time.Time     // Start of the current stall.
+	stalls        int64         // Count of stalls.
+	stall_time    time.Duration // Total duration of finished stalls.
+	play_time     time.Duration // Total playback time of previous connections.
+}
+
+// Returns new stall detector.
+//
+// param: threshold time.Duration   Video gap or lag considered as stall,
+//                                  DEFAULT_STALL_THRESHOLD if it is not positive.
+func NewStallDetector(threshold time.Duration) *StallDetector {
+	if threshold <= 0 {
+		threshold = DEFAULT_STALL_THRESHOLD * time.Millisecond
+	}
+	return &StallDetector{threshold: threshold}
+}
+
+// Handles received video message.
+// Finishes the current stall or detects timestamps lag.
+//
+// params: now       time.Time   Receiving time.
+//         timestamp uint32      Video timestamp in milliseconds.
+func (d *StallDetector) OnVideo(now time.Time, timestamp uint32) {
+	if d.started.IsZero() {
+		d.started = now
+		d.first_ts = timestamp
+		d.base_lag = 0
+	}
+	lag := now.Sub(d.started) -
+		time.Duration(timestamp-d.first_ts)*time.Millisecond
+	switch {
+	case d.stalled:
+		d.stall_time += now.Sub(d.stall_started)
+		d.stalled = false
+		d.base_lag = lag
+	case lag-d.base_lag > d.threshold:
+		// Playback has waited for this frame, so the lag is rebuffering.
+		d.stalls++
+		d.stall_time += lag - d.base_lag
+		d.base_lag = lag
+	case lag < d.base_lag:
+		d.base_lag = lag
+	}
+	d.last_video = now
+}
+
+// Detects stall of playing connection without video.
+// Returns true if player is stalled.
+//
+// param: now time.Time   Current time.
+func (d *StallDetector) Check(now time.Time) bool {
+	if !d.stalled && !d.started.IsZero() &&
+		now.Sub(d.last_video) > d.threshold {
+		d.stalled = true
+		d.stall_started = d.last_video
+		d.stalls++
+	}
+	return d.stalled
+}
+
+// Finishes playback of lost connection.
+// The current stall is finished at connection loss.
+//
+// param: now time.Time   Connection loss time.
+func (d *StallDetector) Interrupt(now time.Time) {
+	if d.started.IsZero() {
+		return
+	}
+	if d.stalled {
+		d.stall_time += now.Sub(d.stall_started)
+		d.stalled = false
+	}
+	d.play_time += now.Sub(d.started)
+	d.started = time.Time{}
+}
+
+// Returns count of stalls.
+func (d *StallDetector) Stalls() int64 {
+	return d.stalls
+}
+
+// Returns total stall duration.
+//
+// param: now time.Time   Current time.
+func (d *StallDetector) StallTime(now time.Time) time.Duration {
+	stall_time := d.stall_time
+	if d.stalled {
+		stall_time += now.Sub(d.stall_started)
+	}
+	return stall_time
+}
+
+// Returns total playback duration including stalls.
+//
+// param: now time.Time   Current time.
+func (d *StallDetector) PlayTime(now time.Time) time.Duration {
+	play_time := d.play_time
+	if !d.started.IsZero() {
+		play_time += now.Sub(d.started)
+	}
+	return play_time
+}
+
+// Returns part of playback time spent in stalls.
+//
+// param: now time.Time   Current time.
+func (d *StallDetector) RebufferRatio(now time.Time) float64 {
+	play_time := d.PlayTime(now)
+	if play_time <= 0 {
+		return 0
+	}
+	return float64(d.StallTime(now)) / float64(play_time)
+}
@@ -2,7 +2,42 @@ package model
 
 // Value object of start test HTTP request.
 type StartRequest struct {
-	ServerURL   string `schema:"server"`       // RTMP media server URL.
-	ModelCount  int    `schema:"model_count"`  // Count of model bots.
-	ClientCount int    `schema:"client_count"` // Count of client bots.
+	ServerURL       string    `schema:"server"`              // RTMP media server URL.
+	ModelCount      int       `schema:"model_count"`         // Count of model bots.
+	ClientCount     int       `schema:"client_count"`        // Count of client bots.
+	ScenarioFile    string    `schema:"scenario"`            // Test scenario file path.
+	Scenario        *Scenario `schema:"-"`                   // Loaded test scenario.
+	Transport       string    `schema:"transport"`           // RTMP transport name.
+	FrameQueueSize  int       `schema:"frame_queue_size"`    // Capacity of publisher frame queue.
+	OverflowPolicy  string    `schema:"overflow_policy"`     // Policy of full frame queue.
+	FlvFiles        string    `schema:"flv_files"`           // Comma separated test flv files or globs.
+	Independent     bool      `schema:"independent_sources"` // Each publisher reads its own flv file.
+	RandomOffset    bool      `schema:"random_offset"`       // Publishers start from random file position.
+	StallThreshold  int       `schema:"stall_threshold"`     // Video gap or lag of player considered as stall in ms.
+	VerifyIntegrity bool      `schema:"verify_integrity"`    // Publishers tag frames for integrity verification.
+	StreamOffset    int       `schema:"stream_offset"`       // Number of the first stream minus one.
+	LoadProfile               // Ramp-up and ramp-down load profile.
+	TLSOptions                // TLS options of RTMPS connections.
+	ReconnectPolicy           // Reconnect policy of RTMP clients.
+	Sweep                     // Sweep of publishers media parameter.
+	Pacing                    // Pacing of test media sources.
+}
+
+// Loads test scenario file if it is requested.
+// Media server URL is taken from scenario if it is not requested.
+// Counts of models and clients are set to maximal counts of scenario.
+func (r *StartRequest) LoadScenario() error {
+	if r.ScenarioFile == "" {
+		return nil
+	}
+	scenario, err := LoadScenario(r.ScenarioFile)
+	if err != nil {
+		return err
+	}
+	r.Scenario = scenario
+	if r.ServerURL == "" {
+		r.ServerURL = scenario.ServerURL
+	}
+	r.ModelCount, r.ClientCount = scenario.MaxCounts()
+	return nil
 }
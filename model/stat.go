@@ -25,12 +25,34 @@ type StatItem struct {
 	ClientID         string               // RTMP client  identifier.
 	AudioBytes       int64                // Processed audio bytes.
 	VideoBytes       int64                // Processed video bytes.
-	VideoStartUpTime int64                // Video publish/play startup time in second.
-	AudioStartUpTime int64                // Audio publish/play startup time in second.
+	VideoStartUpTime int64                // Video publish/play startup time in milliseconds.
+	AudioStartUpTime int64                // Audio publish/play startup time in milliseconds.
 	TotalTime        int64                // Total publish/play time in second.
 	FPS              int64                // Frames per second.
-	Receivers        map[string]*StatItem // Stream receivers map (for publisher only).
+	Bitrate          int64                // Bits per second of the last statistic interval.
+	Receivers        map[string]*StatItem `json:"-"` // Stream receivers map (for publisher only).
 	TotalFrames      int64                // Total count of processed RTMP frames.
+	DroppedFrames    int64                // Frames dropped by full frame queue (for publisher only).
+	Reconnects       int64                // Count of reconnect attempts.
+	Downtime         int64                // Total time without connection after its loss in milliseconds.
+	Stalls           int64                // Count of playback stalls (for player only).
+	StallTime        int64                // Total stalls duration in milliseconds (for player only).
+	PlayTime         int64                // Playback time including stalls in milliseconds (for player only).
+	RebufferRatio    float64              // Part of playback time spent in stalls (for player only).
+	Integrity        Integrity            // Received frames integrity (for player only).
+	VideoCodec       string               // Video codec name.
+	AudioCodec       string               // Audio codec name.
+	KeyframeInterval Summary              // Keyframe intervals in milliseconds.
+	GopSize          Summary              // GOP sizes in frames.
+	VideoHeader      string               // AVC sequence header arrival status of connection.
+	AudioHeader      string               // AAC sequence header arrival status of connection.
+	Latency          Summary              // End-to-end latency in milliseconds (for player only).
+	SendJitter       Summary              // Deviation of frames sending from schedule in milliseconds (for publisher only).
+	LatencyHistogram Histogram            `json:"-"` // End-to-end latency histogram in milliseconds (for player only).
+	Timings          map[string]int64     // Connection phases durations in nanoseconds.
+	State            string               // Lifecycle state.
+	Transitions      []Transition         // The latest lifecycle transitions.
+	Disconnects      map[string]int64     // Count of disconnects by cause.
 }
 
 // Constructs new StatItem instance.
@@ -53,7 +75,28 @@ func NewStatItem(role string, stream_id string, client_id string) *StatItem {
 		AudioStartUpTime: 0,
 		TotalTime:        0,
 		FPS:              0,
+		Bitrate:          0,
 		Receivers:        make(map[string]*StatItem),
 		TotalFrames:      0,
+		Timings:          make(map[string]int64),
+		Disconnects:      make(map[string]int64),
 	}
 }
+
+// Returns copy of statistic item.
+// Receivers of copy are not copied.
+func (s *StatItem) Snapshot() *StatItem {
+	snapshot := *s
+	snapshot.Receivers = make(map[string]*StatItem)
+	snapshot.Timings = make(map[string]int64)
+	for phase, duration := range s.Timings {
+		snapshot.Timings[phase] = duration
+	}
+	snapshot.Transitions = append([]Transition(nil), s.Transitions...)
+	snapshot.LatencyHistogram = s.LatencyHistogram.Copy()
+	snapshot.Disconnects = make(map[string]int64)
+	for cause, count := range s.Disconnects {
+		snapshot.Disconnects[cause] = count
+	}
+	return &snapshot
+}
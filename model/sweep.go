@@ -0,0 +1,184 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Swept parameters of publishers media.
+const (
+	SWEEP_BITRATE string = "bitrate" // Video bitrate in bits per second.
+	SWEEP_FPS     string = "fps"     // Video frame rate.
+)
+
+// Sweep of publishers media parameter.
+// Counts of clients are held while the parameter steps through the values,
+// each step lasts the same time. Sweep changes synthetic media sources only.
+type Sweep struct {
+	SweepParameter string `schema:"sweep"`        // Swept parameter, empty disables sweep.
+	SweepValues    string `schema:"sweep_values"` // Comma separated parameter values of steps.
+	SweepStepTime  int    `schema:"sweep_step"`   // Duration of each step in seconds.
+}
+
+// Returns true if sweep is requested.
+func (s *Sweep) Enabled() bool {
+	return s.SweepParameter != ""
+}
+
+// Returns parameter values of sweep steps.
+// Values may have "k" or "m" multiplier suffix.
+func (s *Sweep) Steps() ([]int, error) {
+	if s.SweepParameter != SWEEP_BITRATE && s.SweepParameter != SWEEP_FPS {
+		return nil, fmt.Errorf("unknown sweep parameter %s", s.SweepParameter)
+	}
+	if s.SweepStepTime <= 0 {
+		return nil, fmt.Errorf("sweep step time %d is not positive",
+			s.SweepStepTime)
+	}
+	var steps []int
+	for _, value := range s.values() {
+		step, err := parseRate(value)
+		if err != nil {
+			return nil, fmt.Errorf("sweep value %s: %s", value, err.Error())
+		}
+		if step <= 0 {
+			return nil, fmt.Errorf("sweep value %d is not positive", step)
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("sweep values not specified")
+	}
+	return steps, nil
+}
+
+// Returns index of sweep step at the time elapsed since test start.
+// Returns -1 if sweep is disabled or finished.
+//
+// param: elapsed time.Duration   Time elapsed since test start.
+func (s *Sweep) StepAt(elapsed time.Duration) int {
+	if !s.Enabled() || s.SweepStepTime <= 0 || elapsed < 0 {
+		return -1
+	}
+	index := int(elapsed / (time.Duration(s.SweepStepTime) * time.Second))
+	if index >= len(s.values()) {
+		return -1
+	}
+	return index
+}
+
+// Returns total duration of sweep or 0 if sweep is disabled.
+func (s *Sweep) Duration() time.Duration {
+	if !s.Enabled() {
+		return 0
+	}
+	return time.Duration(len(s.values())*s.SweepStepTime) * time.Second
+}
+
+// Returns not empty values of steps.
+func (s *Sweep) values() []string {
+	var values []string
+	for _, value := range strings.Split(s.SweepValues, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Result of sweep step.
+type SweepStep struct {
+	Parameter            string // Swept parameter.
+	Value                int    // Parameter value of step.
+	StartTime            int64  // Step start since test start in seconds.
+	Duration             int64  // Measured step duration in seconds.
+	Samples              int64  // Count of report updates during step.
+	ConnectedModels      int64  // Average count of connected publishers.
+	ConnectedClients     int64  // Average count of connected players.
+	AverageModelFPS      int64  // Average publisher FPS.
+	AverageClientFPS     int64  // Average player FPS.
+	AverageModelBitrate  int64  // Average publisher bitrate in bits per second.
+	AverageClientBitrate int64  // Average player bitrate in bits per second.
+	LatencyP95           int64  // Maximal 95th percentile of players latency in ms.
+	DroppedFrames        int64  // Frames dropped by publishers during step.
+	Reconnects           int64  // Reconnect attempts during step.
+	Stalls               int64  // Playback stalls during step.
+	StallTime            int64  // Stalls duration during step in ms.
+}
+
+// Sums of report values of the current sweep step.
+type sweepSums struct {
+	connected_models  int64 // Sum of connected publishers counts.
+	connected_clients int64 // Sum of connected players counts.
+	model_fps         int64 // Sum of publishers average FPS.
+	client_fps        int64 // Sum of players average FPS.
+	model_bitrate     int64 // Sum of publishers average bitrate.
+	client_bitrate    int64 // Sum of players average bitrate.
+}
+
+// Tracker of sweep steps results.
+// Averages are computed over report updates of step, counters are
+// differences of report counters since step start.
+// Tracker is not guarded, launcher calls it from statistic loop only.
+type SweepTracker struct {
+	parameter string      // Swept parameter.
+	index     int         // Index of the current step.
+	steps     []SweepStep // Results of started steps.
+	sums      sweepSums   // Sums of the current step.
+	base      Report      // Report at the current step start.
+}
+
+// Returns new sweep tracker.
+//
+// param: parameter string   Swept parameter.
+func NewSweepTracker(parameter string) *SweepTracker {
+	return &SweepTracker{parameter: parameter, index: -1}
+}
+
+// Adds updated report to result of sweep step.
+//
+// params: report  *Report         Updated stress test report.
+//         index   int             Index of the current step.
+//         value   int             Parameter value of the current step.
+//         elapsed time.Duration   Time elapsed since test start.
+func (t *SweepTracker) Update(
+	report *Report, index int, value int, elapsed time.Duration) {
+	if index != t.index {
+		t.index = index
+		t.sums = sweepSums{}
+		t.base = *report
+		t.steps = append(t.steps, SweepStep{
+			Parameter: t.parameter,
+			Value:     value,
+			StartTime: int64(elapsed / time.Second),
+		})
+	}
+	step := &t.steps[len(t.steps)-1]
+	t.sums.connected_models += report.ConnectedModelsCount
+	t.sums.connected_clients += report.ConnectedClientsCount
+	t.sums.model_fps += report.AverageModelFPS
+	t.sums.client_fps += report.AverageClientFPS
+	t.sums.model_bitrate += report.AverageModelBitrate
+	t.sums.client_bitrate += report.AverageClientBitrate
+	step.Samples++
+	step.Duration = int64(elapsed/time.Second) - step.StartTime
+	step.ConnectedModels = t.sums.connected_models / step.Samples
+	step.ConnectedClients = t.sums.connected_clients / step.Samples
+	step.AverageModelFPS = t.sums.model_fps / step.Samples
+	step.AverageClientFPS = t.sums.client_fps / step.Samples
+	step.AverageModelBitrate = t.sums.model_bitrate / step.Samples
+	step.AverageClientBitrate = t.sums.client_bitrate / step.Samples
+	if report.LatencyP95 > step.LatencyP95 {
+		step.LatencyP95 = report.LatencyP95
+	}
+	step.DroppedFrames = report.DroppedFrames - t.base.DroppedFrames
+	step.Reconnects = report.Reconnects - t.base.Reconnects
+	step.Stalls = report.Stalls - t.base.Stalls
+	step.StallTime = report.TotalStallTime - t.base.TotalStallTime
+}
+
+// Returns copy of steps results.
+func (t *SweepTracker) Steps() []SweepStep {
+	return append([]SweepStep(nil), t.steps...)
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/instrumentisto/go-rtmp-bot/media"
+)
+
+// Name of synthetic media source used in place of test flv file path.
+// Parameters follow the name as URL query, for example
+// "synthetic:width=1920&height=1080&bitrate=4000k&fps=60".
+const SYNTHETIC_SOURCE = "synthetic"
+
+// Default parameters of synthetic media.
+const (
+	DEFAULT_SYNTHETIC_WIDTH      = 1280    // Video width in pixels.
+	DEFAULT_SYNTHETIC_HEIGHT     = 720     // Video height in pixels.
+	DEFAULT_SYNTHETIC_BITRATE    = 2000000 // Video bitrate in bits per second.
+	DEFAULT_SYNTHETIC_FPS        = 30      // Video frame rate.
+	DEFAULT_SYNTHETIC_GOP        = 60      // Video frames of group of pictures.
+	DEFAULT_SYNTHETIC_AUDIO_RATE = 44100   // Audio sample rate in Hz.
+	DEFAULT_SYNTHETIC_CHANNELS   = 2       // Count of audio channels.
+)
+
+// Parameters of synthetic media source.
+type SyntheticMedia struct {
+	Width     int // Video width in pixels.
+	Height    int // Video height in pixels.
+	Bitrate   int // Video bitrate in bits per second.
+	FPS       int // Video frame rate.
+	GOP       int // Video frames of group of pictures.
+	AudioRate int // Audio sample rate in Hz, zero disables audio.
+	Channels  int // Count of audio channels, 1 or 2.
+}
+
+// Returns true if source path is synthetic media source.
+//
+// param: path string   Test flv file path or synthetic media source.
+func IsSyntheticSource(path string) bool {
+	return path == SYNTHETIC_SOURCE ||
+		strings.HasPrefix(path, SYNTHETIC_SOURCE+":")
+}
+
+// Returns synthetic media parameters of source.
+// Not specified parameters have default values.
+//
+// param: source string   Synthetic media source.
+func ParseSyntheticMedia(source string) (*SyntheticMedia, error) {
+	if !IsSyntheticSource(source) {
+		return nil, fmt.Errorf("%s is not synthetic media source", source)
+	}
+	m := &SyntheticMedia{
+		Width:     DEFAULT_SYNTHETIC_WIDTH,
+		Height:    DEFAULT_SYNTHETIC_HEIGHT,
+		Bitrate:   DEFAULT_SYNTHETIC_BITRATE,
+		FPS:       DEFAULT_SYNTHETIC_FPS,
+		GOP:       DEFAULT_SYNTHETIC_GOP,
+		AudioRate: DEFAULT_SYNTHETIC_AUDIO_RATE,
+		Channels:  DEFAULT_SYNTHETIC_CHANNELS,
+	}
+	query, err := url.ParseQuery(
+		strings.TrimPrefix(source[len(SYNTHETIC_SOURCE):], ":"))
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]*int{
+		"width":      &m.Width,
+		"height":     &m.Height,
+		"bitrate":    &m.Bitrate,
+		"fps":        &m.FPS,
+		"gop":        &m.GOP,
+		"audio_rate": &m.AudioRate,
+		"channels":   &m.Channels,
+	}
+	for name, values := range query {
+		field, ok := fields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown synthetic media parameter %s", name)
+		}
+		if *field, err = parseRate(values[len(values)-1]); err != nil {
+			return nil, fmt.Errorf("synthetic media %s: %s", name, err.Error())
+		}
+	}
+	return m, m.Validate()
+}
+
+// Checks synthetic media parameters.
+func (m *SyntheticMedia) Validate() error {
+	switch {
+	case m.Width <= 0 || m.Height <= 0 || m.Width%2 != 0 || m.Height%2 != 0:
+		return fmt.Errorf("synthetic video size %dx%d is not positive even",
+			m.Width, m.Height)
+	case m.FPS <= 0:
+		return fmt.Errorf("synthetic video fps %d is not positive", m.FPS)
+	case m.Bitrate < 0:
+		return fmt.Errorf("synthetic video bitrate %d is negative", m.Bitrate)
+	case m.GOP <= 0:
+		return fmt.Errorf("synthetic video gop %d is not positive", m.GOP)
+	case m.Channels != 1 && m.Channels != 2:
+		return fmt.Errorf("synthetic audio channels %d are not 1 or 2",
+			m.Channels)
+	}
+	if _, ok := media.AACSampleRateIndex(m.AudioRate); !ok && m.AudioRate != 0 {
+		return fmt.Errorf("synthetic audio rate %d is not AAC sample rate",
+			m.AudioRate)
+	}
+	return nil
+}
+
+// Returns synthetic media source of parameters.
+func (m *SyntheticMedia) Source() string {
+	return fmt.Sprintf(
+		"%s:width=%d&height=%d&bitrate=%d&fps=%d&gop=%d&audio_rate=%d&channels=%d",
+		SYNTHETIC_SOURCE, m.Width, m.Height, m.Bitrate, m.FPS, m.GOP,
+		m.AudioRate, m.Channels)
+}
+
+// Returns integer value with optional "k" or "m" multiplier suffix.
+//
+// param: value string   Parameter value.
+func parseRate(value string) (int, error) {
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(value, "k"), strings.HasSuffix(value, "K"):
+		multiplier = 1000
+	case strings.HasSuffix(value, "m"), strings.HasSuffix(value, "M"):
+		multiplier = 1000000
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	return number * multiplier, nil
+}
@@ -0,0 +1,107 @@
+package model
+
+import "sync"
+
+// Maximal count of kept report snapshots.
+// Snapshots are downsampled twice when the count is reached.
+const MAX_SNAPSHOTS = 3600
+
+// Result of stress test.
+// Keeps time series of report snapshots and the final clients statistic.
+type TestResult struct {
+	mutex      sync.Mutex
+	ticks      int                  // Count of added statistic ticks.
+	TestId     string               // Test ID.
+	StartTime  int64                // Test start UNIX time.
+	Interval   int                  // Statistic ticks per snapshot.
+	Snapshots  []Report             // Scalar report snapshots per interval.
+	Report     *Report              // The latest full report.
+	Clients    map[string]*StatItem // The latest clients statistic.
+	Latency    Summary              // Latency of players in milliseconds.
+	SweepSteps []SweepStep          // Results of sweep steps.
+}
+
+// Returns new test result instance.
+//
+// param: report *Report   Stress test report.
+func NewTestResult(report *Report) *TestResult {
+	return &TestResult{
+		TestId:    report.TestId,
+		StartTime: report.StartTime,
+		Interval:  1,
+		Clients:   make(map[string]*StatItem),
+	}
+}
+
+// Adds snapshot of report and replaces clients statistic.
+// Snapshots keep scalar report fields and counts by fixed keys only,
+// the latest report is kept in full. Sweep steps results are kept once
+// for the whole result.
+//
+// params: report  *Report                Updated stress test report.
+//         clients map[string]*StatItem   Clients statistic.
+func (r *TestResult) AddSnapshot(
+	report *Report, clients map[string]*StatItem) {
+	full := report.Snapshot()
+	full.Clients = nil
+	client_snapshots := make(map[string]*StatItem)
+	for id, client := range clients {
+		client_snapshots[id] = client.Snapshot()
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.ticks%r.Interval == 0 {
+		r.Snapshots = append(r.Snapshots, full.Scalars())
+		if len(r.Snapshots) >= MAX_SNAPSHOTS {
+			r.downsample()
+		}
+	}
+	r.ticks++
+	r.Report = full
+	r.Clients = client_snapshots
+	r.Latency = full.LatencyHistogram.Summary()
+	r.SweepSteps = full.SweepSteps
+}
+
+// Keeps every second snapshot and doubles snapshots interval.
+func (r *TestResult) downsample() {
+	kept := r.Snapshots[:0]
+	for i := 0; i < len(r.Snapshots); i += 2 {
+		kept = append(kept, r.Snapshots[i])
+	}
+	for i := len(kept); i < len(r.Snapshots); i++ {
+		r.Snapshots[i] = Report{}
+	}
+	r.Snapshots = kept
+	r.Interval *= 2
+}
+
+// Returns copy of test result.
+func (r *TestResult) Copy() *TestResult {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return &TestResult{
+		TestId:     r.TestId,
+		StartTime:  r.StartTime,
+		Interval:   r.Interval,
+		Snapshots:  append([]Report(nil), r.Snapshots...),
+		Report:     r.Report,
+		Clients:    r.Clients,
+		Latency:    r.Latency,
+		SweepSteps: r.SweepSteps,
+	}
+}
+
+// Returns the latest full report or the latest snapshot of
+// loaded result without full report, nil if there are no snapshots.
+func (r *TestResult) Final() *Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.Report != nil {
+		return r.Report
+	}
+	if len(r.Snapshots) == 0 {
+		return nil
+	}
+	return &r.Snapshots[len(r.Snapshots)-1]
+}
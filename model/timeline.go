@@ -0,0 +1,134 @@
+package model
+
+import (
+	"sync"
+	"time"
+)
+
+// RTMP connection lifecycle phases.
+const (
+	PHASE_DIAL          string = "dial"          // TCP connection.
+	PHASE_TLS_HANDSHAKE string = "tls_handshake" // TLS handshake of RTMPS.
+	PHASE_HANDSHAKE     string = "handshake"     // RTMP handshake.
+	PHASE_CONNECT       string = "connect"       // RTMP connect command.
+	PHASE_CREATE_STREAM string = "create_stream" // RTMP createStream command.
+	PHASE_PUBLISH       string = "publish"       // RTMP publish command.
+	PHASE_PLAY          string = "play"          // RTMP play command.
+	PHASE_FIRST_VIDEO   string = "first_video"   // First video message.
+	PHASE_FIRST_AUDIO   string = "first_audio"   // First audio message.
+	PHASE_FIRST_KEY     string = "first_key"     // First video keyframe.
+)
+
+// Lifecycle phases of RTMP publisher.
+var PUBLISHER_PHASES = []string{
+	PHASE_DIAL, PHASE_TLS_HANDSHAKE, PHASE_HANDSHAKE, PHASE_CONNECT,
+	PHASE_CREATE_STREAM, PHASE_PUBLISH, PHASE_FIRST_VIDEO, PHASE_FIRST_AUDIO,
+	PHASE_FIRST_KEY,
+}
+
+// Lifecycle phases of RTMP player.
+var PLAYER_PHASES = []string{
+	PHASE_DIAL, PHASE_TLS_HANDSHAKE, PHASE_HANDSHAKE, PHASE_CONNECT,
+	PHASE_CREATE_STREAM, PHASE_PLAY, PHASE_FIRST_VIDEO, PHASE_FIRST_AUDIO,
+	PHASE_FIRST_KEY,
+}
+
+// Phases which the phase duration is measured from.
+// The first existing phase is used.
+// Phase without base phases is measured from the timeline start.
+var phase_bases = map[string][]string{
+	PHASE_TLS_HANDSHAKE: {PHASE_DIAL},
+	PHASE_HANDSHAKE:     {PHASE_TLS_HANDSHAKE, PHASE_DIAL},
+	PHASE_CONNECT:       {PHASE_HANDSHAKE},
+	PHASE_CREATE_STREAM: {PHASE_CONNECT},
+	PHASE_PUBLISH:       {PHASE_CREATE_STREAM},
+	PHASE_PLAY:          {PHASE_CREATE_STREAM},
+	PHASE_FIRST_VIDEO:   {PHASE_PUBLISH, PHASE_PLAY},
+	PHASE_FIRST_AUDIO:   {PHASE_PUBLISH, PHASE_PLAY},
+	PHASE_FIRST_KEY:     {PHASE_PUBLISH, PHASE_PLAY},
+}
+
+// Timeline of RTMP connection lifecycle.
+// Keeps monotonic time of each phase completion.
+type ConnTimeline struct {
+	mutex   sync.Mutex
+	started time.Time            // Timeline start time.
+	marks   map[string]time.Time // Phases completion time.
+}
+
+// Returns new connection timeline instance.
+func NewConnTimeline() *ConnTimeline {
+	return &ConnTimeline{
+		marks: make(map[string]time.Time),
+	}
+}
+
+// Starts timeline.
+// Resets all marked phases.
+func (t *ConnTimeline) Start() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.started = time.Now()
+	t.marks = make(map[string]time.Time)
+}
+
+// Marks phase completion.
+// Only the first completion of phase is kept.
+//
+// param: phase string   Lifecycle phase.
+func (t *ConnTimeline) Mark(phase string) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.marks[phase]; !ok {
+		t.marks[phase] = now
+	}
+}
+
+// Returns true if phase is completed.
+//
+// param: phase string   Lifecycle phase.
+func (t *ConnTimeline) IsMarked(phase string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, ok := t.marks[phase]
+	return ok
+}
+
+// Returns time passed from timeline start till phase completion.
+// Returns 0 if the phase is not completed.
+//
+// param: phase string   Lifecycle phase.
+func (t *ConnTimeline) Elapsed(phase string) time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	mark, ok := t.marks[phase]
+	if !ok {
+		return 0
+	}
+	return mark.Sub(t.started)
+}
+
+// Returns durations of completed phases in nanoseconds.
+func (t *ConnTimeline) Durations() map[string]int64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	durations := make(map[string]int64)
+	for phase, mark := range t.marks {
+		base := t.started
+		for _, base_phase := range phase_bases[phase] {
+			if base_mark, ok := t.marks[base_phase]; ok {
+				base = base_mark
+				break
+			}
+		}
+		durations[phase] = int64(mark.Sub(base))
+	}
+	return durations
+}
@@ -0,0 +1,11 @@
+package model
+
+// TLS options of RTMPS connections.
+// The options are used only for "rtmps://" media server URLs.
+type TLSOptions struct {
+	TLSCAFile             string `schema:"tls_ca"`          // PEM CA bundle file path.
+	TLSCertFile           string `schema:"tls_cert"`        // PEM client certificate file path.
+	TLSKeyFile            string `schema:"tls_key"`         // PEM client private key file path.
+	TLSServerName         string `schema:"tls_server_name"` // SNI server name, defaults to URL host.
+	TLSInsecureSkipVerify bool   `schema:"tls_insecure"`    // Skips server certificate verification.
+}
@@ -1,12 +1,14 @@
 package player
 
 import (
-	rtmp "github.com/zhangpeihao/gortmp"
 	"log"
 
 	"github.com/instrumentisto/go-rtmp-bot/controller"
+	"github.com/instrumentisto/go-rtmp-bot/media"
 	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/transport"
 	"github.com/instrumentisto/go-rtmp-bot/utils"
+	"sync"
 	"time"
 )
 
@@ -14,135 +16,335 @@ import (
 // Plays RTMP stream from media server.
 type Player struct {
 	status             uint                     // RTMP connection status.
-	createStreamChan   chan rtmp.OutboundStream // The channel for created RTMP stream instance.
+	createStreamChan   chan transport.Stream    // The channel for created RTMP stream instance.
 	id                 string                   // RTMP client identifier.
 	serverURL          string                   // Media server URL.
+	dialer             *controller.Dialer       // RTMP connections dialer.
 	streamID           string                   // Stream key.
 	stop_chanel        chan bool                // The channel for stop player instance.
-	test_handler       *controller.AppHandler   // Application signal handler reference.
-	obConn             rtmp.OutboundConn        // RTMP connection reference.
+	test_handler       controller.SignalHandler // Client events handler.
+	obConn             transport.Conn           // RTMP connection reference.
 	stat               *model.StatItem          // Statistic item instance.
-	start_command_time int64                    // Start command UNIX time.
-	startedAt          int64                    // Player started UNIX time.
+	timeline           *model.ConnTimeline      // Connection lifecycle timeline.
+	startedAt          time.Time                // Player started time.
 	old_frame_count    int64                    // Count of receiving video frames.
+	old_bytes_count    int64                    // Count of receiving bytes.
+	latency            *model.Histogram         // End-to-end latency in milliseconds.
+	mutex              sync.Mutex               // Guards status and statistic.
+	stop_once          sync.Once                // Closes stop channel once.
+	reconnect          *model.ReconnectPolicy   // Reconnect policy.
+	lost_chan          chan struct{}            // The channel for connection loss.
+	generation         int                      // Number of the current connection.
+	playing            bool                     // The current connection receives video.
+	lost_at            time.Time                // Connection loss time, zero while playing.
+	downtime           time.Duration            // Total time without connection after its loss.
+	lifecycle          *model.ClientLifecycle   // Client lifecycle state machine.
+	stalls             *model.StallDetector     // Playback stalls detector.
+	integrity          *model.IntegrityChecker  // Received frames integrity checker.
+	media              *model.MediaTracker      // Received media tracker.
+	nalu_length_size   int                      // Size of NAL unit length field of received video.
+	observer           model.SampleObserver     // Observer of measured samples, may be nil.
 }
 
 // Constructs new RTMP player instance.
 //
 // params: Media-server URL                       string
 //         Stream key                             string
-//         Application signal handler reference   *controller.AppHandler
+//         Client events handler                  controller.SignalHandler
+//         RTMP connections dialer                *controller.Dialer
+//         Reconnect policy                       *model.ReconnectPolicy
+//         Video gap or lag considered as stall   time.Duration
+//         Observer of measured samples           model.SampleObserver
 //
 // returns: new instance of Player
 func NewPlayer(
-	url string, stream_key string, test_handler *controller.AppHandler) *Player {
+	url string, stream_key string, test_handler controller.SignalHandler,
+	dialer *controller.Dialer, reconnect *model.ReconnectPolicy,
+	stall_threshold time.Duration, observer model.SampleObserver) *Player {
 	client_id := utils.GetUUID()
 	return &Player{
-		status:          uint(0),
-		serverURL:       url,
-		streamID:        stream_key,
-		stop_chanel:     make(chan bool),
-		test_handler:    test_handler,
-		id:              client_id,
-		startedAt:       0,
-		stat:            model.NewStatItem(model.ROLE_PLAYER, stream_key, client_id),
-		old_frame_count: 0,
+		status:           uint(0),
+		serverURL:        url,
+		dialer:           dialer,
+		streamID:         stream_key,
+		stop_chanel:      make(chan bool),
+		test_handler:     test_handler,
+		id:               client_id,
+		timeline:         model.NewConnTimeline(),
+		stat:             model.NewStatItem(model.ROLE_PLAYER, stream_key, client_id),
+		old_frame_count:  0,
+		latency:          model.NewHistogram(),
+		createStreamChan: make(chan transport.Stream, 1),
+		reconnect:        reconnect,
+		lost_chan:        make(chan struct{}, 1),
+		lifecycle:        model.NewClientLifecycle(),
+		stalls:           model.NewStallDetector(stall_threshold),
+		integrity:        model.NewIntegrityChecker(),
+		media:            model.NewMediaTracker(),
+		nalu_length_size: media.NALU_LENGTH_SIZE,
+		observer:         observer,
 	}
 }
 
 // Runs RTMP player.
+// Reconnects after connection failure if reconnect policy allows it.
 func (p *Player) Run() {
 	defer p.onRecover()
-	p.createStreamChan = make(chan rtmp.OutboundStream)
-	p.start_command_time = time.Now().Unix()
+	p.timeline.Start()
+	attempt := 0
+	for {
+		played, stopped := p.runConnection()
+		if stopped {
+			return
+		}
+		p.connectionDown(played)
+		if played {
+			attempt = 0
+		}
+		attempt++
+		if !p.reconnect.CanRetry(attempt) {
+			return
+		}
+		backoff := p.reconnect.Backoff(attempt)
+		log.Printf("Player %s reconnects in %s, attempt %d", p.id, backoff, attempt)
+		select {
+		case <-time.After(backoff):
+		case <-p.stop_chanel:
+			return
+		}
+		p.mutex.Lock()
+		p.stat.Reconnects++
+		p.mutex.Unlock()
+	}
+}
+
+// Runs single RTMP connection until it fails or the player is stopped.
+// Returns true as played if the connection received video
+// and true as stopped if the player is stopped.
+func (p *Player) runConnection() (played bool, stopped bool) {
+	generation := p.newConnection()
 	testHandler := &controller.RTMPHandler{
-		Handler: p.test_handler,
-		ID:      p.id,
+		Handler:  p.test_handler,
+		ID:       p.id,
+		Timeline: p.timeline,
+		Receiver: p,
+		Lost: func() {
+			p.connectionLost(generation)
+		},
 	}
 	var err error
-	p.obConn, err = rtmp.Dial(p.serverURL, testHandler, 100)
+	p.obConn, err = p.dialer.Dial(p.serverURL, testHandler)
 
 	if err != nil {
-		p.stat.Status = model.STATUS_DESCRIPTIONS[6]
+		p.setErrorStatus(model.CAUSE_DIAL_ERROR)
 		log.Printf("Player DIAL error: %s", err.Error())
-		return
+		return false, false
 	}
+	defer p.closeConn()
 
 	err = p.obConn.Connect()
 
 	if err != nil {
 		log.Printf("Player CONNECTION error: %s", err.Error())
-		p.stat.Status = model.STATUS_DESCRIPTIONS[6]
-		return
+		p.setErrorStatus(model.CAUSE_CONNECT_ERROR)
+		return false, false
 	}
 	for {
 		select {
-		case stream, ok := <-p.createStreamChan:
-			if !ok {
-				return
-			}
+		case stream := <-p.createStreamChan:
 			// Play
-			err = stream.Play(p.streamID, nil, nil, nil)
+			err = stream.Play(p.streamID)
 			if err != nil {
-				p.stat.Status = model.STATUS_DESCRIPTIONS[6]
+				p.setErrorStatus(model.CAUSE_STREAM_ERROR)
 				log.Printf("Player PLAY error: %s", err.Error())
-				return
+				return false, false
 			}
+		case <-p.lost_chan:
+			log.Printf("Player %s connection lost", p.id)
+			p.mutex.Lock()
+			played = p.playing
+			p.mutex.Unlock()
+			return played, false
 		case <-p.stop_chanel:
-			return
+			p.lifecycle.Transit(model.STATE_CLOSED, model.CAUSE_STOPPED)
+			return false, true
 		}
 	}
 }
 
+// Starts new connection generation.
+// Events of previous connections are dropped.
+//
+// return number of the new connection.
+func (p *Player) newConnection() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.generation++
+	p.playing = false
+	if p.generation == 1 {
+		p.lifecycle.Transit(model.STATE_CONNECTING, model.REASON_START)
+	} else {
+		p.lifecycle.Transit(model.STATE_CONNECTING, model.REASON_RECONNECT)
+	}
+	select {
+	case <-p.lost_chan:
+	default:
+	}
+	select {
+	case <-p.createStreamChan:
+	default:
+	}
+	return p.generation
+}
+
+// Notifies Run of connection loss.
+// Losses of previous connections are ignored.
+//
+// param: generation int   Number of lost connection.
+func (p *Player) connectionLost(generation int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if generation != p.generation {
+		return
+	}
+	select {
+	case p.lost_chan <- struct{}{}:
+	default:
+	}
+}
+
+// Marks lost connection closed and starts downtime
+// if the connection received video.
+//
+// param: played bool   The connection received video.
+func (p *Player) connectionDown(played bool) {
+	p.SetStatus(transport.STATUS_CLOSE)
+	p.lifecycle.Transit(model.STATE_CLOSED, model.CAUSE_CONNECTION_LOST)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stalls.Interrupt(time.Now())
+	p.integrity.Interrupt()
+	p.media.Interrupt()
+	if played && p.lost_at.IsZero() {
+		p.lost_at = time.Now()
+	}
+}
+
+// Closes the current RTMP connection.
+func (p *Player) closeConn() {
+	if p.obConn != nil {
+		p.obConn.Close()
+		p.obConn = nil
+	}
+}
+
 // Process of RTMP message.
 //
-// param: message   rtmp.Message.
-func (p *Player) PlayStream(message *rtmp.Message) {
+// param: message   *transport.Message.
+func (p *Player) PlayStream(message *transport.Message) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.media.OnTag(media.ParseTag(message.Type, message.Data),
+		message.Timestamp) {
+		p.timeline.Mark(model.PHASE_FIRST_KEY)
+	}
 	switch message.Type {
-	case rtmp.VIDEO_TYPE:
-		if p.stat.VideoBytes == 0 {
-			p.stat.VideoStartUpTime = time.Now().Unix() - p.start_command_time
+	case transport.VIDEO_MESSAGE:
+		if !p.playing {
+			p.lifecycle.Transit(model.STATE_PLAYING, model.REASON_FIRST_VIDEO)
 		}
-		if p.startedAt == 0 && p.stat.VideoBytes > 0 {
-			p.startedAt = time.Now().Unix()
+		p.playing = true
+		if !p.lost_at.IsZero() {
+			p.downtime += time.Since(p.lost_at)
+			p.lost_at = time.Time{}
 		}
-		p.stat.VideoBytes += int64(message.Buf.Len())
+		if p.stat.VideoBytes == 0 {
+			p.timeline.Mark(model.PHASE_FIRST_VIDEO)
+			p.stat.VideoStartUpTime = int64(
+				p.timeline.Elapsed(model.PHASE_FIRST_VIDEO) / time.Millisecond)
+			p.startedAt = time.Now()
+		}
+		p.stat.VideoBytes += int64(len(message.Data))
 		p.stat.TotalFrames++
-	case rtmp.AUDIO_TYPE:
+		p.stalls.OnVideo(time.Now(), message.Timestamp)
+		if media.IsAVCSequenceHeader(message.Data) {
+			p.nalu_length_size = media.NaluLengthSize(message.Data)
+		}
+		if payload, ok := media.FindBotSEI(
+			message.Data, p.nalu_length_size); ok {
+			if marker, err := media.DecodeMarker(payload); err == nil {
+				latency := marker.Latency(time.Now())
+				p.latency.Add(latency)
+				if p.observer != nil {
+					p.observer.ObserveLatency(
+						model.ROLE_PLAYER, p.streamID, latency)
+				}
+				if marker.Integrity {
+					p.integrity.OnFrame(marker.Seq, marker.Timestamp,
+						message.Timestamp, marker.Checksum ==
+							media.FrameChecksum(media.RemoveBotSEI(
+								message.Data, p.nalu_length_size)))
+				}
+			}
+		}
+	case transport.AUDIO_MESSAGE:
 		if p.stat.AudioBytes == 0 {
-			p.stat.AudioStartUpTime = time.Now().Unix() - p.start_command_time
+			p.timeline.Mark(model.PHASE_FIRST_AUDIO)
+			p.stat.AudioStartUpTime = int64(
+				p.timeline.Elapsed(model.PHASE_FIRST_AUDIO) / time.Millisecond)
 		}
-		p.stat.AudioBytes += int64(message.Buf.Len())
+		p.stat.AudioBytes += int64(len(message.Data))
 	}
 }
 
 // This method implements IRTMPClient interface only.
 //
-// param: rtmp stream   rtmp.OutboundStream
-func (p *Player) PublishStream(stream rtmp.OutboundStream) {
-	// Does nothing!
-}
-func (p *Player) AddFrame(frame *model.FlvFrame) {
+// param: stream   transport.Stream
+func (p *Player) PublishStream(stream transport.Stream) {
 	// Does nothing!
 }
 
 // Stops player.
+// Closes stop channel, so stopping of finished player does not block.
 func (p *Player) Stop() {
-	p.stop_chanel <- true
-
+	p.stop_once.Do(func() {
+		close(p.stop_chanel)
+	})
 }
 
 // Sets RTMP connection status.
+// Successful connect command moves lifecycle to connected state.
 //
 // param: status uint.
 func (p *Player) SetStatus(status uint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	p.status = status
 	p.stat.Status = model.STATUS_DESCRIPTIONS[p.status]
+	if status == transport.STATUS_CONNECT_OK {
+		p.lifecycle.Transit(model.STATE_CONNECTED, model.REASON_CONNECT_OK)
+	}
+}
+
+// Sets connection error status description and fails lifecycle.
+//
+// param: cause string   Failure cause.
+func (p *Player) setErrorStatus(cause string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stat.Status = model.STATUS_DESCRIPTIONS[6]
+	p.lifecycle.Transit(model.STATE_FAILED, cause)
 }
 
 // Sets created RTMP stream reference.
-func (p *Player) SetStream(stream rtmp.OutboundStream) {
-	p.createStreamChan <- stream
+// Does not block events dispatching: the stream is dropped
+// if another created stream is still not taken by Run.
+func (p *Player) SetStream(stream transport.Stream) {
+	select {
+	case p.createStreamChan <- stream:
+	default:
+		log.Printf("Player %s dropped created stream", p.id)
+	}
 }
 
 // Returns RTMP stream key.
@@ -159,24 +361,55 @@ func (p *Player) GetID() string {
 	return p.id
 }
 
-// Returns statistic item instance.
+// Returns snapshot of statistic item.
 //
 // return StatItem.
 func (p *Player) GetStat() *model.StatItem {
-	return p.stat
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.stat.Snapshot()
 }
 
 // Updates client statistic.
+// Playing without video longer than stall threshold is stalled.
 func (p *Player) UpdateStat() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 
 	if p.stat.VideoBytes > 0 {
-		p.stat.TotalTime = time.Now().Unix() - p.startedAt
+		p.stat.TotalTime = int64(time.Since(p.startedAt) / time.Second)
 	}
 
-	if p.stat.TotalFrames != p.old_frame_count {
-		p.stat.FPS = p.stat.TotalFrames - p.old_frame_count
-		p.old_frame_count = p.stat.TotalFrames
+	p.stat.FPS = p.stat.TotalFrames - p.old_frame_count
+	p.old_frame_count = p.stat.TotalFrames
+	bytes_count := p.stat.AudioBytes + p.stat.VideoBytes
+	p.stat.Bitrate = (bytes_count - p.old_bytes_count) * 8
+	p.old_bytes_count = bytes_count
+	p.stat.Timings = p.timeline.Durations()
+	p.stat.Latency = p.latency.Summary()
+	p.stat.LatencyHistogram = p.latency.Copy()
+	downtime := p.downtime
+	if !p.lost_at.IsZero() {
+		downtime += time.Since(p.lost_at)
+	}
+	p.stat.Downtime = int64(downtime / time.Millisecond)
+	now := time.Now()
+	stalled := p.stalls.Check(now)
+	switch state := p.lifecycle.State(); {
+	case state == model.STATE_PLAYING && stalled:
+		p.lifecycle.Transit(model.STATE_STALLED, model.REASON_NO_MEDIA)
+	case state == model.STATE_STALLED && !stalled:
+		p.lifecycle.Transit(model.STATE_PLAYING, model.REASON_MEDIA_RESUMED)
 	}
+	p.stat.Stalls = p.stalls.Stalls()
+	p.stat.StallTime = int64(p.stalls.StallTime(now) / time.Millisecond)
+	p.stat.PlayTime = int64(p.stalls.PlayTime(now) / time.Millisecond)
+	p.stat.RebufferRatio = p.stalls.RebufferRatio(now)
+	p.stat.Integrity = p.integrity.Stats()
+	p.media.Update(p.stat)
+	p.stat.State = p.lifecycle.State()
+	p.stat.Transitions = p.lifecycle.Transitions()
+	p.stat.Disconnects = p.lifecycle.Disconnects()
 }
 
 // Check any panic.
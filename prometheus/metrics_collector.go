@@ -1,112 +1,580 @@
 package prometheus
 
 import (
-	"github.com/Zumata/exporttools"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/instrumentisto/go-rtmp-bot/model"
-	"github.com/instrumentisto/go-rtmp-bot/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels of RTMP clients metrics.
+const (
+	LABEL_ROLE   = "role"   // RTMP client role.
+	LABEL_STREAM = "stream" // RTMP stream key.
+	LABEL_PHASE  = "phase"  // RTMP connection phase.
+	LABEL_STATE  = "state"  // RTMP client lifecycle state.
+	LABEL_CAUSE  = "cause"  // RTMP client disconnect cause.
+	LABEL_HEADER = "header" // Sequence header arrival by role and media kind.
 )
 
+// Gauge of stress test report value.
+type reportGauge struct {
+	name        string                    // Metric name.
+	description string                    // Metric description.
+	value       func(*model.Report) int64 // Returns report value.
+}
+
+// Gauge of stress test report ratio.
+type reportRatio struct {
+	name        string                      // Metric name.
+	description string                      // Metric description.
+	value       func(*model.Report) float64 // Returns report ratio.
+}
+
+// Counter of RTMP clients statistic values.
+type clientCounter struct {
+	name        string                      // Metric name.
+	description string                      // Metric description.
+	value       func(*model.StatItem) int64 // Returns client value.
+}
+
+// Histogram of RTMP clients statistic values.
+type clientHistogram struct {
+	name        string                          // Metric name.
+	description string                          // Metric description.
+	buckets     []float64                       // Histogram buckets.
+	values      func(*model.StatItem) []float64 // Returns client samples.
+}
+
+// Gauges of stress test report.
+var report_gauges = []reportGauge{
+	{"model_connected", "Count of connected models",
+		func(r *model.Report) int64 { return r.ConnectedModelsCount }},
+	{"clients_connected", "Count of connected clients",
+		func(r *model.Report) int64 { return r.ConnectedClientsCount }},
+	{"failure_models", "Count of failures publisher connections",
+		func(r *model.Report) int64 { return r.ConnectedModelCountLag }},
+	{"client_failures", "Count of failures clients connections",
+		func(r *model.Report) int64 { return r.ConnectedClientCountLag }},
+	{"total_time", "Stress test total time",
+		func(r *model.Report) int64 { return r.TotalTime }},
+	{"total_clients", "Total clients count",
+		func(r *model.Report) int64 { return int64(r.TotalClients) }},
+	{"total_model_fps", "Average model fps",
+		func(r *model.Report) int64 { return r.AverageModelFPS }},
+	{"total_client_fps", "Average client fps",
+		func(r *model.Report) int64 { return r.AverageClientFPS }},
+	{"audio_bytes_sends", "Average audio bytes sends",
+		func(r *model.Report) int64 { return r.AverageAudioBytesSends }},
+	{"video_bytes_sends", "Average video bytes sends",
+		func(r *model.Report) int64 { return r.AverageVideoBytesSends }},
+	{"audio_bytes_received", "Average audio bytes received",
+		func(r *model.Report) int64 { return r.AverageAudioBytesReceived }},
+	{"video_bytes_received", "Average video bytes received",
+		func(r *model.Report) int64 { return r.AverageVideoBytesReceived }},
+	{"average_video_time_published", "Average video time published",
+		func(r *model.Report) int64 { return r.TotalVideoPublished }},
+	{"average_video_time_received", "Average video time received",
+		func(r *model.Report) int64 { return r.TotalVideoPlayed }},
+	{"latency_p50_ms", "50th percentile of end-to-end latency in milliseconds",
+		func(r *model.Report) int64 { return r.LatencyP50 }},
+	{"latency_p95_ms", "95th percentile of end-to-end latency in milliseconds",
+		func(r *model.Report) int64 { return r.LatencyP95 }},
+	{"latency_p99_ms", "99th percentile of end-to-end latency in milliseconds",
+		func(r *model.Report) int64 { return r.LatencyP99 }},
+	{"dropped_frames", "Count of frames dropped by publishers frame queues",
+		func(r *model.Report) int64 { return r.DroppedFrames }},
+	{"reconnects", "Reconnect attempts of clients",
+		func(r *model.Report) int64 { return r.Reconnects }},
+	{"downtime_ms", "Total downtime of clients in milliseconds",
+		func(r *model.Report) int64 { return r.TotalDowntime }},
+	{"stalls", "Playback stalls of players",
+		func(r *model.Report) int64 { return r.Stalls }},
+	{"stall_ms", "Total playback stalls duration of players in milliseconds",
+		func(r *model.Report) int64 { return r.TotalStallTime }},
+	{"keyframe_interval_ms", "Average keyframe interval of players in milliseconds",
+		func(r *model.Report) int64 { return r.AverageKeyframeInterval }},
+	{"keyframe_interval_max_ms", "Maximal keyframe interval of players in milliseconds",
+		func(r *model.Report) int64 { return r.MaxKeyframeInterval }},
+	{"gop_size_frames", "Average GOP size of players in frames",
+		func(r *model.Report) int64 { return r.AverageGopSize }},
+	{"average_model_bitrate_bps", "Average publisher bitrate in bits per second",
+		func(r *model.Report) int64 { return r.AverageModelBitrate }},
+	{"average_client_bitrate_bps", "Average player bitrate in bits per second",
+		func(r *model.Report) int64 { return r.AverageClientBitrate }},
+	{"send_jitter_ms", "Average send jitter of publishers in milliseconds",
+		func(r *model.Report) int64 { return r.AverageSendJitter }},
+	{"send_jitter_p95_ms", "Maximal 95th percentile of publishers send jitter in milliseconds",
+		func(r *model.Report) int64 { return r.SendJitterP95 }},
+	{"sweep_step", "Current sweep step, 0 without sweep",
+		func(r *model.Report) int64 { return r.SweepStep }},
+	{"sweep_value", "Swept parameter value of current sweep step",
+		func(r *model.Report) int64 { return r.SweepValue }},
+	{"live_agents", "Live agents of distributed test",
+		func(r *model.Report) int64 { return r.LiveAgents }},
+	{"dead_agents", "Dead agents of distributed test",
+		func(r *model.Report) int64 { return r.DeadAgents }},
+}
+
+// Ratio gauges of stress test report.
+var report_ratios = []reportRatio{
+	{"rebuffer_ratio", "Part of players playback time spent in stalls",
+		func(r *model.Report) float64 { return r.RebufferRatio }},
+	{"frame_loss_ratio", "Part of frames lost on the way to players",
+		func(r *model.Report) float64 { return r.Integrity.LossRate }},
+	{"frame_reorder_ratio", "Part of frames reordered on the way to players",
+		func(r *model.Report) float64 { return r.Integrity.ReorderRate }},
+}
+
+// Counters of RTMP clients.
+var client_counters = []clientCounter{
+	{"audio_bytes_total", "Audio bytes sent or received by clients",
+		func(s *model.StatItem) int64 { return s.AudioBytes }},
+	{"video_bytes_total", "Video bytes sent or received by clients",
+		func(s *model.StatItem) int64 { return s.VideoBytes }},
+	{"video_frames_total", "Video frames sent or received by clients",
+		func(s *model.StatItem) int64 { return s.TotalFrames }},
+	{"dropped_frames_total", "Frames dropped by publishers frame queues",
+		func(s *model.StatItem) int64 { return s.DroppedFrames }},
+	{"reconnects_total", "Reconnect attempts of clients",
+		func(s *model.StatItem) int64 { return s.Reconnects }},
+	{"downtime_ms_total", "Downtime of clients in milliseconds",
+		func(s *model.StatItem) int64 { return s.Downtime }},
+	{"stalls_total", "Playback stalls of players",
+		func(s *model.StatItem) int64 { return s.Stalls }},
+	{"stall_ms_total", "Playback stalls duration of players in milliseconds",
+		func(s *model.StatItem) int64 { return s.StallTime }},
+	{"frames_verified_total", "Frames verified by players",
+		func(s *model.StatItem) int64 { return s.Integrity.Frames }},
+	{"frames_lost_total", "Frames lost on the way to players",
+		func(s *model.StatItem) int64 { return s.Integrity.Lost }},
+	{"frames_duplicated_total", "Frames duplicated on the way to players",
+		func(s *model.StatItem) int64 { return s.Integrity.Duplicated }},
+	{"frames_reordered_total", "Frames reordered on the way to players",
+		func(s *model.StatItem) int64 { return s.Integrity.Reordered }},
+	{"frames_corrupted_total", "Frames with wrong checksum received by players",
+		func(s *model.StatItem) int64 { return s.Integrity.Corrupted }},
+	{"timestamp_jumps_total", "Timestamp discontinuities received by players",
+		func(s *model.StatItem) int64 { return s.Integrity.TimestampJumps }},
+}
+
+// Histograms of RTMP clients.
+var client_histograms = []clientHistogram{
+	{"client_fps", "Frames per second of connected clients",
+		[]float64{1, 5, 10, 15, 20, 25, 30, 40, 50, 60},
+		func(s *model.StatItem) []float64 {
+			if !isConnected(s) {
+				return nil
+			}
+			return []float64{float64(s.FPS)}
+		}},
+	{"client_bitrate_bps", "Bitrate of connected clients in bits per second",
+		prometheus.ExponentialBuckets(64000, 2, 10),
+		func(s *model.StatItem) []float64 {
+			if !isConnected(s) {
+				return nil
+			}
+			return []float64{float64(s.Bitrate)}
+		}},
+	{"client_startup_seconds", "Video startup time of clients in seconds",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+		func(s *model.StatItem) []float64 {
+			if s.VideoBytes == 0 {
+				return nil
+			}
+			return []float64{
+				float64(s.VideoStartUpTime) / float64(time.Second/time.Millisecond)}
+		}},
+	{"player_rebuffer_ratio", "Part of players playback time spent in stalls",
+		[]float64{0.001, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1},
+		func(s *model.StatItem) []float64 {
+			if s.Role != model.ROLE_PLAYER || s.PlayTime == 0 {
+				return nil
+			}
+			return []float64{s.RebufferRatio}
+		}},
+	{"client_keyframe_interval_seconds", "Mean keyframe interval of clients in seconds",
+		[]float64{0.5, 1, 2, 3, 4, 5, 6, 8, 10, 15},
+		func(s *model.StatItem) []float64 {
+			if s.KeyframeInterval.Count == 0 {
+				return nil
+			}
+			return []float64{float64(s.KeyframeInterval.Mean) /
+				float64(time.Second/time.Millisecond)}
+		}},
+	{"client_gop_size_frames", "Mean GOP size of clients in frames",
+		[]float64{10, 25, 30, 50, 60, 90, 120, 150, 250, 300},
+		func(s *model.StatItem) []float64 {
+			if s.GopSize.Count == 0 {
+				return nil
+			}
+			return []float64{float64(s.GopSize.Mean)}
+		}},
+	{"publisher_send_jitter_seconds", "Mean send jitter of publishers in seconds",
+		[]float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1},
+		func(s *model.StatItem) []float64 {
+			if s.SendJitter.Count == 0 {
+				return nil
+			}
+			return []float64{float64(s.SendJitter.Mean) /
+				float64(time.Second/time.Millisecond)}
+		}},
+}
+
+// Buckets of end-to-end latency histogram in seconds.
+var latency_buckets = []float64{0.1, 0.25, 0.5, 1, 2, 3, 5, 10, 30}
+
+// Buckets of connection phases histogram in seconds.
+var phase_buckets = []float64{
+	0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
 // Collector of test metrics.
 type metricsCollector struct {
-	report *model.Report // Stress test report instance.
+	report          *model.Report              // Stress test report instance.
+	labels          MetricLabels               // Requested clients metrics labels.
+	label_names     []string                   // Clients metrics label names.
+	gauge_descs     []*prometheus.Desc         // Report gauges descriptions.
+	ratio_descs     []*prometheus.Desc         // Report ratio gauges descriptions.
+	counter_descs   []*prometheus.Desc         // Clients counters descriptions.
+	histogram_descs []*prometheus.Desc         // Clients histograms descriptions.
+	phase_desc      *prometheus.Desc           // Connection phases histogram description.
+	state_desc      *prometheus.Desc           // Clients states gauge description.
+	disconnect_desc *prometheus.Desc           // Disconnects counter description.
+	header_desc     *prometheus.Desc           // Sequence headers gauge description.
+	latency         *prometheus.HistogramVec   // Latency histogram observed by players.
+	mutex           sync.Mutex                 // Guards clients and removed counters.
+	clients         map[string]*model.StatItem // Clients statistic of the previous scrape.
+	removed         map[string]*groupCounters  // Counters of removed clients by group key.
+}
+
+// Cumulative counters of group of RTMP clients.
+type groupCounters struct {
+	label_values []string         // Label values of group.
+	counters     []int64          // Values of clients counters.
+	disconnects  map[string]int64 // Disconnects by cause.
 }
 
 // Returns new instance of Metrics collector
 //
-// param: report *model.Report   Instance of the test report.
-func newMetricsCollector(report *model.Report) *metricsCollector {
-	return &metricsCollector{
-		report: report,
-	}
-}
-
-// Returns array of prometheus metrics or error instance.
-func (c *metricsCollector) Collect() ([]*exporttools.Metric, error) {
-	metrics := []*exporttools.Metric{
-		&exporttools.Metric{
-			Name:        "model_connected",
-			Type:        exporttools.Gauge,
-			Value:       c.report.ConnectedModelsCount,
-			Description: "Count of connected models",
-		},
-		&exporttools.Metric{
-			Name:        "clients_connected",
-			Type:        exporttools.Gauge,
-			Value:       c.report.ConnectedClientsCount,
-			Description: "Count of connected clients",
-		},
-		&exporttools.Metric{
-			Name:        "failure_models",
-			Type:        exporttools.Gauge,
-			Value:       c.report.ConnectedModelCountLag,
-			Description: "Count of failures publisher connections",
-		},
-		&exporttools.Metric{
-			Name:        "client_failures",
-			Type:        exporttools.Gauge,
-			Value:       c.report.ConnectedClientCountLag,
-			Description: "Count of failures clients connections",
-		},
-		&exporttools.Metric{
-			Name:        "total_time",
-			Type:        exporttools.Gauge,
-			Value:       c.report.TotalTime,
-			Description: "Stress test total time",
-		},
-		&exporttools.Metric{
-			Name:        "total_clients",
-			Type:        exporttools.Gauge,
-			Value:       utils.Num64(c.report.TotalClients),
-			Description: "Total clients count",
-		},
-		&exporttools.Metric{
-			Name:        "total_model_fps",
-			Type:        exporttools.Gauge,
-			Value:       c.report.AverageModelFPS,
-			Description: "Average model fps",
-		},
-		&exporttools.Metric{
-			Name:        "total_client_fps",
-			Type:        exporttools.Gauge,
-			Value:       c.report.AverageClientFPS,
-			Description: "Average client fps",
-		},
-		&exporttools.Metric{
-			Name:        "audio_bytes_sends",
-			Type:        exporttools.Gauge,
-			Value:       c.report.AverageAudioBytesSends,
-			Description: "Average audio bytes sends",
-		},
-		&exporttools.Metric{
-			Name:        "video_bytes_sends",
-			Type:        exporttools.Gauge,
-			Value:       c.report.AverageVideoBytesSends,
-			Description: "Average video bytes sends",
-		},
-		&exporttools.Metric{
-			Name:        "audio_bytes_received",
-			Type:        exporttools.Gauge,
-			Value:       c.report.AverageAudioBytesReceived,
-			Description: "Average audio bytes received",
-		},
-		&exporttools.Metric{
-			Name:        "video_bytes_received",
-			Type:        exporttools.Gauge,
-			Value:       c.report.AverageVideoBytesReceived,
-			Description: "Average video bytes received",
-		},
-		&exporttools.Metric{
-			Name:        "average_video_time_published",
-			Type:        exporttools.Gauge,
-			Value:       c.report.TotalVideoPublished,
-			Description: "Average video time published",
-		},
-		&exporttools.Metric{
-			Name:        "average_video_time_received",
-			Type:        exporttools.Gauge,
-			Value:       c.report.TotalVideoPlayed,
-			Description: "Average video time received",
-		},
-	}
-	return metrics, nil
+// params: report *model.Report   Instance of the test report.
+//         labels MetricLabels    Requested clients metrics labels.
+func newMetricsCollector(
+	report *model.Report, labels MetricLabels) *metricsCollector {
+	c := &metricsCollector{
+		report:  report,
+		labels:  labels,
+		clients: make(map[string]*model.StatItem),
+		removed: make(map[string]*groupCounters),
+	}
+	if labels.Role {
+		c.label_names = append(c.label_names, LABEL_ROLE)
+	}
+	if labels.Stream {
+		c.label_names = append(c.label_names, LABEL_STREAM)
+	}
+	prefix := report.MetricPrefix
+	for _, gauge := range report_gauges {
+		c.gauge_descs = append(c.gauge_descs, prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "", gauge.name),
+			gauge.description, nil, nil))
+	}
+	for _, ratio := range report_ratios {
+		c.ratio_descs = append(c.ratio_descs, prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "", ratio.name),
+			ratio.description, nil, nil))
+	}
+	for _, counter := range client_counters {
+		c.counter_descs = append(c.counter_descs, prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "", counter.name),
+			counter.description, c.label_names, nil))
+	}
+	for _, histogram := range client_histograms {
+		c.histogram_descs = append(c.histogram_descs, prometheus.NewDesc(
+			prometheus.BuildFQName(prefix, "", histogram.name),
+			histogram.description, c.label_names, nil))
+	}
+	c.phase_desc = prometheus.NewDesc(
+		prometheus.BuildFQName(prefix, "", "connection_phase_seconds"),
+		"Duration of RTMP connection phases in seconds",
+		append([]string{LABEL_PHASE}, c.label_names...), nil)
+	c.state_desc = prometheus.NewDesc(
+		prometheus.BuildFQName(prefix, "", "clients_state"),
+		"Count of clients by lifecycle state",
+		[]string{LABEL_STATE}, nil)
+	c.disconnect_desc = prometheus.NewDesc(
+		prometheus.BuildFQName(prefix, "", "disconnects_total"),
+		"Disconnects of clients by cause",
+		append([]string{LABEL_CAUSE}, c.label_names...), nil)
+	c.header_desc = prometheus.NewDesc(
+		prometheus.BuildFQName(prefix, "", "sequence_headers"),
+		"Count of clients by sequence headers arrival",
+		[]string{LABEL_HEADER}, nil)
+	c.latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: prefix,
+		Name:      "client_latency_seconds",
+		Help:      "End-to-end latency of players in seconds",
+		Buckets:   latency_buckets,
+	}, c.label_names)
+	return c
+}
+
+// Observes end-to-end latency of player when it is measured.
+//
+// params: role      string   Role of RTMP client.
+//         stream_id string   Stream key.
+//         latency   int64    End-to-end latency in milliseconds.
+func (c *metricsCollector) ObserveLatency(
+	role string, stream_id string, latency int64) {
+	c.latency.WithLabelValues(c.labelValues(role, stream_id)...).Observe(
+		float64(latency) / float64(time.Second/time.Millisecond))
+}
+
+// Sends descriptions of all metrics.
+//
+// param: ch chan<- *prometheus.Desc   Descriptions channel.
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.gauge_descs {
+		ch <- desc
+	}
+	for _, desc := range c.ratio_descs {
+		ch <- desc
+	}
+	for _, desc := range c.counter_descs {
+		ch <- desc
+	}
+	for _, desc := range c.histogram_descs {
+		ch <- desc
+	}
+	ch <- c.phase_desc
+	ch <- c.state_desc
+	ch <- c.disconnect_desc
+	ch <- c.header_desc
+	c.latency.Describe(ch)
+}
+
+// Sends report gauges and clients counters and histograms.
+//
+// param: ch chan<- prometheus.Metric   Metrics channel.
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	report := c.report.Snapshot()
+	for i, gauge := range report_gauges {
+		ch <- prometheus.MustNewConstMetric(c.gauge_descs[i],
+			prometheus.GaugeValue, float64(gauge.value(report)))
+	}
+	for i, ratio := range report_ratios {
+		ch <- prometheus.MustNewConstMetric(c.ratio_descs[i],
+			prometheus.GaugeValue, ratio.value(report))
+	}
+	for state, count := range report.ClientStates {
+		ch <- prometheus.MustNewConstMetric(c.state_desc,
+			prometheus.GaugeValue, float64(count), state)
+	}
+	for header, count := range report.SequenceHeaders {
+		ch <- prometheus.MustNewConstMetric(c.header_desc,
+			prometheus.GaugeValue, float64(count), header)
+	}
+	groups := c.groupClients(report.Clients)
+	totals := c.counterTotals(report.Clients)
+	for i := range client_counters {
+		for _, total := range totals {
+			ch <- prometheus.MustNewConstMetric(c.counter_descs[i],
+				prometheus.CounterValue, float64(total.counters[i]),
+				total.label_values...)
+		}
+	}
+	for i, histogram := range client_histograms {
+		for _, group := range groups {
+			var values []float64
+			for _, client := range group.clients {
+				values = append(values, histogram.values(client)...)
+			}
+			ch <- newConstHistogram(c.histogram_descs[i],
+				histogram.buckets, values, group.label_values...)
+		}
+	}
+	for _, group := range groups {
+		phases := make(map[string][]float64)
+		for _, client := range group.clients {
+			for phase, duration := range client.Timings {
+				phases[phase] = append(phases[phase],
+					time.Duration(duration).Seconds())
+			}
+		}
+		for phase, values := range phases {
+			ch <- newConstHistogram(c.phase_desc, phase_buckets, values,
+				append([]string{phase}, group.label_values...)...)
+		}
+	}
+	for _, total := range totals {
+		for cause, count := range total.disconnects {
+			ch <- prometheus.MustNewConstMetric(c.disconnect_desc,
+				prometheus.CounterValue, float64(count),
+				append([]string{cause}, total.label_values...)...)
+		}
+	}
+	c.latency.Collect(ch)
+}
+
+// Returns cumulative counters of clients groups by group key.
+// Counters of clients removed since the previous scrape are kept,
+// so exported counters never decrease.
+//
+// param: clients map[string]*model.StatItem   RTMP clients statistic.
+func (c *metricsCollector) counterTotals(
+	clients map[string]*model.StatItem) map[string]*groupCounters {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for id, client := range c.clients {
+		if _, ok := clients[id]; ok {
+			continue
+		}
+		label_values := c.labelValues(client.Role, client.StreamID)
+		key := strings.Join(label_values, "\x00")
+		removed, ok := c.removed[key]
+		if !ok {
+			removed = newGroupCounters(label_values)
+			c.removed[key] = removed
+		}
+		removed.add(client)
+	}
+	c.clients = make(map[string]*model.StatItem)
+	totals := make(map[string]*groupCounters)
+	if len(c.label_names) == 0 {
+		totals[""] = newGroupCounters(nil)
+	}
+	for key, removed := range c.removed {
+		total := newGroupCounters(removed.label_values)
+		total.merge(removed)
+		totals[key] = total
+	}
+	for id, client := range clients {
+		c.clients[id] = client
+		label_values := c.labelValues(client.Role, client.StreamID)
+		key := strings.Join(label_values, "\x00")
+		total, ok := totals[key]
+		if !ok {
+			total = newGroupCounters(label_values)
+			totals[key] = total
+		}
+		total.add(client)
+	}
+	return totals
+}
+
+// Returns new zero counters of clients group.
+//
+// param: label_values []string   Label values of group.
+func newGroupCounters(label_values []string) *groupCounters {
+	disconnects := make(map[string]int64)
+	for _, cause := range model.DISCONNECT_CAUSES {
+		disconnects[cause] = 0
+	}
+	return &groupCounters{
+		label_values: label_values,
+		counters:     make([]int64, len(client_counters)),
+		disconnects:  disconnects,
+	}
+}
+
+// Adds counters of RTMP client.
+//
+// param: client *model.StatItem   RTMP client statistic.
+func (g *groupCounters) add(client *model.StatItem) {
+	for i, counter := range client_counters {
+		g.counters[i] += counter.value(client)
+	}
+	for cause, count := range client.Disconnects {
+		g.disconnects[cause] += count
+	}
+}
+
+// Adds counters of other group.
+//
+// param: other *groupCounters   Added group counters.
+func (g *groupCounters) merge(other *groupCounters) {
+	for i, count := range other.counters {
+		g.counters[i] += count
+	}
+	for cause, count := range other.disconnects {
+		g.disconnects[cause] += count
+	}
+}
+
+// Group of RTMP clients with the same label values.
+type clientGroup struct {
+	label_values []string          // Label values of group.
+	clients      []*model.StatItem // Clients statistic.
+}
+
+// Groups RTMP clients by requested labels.
+//
+// param: clients map[string]*model.StatItem   RTMP clients statistic.
+func (c *metricsCollector) groupClients(
+	clients map[string]*model.StatItem) map[string]*clientGroup {
+	groups := make(map[string]*clientGroup)
+	if len(c.label_names) == 0 {
+		groups[""] = &clientGroup{}
+	}
+	for _, client := range clients {
+		label_values := c.labelValues(client.Role, client.StreamID)
+		key := strings.Join(label_values, "\x00")
+		group, ok := groups[key]
+		if !ok {
+			group = &clientGroup{label_values: label_values}
+			groups[key] = group
+		}
+		group.clients = append(group.clients, client)
+	}
+	return groups
+}
+
+// Returns requested label values of RTMP client.
+//
+// params: role      string   Role of RTMP client.
+//         stream_id string   Stream key.
+func (c *metricsCollector) labelValues(role string, stream_id string) []string {
+	var label_values []string
+	if c.labels.Role {
+		label_values = append(label_values, role)
+	}
+	if c.labels.Stream {
+		label_values = append(label_values, stream_id)
+	}
+	return label_values
+}
+
+// Returns constant histogram of values.
+//
+// params: desc         *prometheus.Desc   Histogram description.
+//         buckets      []float64          Upper bounds of buckets.
+//         values       []float64          Observed values.
+//         label_values ...string          Histogram label values.
+func newConstHistogram(desc *prometheus.Desc, buckets []float64,
+	values []float64, label_values ...string) prometheus.Metric {
+	counts := make(map[float64]uint64)
+	var sum float64
+	for _, bound := range buckets {
+		counts[bound] = 0
+	}
+	for _, value := range values {
+		sum += value
+		for _, bound := range buckets {
+			if value <= bound {
+				counts[bound]++
+			}
+		}
+	}
+	return prometheus.MustNewConstHistogram(
+		desc, uint64(len(values)), sum, counts, label_values...)
+}
+
+// Returns true if RTMP client publishes or plays stream,
+// the same as connected clients of report.
+//
+// param: item *model.StatItem   RTMP client statistic.
+func isConnected(item *model.StatItem) bool {
+	return model.IsStreaming(item.State) && item.FPS > 0
 }
@@ -1,19 +1,23 @@
 package prometheus
 
 import (
-	"github.com/Zumata/exporttools"
-	"github.com/instrumentisto/go-rtmp-bot/model"
-	"github.com/prometheus/client_golang/prometheus"
+	"fmt"
 	"log"
 	"net/http"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Prometheus metrics exporter client.
 type ReportExportClient struct {
 	listen_address string // Listen address for process metrics response from
 	// Prometheus.
-	telemetry_path string        // Path to metrics.
-	report         *model.Report // Stress test report instance.
+	telemetry_path string            // Path to metrics.
+	report         *model.Report     // Stress test report instance.
+	labels         MetricLabels      // Requested clients metrics labels.
+	collector      *metricsCollector // Stress test metrics collector.
 }
 
 // Returns new Prometheus client instance.
@@ -22,28 +26,48 @@ type ReportExportClient struct {
 //                                        response from Prometheus.
 //         metrics_path   string          Path to metrics.
 //         report         *model.Report   Stress test report instance.
+//         labels         MetricLabels    Requested clients metrics labels.
 func NewReportExportClient(
 	listen_address string,
 	metrics_path string,
-	report *model.Report) *ReportExportClient {
+	report *model.Report,
+	labels MetricLabels) *ReportExportClient {
 	return &ReportExportClient{
 		listen_address: listen_address,
 		telemetry_path: metrics_path,
 		report:         report,
+		labels:         labels,
+		collector:      newMetricsCollector(report, labels),
 	}
 }
 
+// Observes end-to-end latency of player when it is measured.
+//
+// params: role      string   Role of RTMP client.
+//         stream_id string   Stream key.
+//         latency   int64    End-to-end latency in milliseconds.
+func (c *ReportExportClient) ObserveLatency(
+	role string, stream_id string, latency int64) {
+	c.collector.ObserveLatency(role, stream_id, latency)
+}
+
 // Runs prometheus exporter client.
 func (c *ReportExportClient) Run() {
-	exporter := NewExporter(c.report)
-	err := exporttools.Export(exporter)
-	if err != nil {
-		log.Fatal(err)
-	}
-	http.Handle(c.telemetry_path, prometheus.Handler())
-	http.HandleFunc("/", exporttools.DefaultMetricsHandler(
-		"Media server stress test exporter", c.telemetry_path))
-	err = http.ListenAndServe(c.listen_address, nil)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c.collector)
+	mux := http.NewServeMux()
+	mux.Handle(c.telemetry_path,
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html>
+<head><title>Media server stress test exporter</title></head>
+<body>
+<h1>Media server stress test exporter</h1>
+<p><a href="%s">Metrics</a></p>
+</body>
+</html>`, c.telemetry_path)
+	})
+	err := http.ListenAndServe(c.listen_address, mux)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,49 +1,22 @@
 package prometheus
 
 import (
-	"github.com/Zumata/exporttools"
 	"github.com/instrumentisto/go-rtmp-bot/model"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-// Implements Prometheus Exporter interface.
-type stressTestExporter struct {
-	exporter *exporttools.BaseExporter // Base Prometheus exporter instance.
-	report   *model.Report             // Stress test report.
+// Requested labels of RTMP clients metrics.
+// Clients metrics are aggregated over all clients without labels.
+type MetricLabels struct {
+	Role   bool // Label clients metrics by client role.
+	Stream bool // Label clients metrics by stream key.
 }
 
-// Returns new prometheus stress test exporter instance.
+// Returns new prometheus stress test collector instance.
 //
-// param: report *model.Report   Stress test report instance.
-func NewExporter(report *model.Report) *stressTestExporter {
-	return &stressTestExporter{
-		exporter: exporttools.NewBaseExporter(report.MetricPrefix),
-		report:   report,
-	}
-}
-
-// Setups stress test exporter.
-func (e *stressTestExporter) Setup() error {
-	e.exporter.AddGroup(newMetricsCollector(e.report))
-	return nil
-}
-
-// Closes stress test exporter.
-func (e *stressTestExporter) Close() error {
-	return nil
-}
-
-// Describes prometheus metrics.
-func (e *stressTestExporter) Describe(ch chan<- *prometheus.Desc) {
-	exporttools.GenericDescribe(e.exporter, ch)
-}
-
-// Collects prometheus metrics.
-func (e *stressTestExporter) Collect(ch chan<- prometheus.Metric) {
-	exporttools.GenericCollect(e.exporter, ch)
-}
-
-// Process metrics.
-func (e *stressTestExporter) Process() {
-	e.exporter.Process()
+// params: report *model.Report   Stress test report instance.
+//         labels MetricLabels    Requested clients metrics labels.
+func NewExporter(
+	report *model.Report, labels MetricLabels) prometheus.Collector {
+	return newMetricsCollector(report, labels)
 }
@@ -1,27 +1,38 @@
 package publisher
 
 import (
-	"github.com/instrumentisto/go-rtmp-bot/controller"
+	"fmt"
+	"github.com/instrumentisto/go-rtmp-bot/media"
 	"github.com/instrumentisto/go-rtmp-bot/model"
 	"github.com/zhangpeihao/goflv"
 	"log"
-	"time"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 )
 
+// Gap between the last frame of file and the first frame of the next loop
+// in milliseconds.
+const LOOP_GAP = 40
+
 // Opens and read test flv file.
+// Fans out read frames to publishers frame queues.
 type FlvStream struct {
-	FlvFile  *flv.File              // flv file content.
-	fileName string                 // flv file name.
-	handler  *controller.AppHandler // Application signal handler.
+	FlvFile  *flv.File         // flv file content.
+	fileName string            // flv file name.
+	fanout   *FrameFanout      // Fan-out of frames to publishers.
+	pending  []*model.FlvFrame // Frames to play before the file tags.
+	done     chan struct{}     // Closed to stop playing.
+	once     sync.Once         // Closes done channel once.
+	pacer    *Pacer            // Scheduler of frames sending.
 }
 
 // Creates new instance of FlvStream.
 //
-// params: file_name   string                   Test FLV file name.
-//         app_handler *controller.AppHandler   Application signals handler.
-func NewFlvFile(
-	file_name string,
-	app_handler *controller.AppHandler) (*FlvStream, error) {
+// params: file_name string          Test FLV file name.
+//         pacing    *model.Pacing   Frames pacing, nil is realtime pacing.
+func NewFlvFile(file_name string, pacing *model.Pacing) (*FlvStream, error) {
 	file, err := flv.OpenFile(file_name)
 	if err != nil {
 		return nil, err
@@ -29,58 +40,177 @@ func NewFlvFile(
 	return &FlvStream{
 		FlvFile:  file,
 		fileName: file_name,
-		handler:  app_handler,
+		fanout:   NewFrameFanout(),
+		done:     make(chan struct{}),
+		pacer:    NewPacer(pacing),
 	}, nil
 }
 
-// Plays the test flv file.
+// Returns test flv files paths matched by comma separated paths or globs.
+// Paths of every pattern are sorted, duplicated paths are skipped.
+// Synthetic media sources are returned as is.
+//
+// param: patterns string   Comma separated flv files paths or globs.
+func ResolveFlvFiles(patterns string) ([]string, error) {
+	var files []string
+	found := make(map[string]bool)
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if model.IsSyntheticSource(pattern) {
+			if !found[pattern] {
+				found[pattern] = true
+				files = append(files, pattern)
+			}
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no flv files match %s", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if !found[match] {
+				found[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+	return files, nil
+}
+
+// Returns new publisher frame queue subscribed to the file frames.
+//
+// params: size   int      Queue capacity.
+//         policy string   Overflow policy.
+func (s *FlvStream) Subscribe(size int, policy string) *FrameQueue {
+	return s.fanout.Subscribe(size, policy)
+}
+
+// Skips the file up to the first video key frame after the part of file.
+// Skipped metadata and sequence headers are played before the key frame,
+// so players can decode the stream from the key frame.
+// Plays from the beginning if there is no key frame after the part.
+//
+// param: part float64   Skipped part of file size from 0 to 1.
+func (s *FlvStream) Seek(part float64) error {
+	offset := int64(float64(s.FlvFile.Size()) * part)
+	var metadata, video_header, audio_header *model.FlvFrame
+	for !s.FlvFile.IsFinished() {
+		header, data, err := s.FlvFile.ReadTag()
+		if err != nil {
+			return err
+		}
+		frame := &model.FlvFrame{Header: header, Frame: data}
+		switch header.TagType {
+		case flv.SCRIPT_DATA_TAG:
+			metadata = frame
+		case flv.AUDIO_TAG:
+			if media.IsAACSequenceHeader(data) {
+				audio_header = frame
+			}
+		case flv.VIDEO_TAG:
+			if media.IsAVCSequenceHeader(data) {
+				video_header = frame
+				continue
+			}
+			if s.FlvFile.CurrentOffset() < offset || !media.IsKeyFrame(data) {
+				continue
+			}
+			s.pending = nil
+			for _, f := range []*model.FlvFrame{
+				metadata, video_header, audio_header} {
+				if f != nil {
+					tag_header := *f.Header
+					tag_header.Timestamp = header.Timestamp
+					s.pending = append(s.pending,
+						&model.FlvFrame{Header: &tag_header, Frame: f.Frame})
+				}
+			}
+			s.pending = append(s.pending, frame)
+			return nil
+		}
+	}
+	s.FlvFile.LoopBack()
+	return nil
+}
+
+// Plays the test flv file in loop until the file is closed.
+// Frames timestamps grow monotonically through the loops,
+// frames are sent by schedule of the pacer.
 func (s *FlvStream) PlayFile() {
+	defer s.FlvFile.Close()
 	startTs := uint32(0)
-	startAt := time.Now().UnixNano()
-	preTs := uint32(0)
+	baseTs := uint32(0)
+	lastTs := uint32(0)
+	started := false
 	for {
-		if s.FlvFile.IsFinished() {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+		if len(s.pending) == 0 && s.FlvFile.IsFinished() {
 			s.FlvFile.LoopBack()
-			startAt = time.Now().UnixNano()
-			startTs = uint32(0)
-			preTs = uint32(0)
+			baseTs = lastTs + LOOP_GAP
+			started = false
 		}
-		header, data, err := s.FlvFile.ReadTag()
+		header, data, err := s.readTag()
 
 		if err != nil {
 			log.Printf("Read rtmp tag ERROR: %s", err.Error())
 			return
 		}
 
-		if startTs == uint32(0) {
+		if !started {
 			startTs = header.Timestamp
+			started = true
 		}
 
-		delta_timestamp := uint32(0)
+		delta_timestamp := baseTs
 
 		if header.Timestamp > startTs {
-			delta_timestamp = header.Timestamp - startTs
+			delta_timestamp += header.Timestamp - startTs
 		}
-		if delta_timestamp > preTs {
-			preTs = delta_timestamp
+		if delta_timestamp > lastTs {
+			lastTs = delta_timestamp
 		}
 
+		send_at, delay, ok := s.pacer.Wait(delta_timestamp, s.done)
+		if !ok {
+			return
+		}
 		frame := &model.FlvFrame{
-			Header: header,
-			Frame:  data,
+			Header:         header,
+			Frame:          data,
+			DeltaTimestamp: delta_timestamp,
+			Tag:            media.ParseTag(header.TagType, data),
+			SendAt:         send_at,
+			SendDelay:      delay,
 		}
-		signal := model.NewSignal(model.ADD_FRAME, "flv_stream")
-		signal.Data = frame
-		s.handler.OnSignal(signal)
-		delta2 := uint32((time.Now().UnixNano() - startAt) / 1000000)
+		s.fanout.Broadcast(frame)
+	}
+}
 
-		if delta_timestamp > delta2+100 {
-			time.Sleep(time.Millisecond * time.Duration(delta_timestamp-delta2))
-		}
+// Returns the next pending frame or the next file tag.
+func (s *FlvStream) readTag() (*flv.TagHeader, []byte, error) {
+	if len(s.pending) > 0 {
+		frame := s.pending[0]
+		s.pending = s.pending[1:]
+		return frame.Header, frame.Frame, nil
 	}
+	return s.FlvFile.ReadTag()
 }
 
-// Closes flv file.
+// Stops playing of flv file.
+// The file is closed by the playing loop.
 func (s *FlvStream) CloseFile() {
-	s.FlvFile.Close()
+	s.once.Do(func() {
+		close(s.done)
+	})
 }
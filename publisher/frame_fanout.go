@@ -0,0 +1,155 @@
+package publisher
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+)
+
+// Policies of full publisher frame queue.
+const (
+	OVERFLOW_DROP       string = "drop"       // Drops new frame.
+	OVERFLOW_BLOCK      string = "block"      // Waits for free space.
+	OVERFLOW_DISCONNECT string = "disconnect" // Disconnects publisher.
+)
+
+// Default capacity of publisher frame queue.
+const DEFAULT_FRAME_QUEUE_SIZE = 64
+
+// Checks overflow policy name.
+// Empty policy is the drop policy.
+//
+// param: policy string   Overflow policy.
+func CheckOverflowPolicy(policy string) error {
+	switch policy {
+	case "", OVERFLOW_DROP, OVERFLOW_BLOCK, OVERFLOW_DISCONNECT:
+		return nil
+	}
+	return fmt.Errorf("unknown frame queue overflow policy %q", policy)
+}
+
+// Fan-out of flv frames to publishers frame queues.
+type FrameFanout struct {
+	mutex  sync.RWMutex
+	queues map[*FrameQueue]bool // Subscribed frame queues.
+}
+
+// Returns new frame fan-out.
+func NewFrameFanout() *FrameFanout {
+	return &FrameFanout{
+		queues: make(map[*FrameQueue]bool),
+	}
+}
+
+// Returns new frame queue subscribed to fan-out frames.
+//
+// params: size   int      Queue capacity.
+//         policy string   Overflow policy.
+func (f *FrameFanout) Subscribe(size int, policy string) *FrameQueue {
+	if size < 1 {
+		size = DEFAULT_FRAME_QUEUE_SIZE
+	}
+	if policy == "" {
+		policy = OVERFLOW_DROP
+	}
+	q := &FrameQueue{
+		frames:    make(chan *model.FlvFrame, size),
+		done_chan: make(chan bool),
+		policy:    policy,
+		fanout:    f,
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.queues[q] = true
+	return q
+}
+
+// Pushes frame to all subscribed queues.
+//
+// param: frame *model.FlvFrame   Flv frame.
+func (f *FrameFanout) Broadcast(frame *model.FlvFrame) {
+	f.mutex.RLock()
+	queues := make([]*FrameQueue, 0, len(f.queues))
+	for q := range f.queues {
+		queues = append(queues, q)
+	}
+	f.mutex.RUnlock()
+	for _, q := range queues {
+		q.push(frame)
+	}
+}
+
+// Removes queue from subscribed queues.
+//
+// param: q *FrameQueue   Frame queue.
+func (f *FrameFanout) unsubscribe(q *FrameQueue) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.queues, q)
+}
+
+// Bounded queue of publisher flv frames.
+type FrameQueue struct {
+	frames     chan *model.FlvFrame // Queued frames.
+	done_chan  chan bool            // Closed when queue is closed.
+	close_once sync.Once
+	policy     string       // Overflow policy.
+	fanout     *FrameFanout // Fan-out of the queue.
+	dropped    int64        // Count of dropped frames.
+	overflowed int32        // Queue is closed by overflow.
+}
+
+// Returns channel of queued frames.
+func (q *FrameQueue) Frames() <-chan *model.FlvFrame {
+	return q.frames
+}
+
+// Returns channel which is closed when queue is closed.
+func (q *FrameQueue) Done() <-chan bool {
+	return q.done_chan
+}
+
+// Returns count of dropped frames.
+func (q *FrameQueue) Dropped() int64 {
+	return atomic.LoadInt64(&q.dropped)
+}
+
+// Returns true if queue is closed by disconnect overflow policy.
+func (q *FrameQueue) Overflowed() bool {
+	return atomic.LoadInt32(&q.overflowed) == 1
+}
+
+// Unsubscribes queue from fan-out and closes it.
+func (q *FrameQueue) Close() {
+	q.close_once.Do(func() {
+		q.fanout.unsubscribe(q)
+		close(q.done_chan)
+	})
+}
+
+// Pushes frame to queue following overflow policy.
+//
+// param: frame *model.FlvFrame   Flv frame.
+func (q *FrameQueue) push(frame *model.FlvFrame) {
+	if q.policy == OVERFLOW_BLOCK {
+		select {
+		case q.frames <- frame:
+		case <-q.done_chan:
+		}
+		return
+	}
+	select {
+	case q.frames <- frame:
+		return
+	case <-q.done_chan:
+		return
+	default:
+	}
+	atomic.AddInt64(&q.dropped, 1)
+	if q.policy == OVERFLOW_DISCONNECT {
+		atomic.StoreInt32(&q.overflowed, 1)
+		q.Close()
+	}
+}
@@ -0,0 +1,21 @@
+package publisher
+
+import "github.com/instrumentisto/go-rtmp-bot/model"
+
+// Source of test media frames fanned out to publishers.
+type MediaSource interface {
+	Subscribe(size int, policy string) *FrameQueue // Returns new publisher frame queue.
+	PlayFile()                                     // Plays media until the source is closed.
+	CloseFile()                                    // Stops playing of media.
+}
+
+// Opens test flv file or synthetic media source.
+//
+// params: path   string          Test flv file path or synthetic media source.
+//         pacing *model.Pacing   Frames pacing, nil is realtime pacing.
+func OpenSource(path string, pacing *model.Pacing) (MediaSource, error) {
+	if model.IsSyntheticSource(path) {
+		return NewSyntheticStream(path, pacing)
+	}
+	return NewFlvFile(path, pacing)
+}
@@ -0,0 +1,90 @@
+package publisher
+
+import (
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"time"
+)
+
+// Scheduler of test media frames.
+// Schedule is measured by monotonic clock from the first frame.
+type Pacer struct {
+	speed    float64       // Speed factor, 0 sends frames at once.
+	lead     time.Duration // Allowed lead of schedule.
+	lag      time.Duration // Lag of schedule caught up by burst.
+	started  bool          // The first frame is scheduled.
+	start_at time.Time     // Schedule time of the first frame.
+	start_ts uint32        // Timestamp of the first frame.
+}
+
+// Returns new pacer.
+//
+// param: pacing *model.Pacing   Pacing options, nil is realtime pacing.
+func NewPacer(pacing *model.Pacing) *Pacer {
+	p := &Pacer{
+		speed: 1,
+		lead:  model.DEFAULT_PACING_LEAD * time.Millisecond,
+		lag:   model.DEFAULT_PACING_LAG * time.Millisecond,
+	}
+	if pacing != nil {
+		p.speed = pacing.Speed()
+		if pacing.PacingLead > 0 {
+			p.lead = time.Duration(pacing.PacingLead) * time.Millisecond
+		}
+		if pacing.PacingLag > 0 {
+			p.lag = time.Duration(pacing.PacingLag) * time.Millisecond
+		}
+	}
+	return p
+}
+
+// Waits for schedule of frame.
+// Returns schedule time of the frame, zero time if frames are not
+// scheduled, deviation of sending from the schedule measured when
+// waiting is finished, and false if the done channel is closed.
+// Deviation is negative if the frame is sent ahead of schedule.
+//
+// params: timestamp uint32            Continuous frame timestamp in ms.
+//         done      <-chan struct{}   Closed to stop waiting.
+func (p *Pacer) Wait(timestamp uint32,
+	done <-chan struct{}) (time.Time, time.Duration, bool) {
+	if p.speed <= 0 {
+		select {
+		case <-done:
+			return time.Time{}, 0, false
+		default:
+			return time.Time{}, 0, true
+		}
+	}
+	if !p.started {
+		p.started = true
+		p.start_at = time.Now()
+		p.start_ts = timestamp
+	}
+	send_at := p.start_at
+	if timestamp > p.start_ts {
+		send_at = send_at.Add(time.Duration(
+			float64(timestamp-p.start_ts) * float64(time.Millisecond) / p.speed))
+	}
+	if late := time.Since(send_at); late > p.lag {
+		// Shifts schedule instead of burst of all late frames.
+		p.start_at = p.start_at.Add(late)
+		send_at = send_at.Add(late)
+	}
+	wait := time.Until(send_at) - p.lead
+	if wait <= 0 {
+		select {
+		case <-done:
+			return send_at, 0, false
+		default:
+			return send_at, time.Since(send_at), true
+		}
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return send_at, 0, false
+	case <-timer.C:
+		return send_at, time.Since(send_at), true
+	}
+}
@@ -2,11 +2,13 @@ package publisher
 
 import (
 	"github.com/instrumentisto/go-rtmp-bot/controller"
+	"github.com/instrumentisto/go-rtmp-bot/media"
 	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/transport"
 	"github.com/instrumentisto/go-rtmp-bot/utils"
 	"github.com/zhangpeihao/goflv"
-	rtmp "github.com/zhangpeihao/gortmp"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -14,81 +16,232 @@ import (
 // Publishes RTMP stream to media server.
 type Publisher struct {
 	status             uint                     // RTMP connection status.
-	createStreamChan   chan rtmp.OutboundStream // The channel for created RTMP stream instance.
+	createStreamChan   chan transport.Stream    // The channel for created RTMP stream instance.
 	serverURL          string                   // Media server URL.
+	dialer             *controller.Dialer       // RTMP connections dialer.
 	streamID           string                   // Stream key.
-	stop_chanel        chan bool                // The channel for stop publisher instance.
-	test_handler       *controller.AppHandler   // Application signal handler reference.
-	FlvChan            chan *model.FlvFrame     // Test .flv file url for streaming.
-	obConn             rtmp.OutboundConn        // RTMP connection reference.
+	test_handler       controller.SignalHandler // Client events handler.
+	frames             *FrameQueue              // Queue of flv frames to publish.
+	obConn             transport.Conn           // RTMP connection reference.
 	id                 string                   // RTMP client identifier.
 	stat               *model.StatItem          // Statistic item instance.
-	start_command_time int64                    // Start command UNIX time.
-	startedAt          int64                    // Publish started UNIX time.
+	timeline           *model.ConnTimeline      // Connection lifecycle timeline.
+	startedAt          time.Time                // Publish started time.
 	old_frame_count    int64                    // Count of sends video frames.
-	published_stream   rtmp.OutboundStream
+	old_bytes_count    int64                    // Count of sends bytes.
+	start_timestamp    uint32                   // Timestamp of the first published frame.
+	timestamp_started  bool                     // The first frame is published.
+	published_stream   transport.Stream
+	mutex              sync.Mutex               // Guards status, statistic and published stream.
+	reconnect          *model.ReconnectPolicy   // Reconnect policy.
+	lost_chan          chan struct{}            // The channel for connection loss.
+	generation         int                      // Number of the current connection.
+	published          bool                     // The current connection publishes stream.
+	lost_at            time.Time                // Connection loss time, zero while publishing.
+	downtime           time.Duration            // Total time without connection after its loss.
+	lifecycle          *model.ClientLifecycle   // Client lifecycle state machine.
+	verify             bool                     // Frames are tagged for integrity verification.
+	frame_seq          uint64                   // Sequence number of the last tagged frame.
+	media              *model.MediaTracker      // Published media tracker.
+	metadata           *model.FlvFrame          // The latest metadata frame.
+	video_header       *model.FlvFrame          // The latest AVC sequence header frame.
+	audio_header       *model.FlvFrame          // The latest AAC sequence header frame.
+	headers_sent       bool                     // Cached headers are published to the current stream.
+	jitter             *model.Distribution      // Deviations of frames sending from schedule in ms.
 }
 
 // Constructs new RTMP Publisher instance.
 //
 // params: Media server URL             string;
 //         Stream key                   string;
-//         Application signal handler   *controller.AppHandler
+//         Client events handler        controller.SignalHandler
+//         Flv frames queue             *FrameQueue
+//         RTMP connections dialer      *controller.Dialer
+//         Reconnect policy             *model.ReconnectPolicy
+//         Integrity verification mode  bool
 //
 // return new *Publisher instance.
 func NewPublisher(
 	url string, stream_key string,
-	test_handler *controller.AppHandler,
-	flv_chan chan *model.FlvFrame) *Publisher {
+	test_handler controller.SignalHandler,
+	frames *FrameQueue, dialer *controller.Dialer,
+	reconnect *model.ReconnectPolicy, verify bool) *Publisher {
 	client_id := utils.GetUUID()
 	return &Publisher{
 		status:             uint(0),
 		serverURL:          url,
+		dialer:             dialer,
 		streamID:           stream_key,
-		stop_chanel:        make(chan bool),
 		test_handler:       test_handler,
 		id:                 client_id,
 		stat:               model.NewStatItem(model.ROLE_PUBLISHER, stream_key, client_id),
-		start_command_time: 0,
-		startedAt:          0,
+		timeline:           model.NewConnTimeline(),
 		old_frame_count:    0,
-		FlvChan:            flv_chan,
+		frames:             frames,
+		createStreamChan:   make(chan transport.Stream, 1),
+		reconnect:          reconnect,
+		lost_chan:          make(chan struct{}, 1),
+		lifecycle:          model.NewClientLifecycle(),
+		verify:             verify,
+		media:              model.NewMediaTracker(),
+		jitter:             model.NewDistribution(model.DISTRIBUTION_SIZE),
 	}
 }
 
 // Runs publish stream.
+// Publishes queued frames until the publisher is stopped
+// or its frames queue overflows with disconnect policy.
+// Reconnects after connection failure if reconnect policy allows it.
 func (p *Publisher) Run() {
-	p.start_command_time = time.Now().Unix()
-	p.createStreamChan = make(chan rtmp.OutboundStream)
-	var err error
+	defer p.frames.Close()
+	go p.sendFrames()
+	p.timeline.Start()
+	attempt := 0
+	for {
+		published, stopped := p.runConnection()
+		if stopped {
+			return
+		}
+		p.connectionDown(published)
+		if published {
+			attempt = 0
+		}
+		attempt++
+		if !p.reconnect.CanRetry(attempt) {
+			return
+		}
+		backoff := p.reconnect.Backoff(attempt)
+		log.Printf("publisher %s reconnects in %s, attempt %d", p.id, backoff, attempt)
+		select {
+		case <-time.After(backoff):
+		case <-p.frames.Done():
+			return
+		}
+		p.mutex.Lock()
+		p.stat.Reconnects++
+		p.mutex.Unlock()
+	}
+}
+
+// Runs single RTMP connection until it fails or the publisher is stopped.
+// Returns true as published if the connection published stream
+// and true as stopped if the publisher is stopped.
+func (p *Publisher) runConnection() (published bool, stopped bool) {
+	generation := p.newConnection()
 	testHandler := &controller.RTMPHandler{
-		Handler: p.test_handler,
-		ID:      p.id,
+		Handler:  p.test_handler,
+		ID:       p.id,
+		Timeline: p.timeline,
+		Lost: func() {
+			p.connectionLost(generation)
+		},
 	}
-	p.obConn, err = rtmp.Dial(p.serverURL, testHandler, 100)
+	var err error
+	p.obConn, err = p.dialer.Dial(p.serverURL, testHandler)
 	if err != nil {
 		log.Printf("publisher dial error %s", err.Error())
-		p.stat.Status = model.STATUS_DESCRIPTIONS[6]
-		return
+		p.setErrorStatus(model.CAUSE_DIAL_ERROR)
+		return false, false
 	}
 	defer p.obConn.Close()
 	err = p.obConn.Connect()
 	if err != nil {
 		log.Printf("publisher connection error %s", err.Error())
-		p.stat.Status = model.STATUS_DESCRIPTIONS[6]
-		return
+		p.setErrorStatus(model.CAUSE_CONNECT_ERROR)
+		return false, false
 	}
 	for {
 		select {
 		case stream := <-p.createStreamChan:
-			stream.Attach(testHandler)
-			err = stream.Publish(p.streamID, "live")
+			err = stream.Publish(p.streamID)
 			if err != nil {
 				log.Printf("publisher publish error %s", err.Error())
-				p.stat.Status = model.STATUS_DESCRIPTIONS[6]
-				return
+				p.setErrorStatus(model.CAUSE_STREAM_ERROR)
+				return false, false
 			}
-		case <-p.stop_chanel:
+		case <-p.lost_chan:
+			log.Printf("publisher %s connection lost", p.id)
+			p.mutex.Lock()
+			published = p.published
+			p.mutex.Unlock()
+			return published, false
+		case <-p.frames.Done():
+			if p.frames.Overflowed() {
+				log.Printf("publisher %s frames queue overflow, disconnecting", p.id)
+				p.SetStatus(transport.STATUS_CLOSE)
+				p.lifecycle.Transit(model.STATE_CLOSED, model.CAUSE_QUEUE_OVERFLOW)
+			} else {
+				p.lifecycle.Transit(model.STATE_CLOSED, model.CAUSE_STOPPED)
+			}
+			return false, true
+		}
+	}
+}
+
+// Starts new connection generation.
+// Events of previous connections are dropped.
+//
+// return number of the new connection.
+func (p *Publisher) newConnection() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.generation++
+	p.published = false
+	p.media.Interrupt()
+	if p.generation == 1 {
+		p.lifecycle.Transit(model.STATE_CONNECTING, model.REASON_START)
+	} else {
+		p.lifecycle.Transit(model.STATE_CONNECTING, model.REASON_RECONNECT)
+	}
+	select {
+	case <-p.lost_chan:
+	default:
+	}
+	select {
+	case <-p.createStreamChan:
+	default:
+	}
+	return p.generation
+}
+
+// Notifies Run of connection loss.
+// Losses of previous connections are ignored.
+//
+// param: generation int   Number of lost connection.
+func (p *Publisher) connectionLost(generation int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if generation != p.generation {
+		return
+	}
+	select {
+	case p.lost_chan <- struct{}{}:
+	default:
+	}
+}
+
+// Closes stream of lost connection and starts downtime
+// if the connection published stream.
+//
+// param: published bool   The connection published stream.
+func (p *Publisher) connectionDown(published bool) {
+	p.SetStatus(transport.STATUS_CLOSE)
+	p.lifecycle.Transit(model.STATE_CLOSED, model.CAUSE_CONNECTION_LOST)
+	p.closeStream()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if published && p.lost_at.IsZero() {
+		p.lost_at = time.Now()
+	}
+}
+
+// Publishes queued frames until the queue is closed.
+func (p *Publisher) sendFrames() {
+	for {
+		select {
+		case frame := <-p.frames.Frames():
+			p.AddFrame(frame)
+		case <-p.frames.Done():
 			return
 		}
 	}
@@ -96,73 +249,224 @@ func (p *Publisher) Run() {
 
 //Publishes test .flv file data to existed RTMP stream.
 //
-// param: stream   rtmp.OutboundStream
-func (p *Publisher) PublishStream(stream rtmp.OutboundStream) {
+// param: stream   transport.Stream
+func (p *Publisher) PublishStream(stream transport.Stream) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	p.published_stream = stream
-	p.startedAt = time.Now().Unix()
+	p.published = true
+	p.headers_sent = false
+	p.lifecycle.Transit(model.STATE_PUBLISHING, model.REASON_PUBLISH_START)
+	p.timestamp_started = false
+	if p.startedAt.IsZero() {
+		p.startedAt = time.Now()
+	}
+	if !p.lost_at.IsZero() {
+		p.downtime += time.Since(p.lost_at)
+		p.lost_at = time.Time{}
+	}
 }
 
+// Publishes flv frame to RTMP stream.
+// Cached metadata and sequence headers are published before the first
+// frame of stream, so the stream is decodable from any source position.
+// Statistic is updated under lock, the frame is sent without it.
+//
+// param: frame *model.FlvFrame   Flv frame to publish.
 func (p *Publisher) AddFrame(frame *model.FlvFrame) {
-	if p.published_stream == nil {
+	p.mutex.Lock()
+	p.cacheHeader(frame)
+	published_stream := p.published_stream
+	generation := p.generation
+	if published_stream == nil ||
+		p.status != transport.STATUS_CREATE_STREAM_OK {
+		p.mutex.Unlock()
 		return
 	}
-
-	if p.status != rtmp.OUTBOUND_CONN_STATUS_CREATE_STREAM_OK {
-		return
+	var headers []*model.FlvFrame
+	if !p.headers_sent {
+		headers = p.cachedHeaders(frame)
+		p.headers_sent = true
+	}
+	// Stream timestamps start from zero for any start position of source.
+	if !p.timestamp_started {
+		p.start_timestamp = frame.DeltaTimestamp
+		p.timestamp_started = true
 	}
+	timestamp := uint32(0)
+	if frame.DeltaTimestamp > p.start_timestamp {
+		timestamp = frame.DeltaTimestamp - p.start_timestamp
+	}
+	for _, header := range headers {
+		p.media.OnTag(header.Tag, timestamp)
+	}
+	data := frame.Frame
 	switch frame.Header.TagType {
 	case flv.AUDIO_TAG:
 		if p.stat.AudioBytes == 0 {
-			p.stat.AudioStartUpTime = time.Now().Unix() - p.start_command_time
+			p.timeline.Mark(model.PHASE_FIRST_AUDIO)
+			p.stat.AudioStartUpTime = int64(
+				p.timeline.Elapsed(model.PHASE_FIRST_AUDIO) / time.Millisecond)
 		}
-		p.stat.AudioBytes += int64(len(frame.Frame))
+		p.stat.AudioBytes += int64(len(data))
 	case flv.VIDEO_TAG:
+		// Embeds wall clock marker for players latency measurement
+		// and frame integrity fields in verification mode.
+		if media.IsAVCNalus(data) {
+			marker := media.NewMarker(time.Now())
+			if p.verify {
+				p.frame_seq++
+				marker.SetIntegrity(p.frame_seq, timestamp, data)
+			}
+			if marked, ok := media.InsertBotSEI(
+				data, marker.Encode(), p.naluLengthSize()); ok {
+				data = marked
+			}
+		}
 		if p.stat.VideoBytes == 0 {
-			p.stat.VideoStartUpTime = time.Now().Unix() - p.start_command_time
+			p.timeline.Mark(model.PHASE_FIRST_VIDEO)
+			p.stat.VideoStartUpTime = int64(
+				p.timeline.Elapsed(model.PHASE_FIRST_VIDEO) / time.Millisecond)
 		}
-		p.stat.VideoBytes += int64(len(frame.Frame))
+		p.stat.VideoBytes += int64(len(data))
 		p.stat.TotalFrames++
 	}
+	if p.media.OnTag(frame.Tag, timestamp) {
+		p.timeline.Mark(model.PHASE_FIRST_KEY)
+	}
+	// Deviation is measured by pacer when the frame leaves the source,
+	// so frames queue delay is not counted as jitter.
+	if !frame.SendAt.IsZero() {
+		jitter := frame.SendDelay
+		if jitter < 0 {
+			jitter = -jitter
+		}
+		p.jitter.Add(int64(jitter / time.Millisecond))
+	}
+	p.mutex.Unlock()
 
-	if err := p.published_stream.PublishData(
-		frame.Header.TagType, frame.Frame,
-		frame.DeltaTimestamp); err != nil {
-		log.Printf("publish data ERROR: %s", err.Error())
-		p.SetStatus(rtmp.OUTBOUND_CONN_STATUS_CLOSE)
-		p.published_stream.Close()
-		p.published_stream = nil
+	for _, header := range headers {
+		if err := published_stream.PublishData(
+			header.Header.TagType, header.Frame, timestamp); err != nil {
+			p.publishFailed(err, generation)
+			return
+		}
+	}
+	if err := published_stream.PublishData(
+		frame.Header.TagType, data, timestamp); err != nil {
+		p.publishFailed(err, generation)
 		return
 	}
 }
 
+// Closes connection after failed publishing of data.
+//
+// params: err        error   Publishing error.
+//         generation int     Number of connection.
+func (p *Publisher) publishFailed(err error, generation int) {
+	log.Printf("publish data ERROR: %s", err.Error())
+	p.SetStatus(transport.STATUS_CLOSE)
+	p.lifecycle.Transit(model.STATE_FAILED, model.CAUSE_SEND_ERROR)
+	p.closeStream()
+	p.connectionLost(generation)
+}
+
+// Caches metadata and sequence header frames.
+//
+// param: frame *model.FlvFrame   Flv frame of source.
+func (p *Publisher) cacheHeader(frame *model.FlvFrame) {
+	switch {
+	case frame.Tag.Type == media.TAG_SCRIPT:
+		p.metadata = frame
+	case frame.Tag.Type == media.TAG_VIDEO && frame.Tag.SequenceHeader:
+		p.video_header = frame
+	case frame.Tag.Type == media.TAG_AUDIO && frame.Tag.SequenceHeader:
+		p.audio_header = frame
+	}
+}
+
+// Returns size of NAL unit length field of the published video.
+func (p *Publisher) naluLengthSize() int {
+	if p.video_header == nil {
+		return media.NALU_LENGTH_SIZE
+	}
+	return media.NaluLengthSize(p.video_header.Frame)
+}
+
+// Returns cached metadata and sequence header frames except the frame.
+//
+// param: frame *model.FlvFrame   Frame to publish.
+func (p *Publisher) cachedHeaders(frame *model.FlvFrame) []*model.FlvFrame {
+	var headers []*model.FlvFrame
+	for _, header := range []*model.FlvFrame{
+		p.metadata, p.video_header, p.audio_header} {
+		if header != nil && header != frame {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// Closes published RTMP stream if it is set.
+func (p *Publisher) closeStream() {
+	p.mutex.Lock()
+	published_stream := p.published_stream
+	p.published_stream = nil
+	p.mutex.Unlock()
+	if published_stream != nil {
+		published_stream.Close()
+	}
+}
+
 // This method implements IRTMPClient interface only.
 //
-// param: rtmp stream   rtmp.OutboundStream
-func (p *Publisher) PlayStream(message *rtmp.Message) {
+// param: message   *transport.Message
+func (p *Publisher) PlayStream(message *transport.Message) {
 	// Does nothing
 }
 
 // Stops publisher.
+// Closes frames queue, so the publisher connection is closed.
 func (p *Publisher) Stop() {
-	if p.published_stream != nil {
-		p.published_stream.Close()
-	}
-	p.stop_chanel <- true
+	p.closeStream()
+	p.frames.Close()
 }
 
 // Sets RTMP connection status.
+// Successful connect command moves lifecycle to connected state.
 //
 // param: status uint
 func (p *Publisher) SetStatus(status uint) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
 	p.status = status
 	p.stat.Status = model.STATUS_DESCRIPTIONS[p.status]
+	if status == transport.STATUS_CONNECT_OK {
+		p.lifecycle.Transit(model.STATE_CONNECTED, model.REASON_CONNECT_OK)
+	}
+}
+
+// Sets connection error status description and fails lifecycle.
+//
+// param: cause string   Failure cause.
+func (p *Publisher) setErrorStatus(cause string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stat.Status = model.STATUS_DESCRIPTIONS[6]
+	p.lifecycle.Transit(model.STATE_FAILED, cause)
 }
 
 // Sets reference to existed RTMP stream.
+// Does not block events dispatching: the stream is dropped
+// if another created stream is still not taken by Run.
 //
-// param: stream rtmp.OutboundStream
-func (p *Publisher) SetStream(stream rtmp.OutboundStream) {
-	p.createStreamChan <- stream
+// param: stream transport.Stream
+func (p *Publisher) SetStream(stream transport.Stream) {
+	select {
+	case p.createStreamChan <- stream:
+	default:
+		log.Printf("Publisher %s dropped created stream", p.id)
+	}
 }
 
 // Returns RTMP client identifier.
@@ -179,26 +483,48 @@ func (p *Publisher) GetStreamKey() string {
 	return p.streamID
 }
 
-// Returns statistic item instance.
+// Returns snapshot of statistic item.
 //
 // return StatItem.
 func (p *Publisher) GetStat() *model.StatItem {
-	return p.stat
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.stat.Snapshot()
 }
 
 // Updates client statistic.
+// Rates of not connected publisher are zero.
+// Publishing without frames in statistic interval is stalled.
 func (p *Publisher) UpdateStat() {
-	if p.status == rtmp.OUTBOUND_CONN_STATUS_CREATE_STREAM_OK {
-		p.stat.TotalTime = time.Now().Unix() - p.startedAt
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.status == transport.STATUS_CREATE_STREAM_OK {
+		p.stat.TotalTime = int64(time.Since(p.startedAt) / time.Second)
 		p.stat.FPS = p.stat.TotalFrames - p.old_frame_count
-		p.old_frame_count = p.stat.TotalFrames
+		bytes_count := p.stat.AudioBytes + p.stat.VideoBytes
+		p.stat.Bitrate = (bytes_count - p.old_bytes_count) * 8
+	} else {
+		p.stat.FPS = 0
+		p.stat.Bitrate = 0
 	}
-}
-
-// Check any panic.
-func (p *Publisher) onRecover() {
-	if r := recover(); r != nil {
-		log.Printf("RECOVER on Publisher %s", r)
-		p.Stop()
+	p.old_frame_count = p.stat.TotalFrames
+	p.old_bytes_count = p.stat.AudioBytes + p.stat.VideoBytes
+	switch state := p.lifecycle.State(); {
+	case state == model.STATE_PUBLISHING && p.stat.FPS == 0:
+		p.lifecycle.Transit(model.STATE_STALLED, model.REASON_NO_MEDIA)
+	case state == model.STATE_STALLED && p.stat.FPS > 0:
+		p.lifecycle.Transit(model.STATE_PUBLISHING, model.REASON_MEDIA_RESUMED)
+	}
+	p.stat.State = p.lifecycle.State()
+	p.stat.Transitions = p.lifecycle.Transitions()
+	p.stat.Disconnects = p.lifecycle.Disconnects()
+	p.media.Update(p.stat)
+	p.stat.SendJitter = p.jitter.Summary()
+	p.stat.DroppedFrames = p.frames.Dropped()
+	downtime := p.downtime
+	if !p.lost_at.IsZero() {
+		downtime += time.Since(p.lost_at)
 	}
+	p.stat.Downtime = int64(downtime / time.Millisecond)
+	p.stat.Timings = p.timeline.Durations()
 }
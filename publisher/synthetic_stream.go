@@ -0,0 +1,194 @@
+package publisher
+
+import (
+	"github.com/instrumentisto/go-rtmp-bot/media"
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/zhangpeihao/goflv"
+	"sync"
+	"time"
+)
+
+// Name of synthetic media encoder in metadata.
+const SYNTHETIC_ENCODER = "go-rtmp-bot"
+
+// Generates synthetic media paced by frames timestamps.
+// Fans out generated frames to publishers frame queues.
+type SyntheticStream struct {
+	params *model.SyntheticMedia // Synthetic media parameters.
+	video  *media.SyntheticVideo // Video generator.
+	audio  *media.SyntheticAudio // Audio generator, nil without audio.
+	fanout *FrameFanout          // Fan-out of frames to publishers.
+	done   chan struct{}         // Closed to stop playing.
+	once   sync.Once             // Closes done channel once.
+	mutex  sync.Mutex            // Guards requested video rate.
+	rate   *videoRate            // Requested video rate, nil if it is applied.
+	pacer  *Pacer                // Scheduler of frames sending.
+}
+
+// Video bitrate and frame rate.
+type videoRate struct {
+	bitrate int // Video bitrate in bits per second.
+	fps     int // Video frame rate.
+}
+
+// Creates new instance of SyntheticStream.
+//
+// params: source string          Synthetic media source.
+//         pacing *model.Pacing   Frames pacing, nil is realtime pacing.
+func NewSyntheticStream(
+	source string, pacing *model.Pacing) (*SyntheticStream, error) {
+	m, err := model.ParseSyntheticMedia(source)
+	if err != nil {
+		return nil, err
+	}
+	s := &SyntheticStream{
+		params: m,
+		video:  media.NewSyntheticVideo(m.Width, m.Height, m.Bitrate, m.FPS),
+		fanout: NewFrameFanout(),
+		done:   make(chan struct{}),
+		pacer:  NewPacer(pacing),
+	}
+	if m.AudioRate > 0 {
+		s.audio = media.NewSyntheticAudio(m.AudioRate, m.Channels)
+	}
+	return s, nil
+}
+
+// Returns new publisher frame queue subscribed to the generated frames.
+//
+// params: size   int      Queue capacity.
+//         policy string   Overflow policy.
+func (s *SyntheticStream) Subscribe(size int, policy string) *FrameQueue {
+	return s.fanout.Subscribe(size, policy)
+}
+
+// Changes video bitrate and frame rate of generated media.
+// The rate is applied before the next video frame.
+// Zero value keeps the current one.
+//
+// params: bitrate int   Video bitrate in bits per second.
+//         fps     int   Video frame rate.
+func (s *SyntheticStream) SetVideoRate(bitrate int, fps int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.rate = &videoRate{bitrate: bitrate, fps: fps}
+}
+
+// Returns requested video rate once or nil.
+func (s *SyntheticStream) requestedRate() *videoRate {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rate := s.rate
+	s.rate = nil
+	return rate
+}
+
+// Generates media until the stream is closed.
+// Metadata and sequence headers are sent first, then video and audio
+// frames are sent by schedule of the pacer. Changed video rate starts
+// from the next frame, changed sequence header starts new group
+// of pictures.
+func (s *SyntheticStream) PlayFile() {
+	send_at, delay, ok := s.pacer.Wait(0, s.done)
+	if !ok {
+		return
+	}
+	s.broadcast(media.TAG_SCRIPT, s.metadata(), 0, send_at, delay)
+	s.broadcast(media.TAG_VIDEO, s.video.SequenceHeader(), 0, send_at, delay)
+	if s.audio != nil {
+		s.broadcast(media.TAG_AUDIO, s.audio.SequenceHeader(), 0, send_at, delay)
+	}
+	var video_frames, audio_frames, gop_frames int64
+	var base_frames int64
+	var base_ts uint32
+	for {
+		video_ts := base_ts + uint32(
+			(video_frames-base_frames)*1000/int64(s.params.FPS))
+		tag_type, timestamp := media.TAG_VIDEO, video_ts
+		if s.audio != nil {
+			audio_ts := uint32(audio_frames * media.AAC_FRAME_SAMPLES * 1000 /
+				int64(s.params.AudioRate))
+			if audio_ts < video_ts {
+				tag_type, timestamp = media.TAG_AUDIO, audio_ts
+			}
+		}
+		send_at, delay, ok := s.pacer.Wait(timestamp, s.done)
+		if !ok {
+			return
+		}
+		if tag_type == media.TAG_AUDIO {
+			s.broadcast(tag_type, s.audio.Frame(), timestamp, send_at, delay)
+			audio_frames++
+			continue
+		}
+		if rate := s.requestedRate(); rate != nil {
+			if rate.bitrate > 0 {
+				s.params.Bitrate = rate.bitrate
+			}
+			if rate.fps > 0 {
+				s.params.FPS = rate.fps
+			}
+			base_ts, base_frames = video_ts, video_frames
+			if s.video.SetRate(s.params.Bitrate, s.params.FPS) {
+				s.broadcast(media.TAG_VIDEO, s.video.SequenceHeader(),
+					video_ts, send_at, delay)
+				gop_frames = 0
+			}
+		}
+		key := gop_frames%int64(s.params.GOP) == 0
+		s.broadcast(tag_type, s.video.Frame(key), timestamp, send_at, delay)
+		video_frames++
+		gop_frames++
+	}
+}
+
+// Sends generated frame to publishers.
+//
+// params: tag_type  byte            FLV tag type.
+//         data      []byte          FLV tag data.
+//         timestamp uint32          Frame timestamp in milliseconds.
+//         send_at   time.Time       Scheduled send time of frame.
+//         delay     time.Duration   Deviation of sending from schedule.
+func (s *SyntheticStream) broadcast(tag_type byte, data []byte,
+	timestamp uint32, send_at time.Time, delay time.Duration) {
+	s.fanout.Broadcast(&model.FlvFrame{
+		Header: &flv.TagHeader{
+			TagType:   tag_type,
+			DataSize:  uint32(len(data)),
+			Timestamp: timestamp,
+		},
+		Frame:          data,
+		DeltaTimestamp: timestamp,
+		Tag:            media.ParseTag(tag_type, data),
+		SendAt:         send_at,
+		SendDelay:      delay,
+	})
+}
+
+// Returns metadata of synthetic media.
+func (s *SyntheticStream) metadata() []byte {
+	m := s.params
+	properties := []media.MetadataProperty{
+		{Name: "width", Value: m.Width},
+		{Name: "height", Value: m.Height},
+		{Name: "framerate", Value: m.FPS},
+		{Name: "videodatarate", Value: float64(m.Bitrate) / 1000},
+		{Name: "videocodecid", Value: int(media.CODEC_AVC)},
+	}
+	if s.audio != nil {
+		properties = append(properties,
+			media.MetadataProperty{Name: "audiosamplerate", Value: m.AudioRate},
+			media.MetadataProperty{Name: "audiosamplesize", Value: 16},
+			media.MetadataProperty{Name: "stereo", Value: m.Channels == 2},
+			media.MetadataProperty{Name: "audiocodecid", Value: int(media.SOUND_AAC)})
+	}
+	return media.NewMetadata(append(properties,
+		media.MetadataProperty{Name: "encoder", Value: SYNTHETIC_ENCODER}))
+}
+
+// Stops generating of media.
+func (s *SyntheticStream) CloseFile() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
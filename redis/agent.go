@@ -0,0 +1,144 @@
+package redis
+
+import (
+	"encoding/json"
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"gopkg.in/redis.v4"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	ASSIGNMENT_PREFIX = "stress_test:assignment:" // Prefix of agent assignment key.
+	REPORT_PREFIX     = "stress_test:report:"     // Prefix of agent report key.
+)
+
+// Default interval of agent heartbeats and test reports.
+const DEFAULT_HEARTBEAT_INTERVAL = time.Second
+
+// Agent of distributed stress test.
+// Registers itself with heartbeats, sends test reports to coordinator
+// and reads load assigned by coordinator.
+type Agent struct {
+	client   *redis.Client  // Redis client.
+	registry *AgentRegistry // Registry of live agents.
+	ttl      time.Duration  // Lifetime of registration and report without heartbeats.
+	info     AgentInfo      // Registration of agent.
+	mutex    sync.Mutex     // Guards registration of agent.
+}
+
+// Returns new agent of distributed stress test.
+//
+// params: r_url    string          Redis server URL.
+//         password string          Redis server password.
+//         db       int             Redis server Data Base ID.
+//         info     AgentInfo       Registration of agent.
+//         ttl      time.Duration   Lifetime of registration without heartbeats.
+func NewAgent(
+	r_url string, password string, db int,
+	info AgentInfo, ttl time.Duration) *Agent {
+	client := redis.NewClient(&redis.Options{
+		Addr:     r_url,
+		Password: password,
+		DB:       db,
+	})
+	if info.Status == "" {
+		info.Status = AGENT_READY
+	}
+	return &Agent{
+		client:   client,
+		registry: NewAgentRegistry(client, ttl),
+		ttl:      ttl,
+		info:     info,
+	}
+}
+
+// Returns agent name.
+func (a *Agent) Name() string {
+	return a.info.Name
+}
+
+// Changes agent status and refreshes registration.
+//
+// param: status string   Agent status.
+func (a *Agent) SetStatus(status string) error {
+	a.mutex.Lock()
+	a.info.Status = status
+	a.mutex.Unlock()
+	return a.Heartbeat()
+}
+
+// Registers agent or refreshes its registration.
+func (a *Agent) Heartbeat() error {
+	a.mutex.Lock()
+	info := a.info
+	a.mutex.Unlock()
+	return a.registry.Register(info)
+}
+
+// Returns load assigned to agent by coordinator.
+func (a *Agent) Assignment() (*model.Assignment, error) {
+	data, err := a.client.Get(ASSIGNMENT_PREFIX + a.info.Name).Result()
+	if err != nil {
+		return nil, err
+	}
+	assignment := &model.Assignment{}
+	if err := json.Unmarshal([]byte(data), assignment); err != nil {
+		return nil, err
+	}
+	return assignment, nil
+}
+
+// Writes test report of agent for coordinator.
+// Report of agent without heartbeats expires with its registration.
+//
+// param: report *model.Report   Test report of agent.
+func (a *Agent) PublishReport(report *model.Report) error {
+	data, err := json.Marshal(report.Snapshot())
+	if err != nil {
+		return err
+	}
+	return a.client.Set(REPORT_PREFIX+a.info.Name, string(data), a.ttl).Err()
+}
+
+// Sends heartbeats and test reports until stop channel is closed.
+// Agent leaves distributed test after stop.
+//
+// params: report   *model.Report     Test report of agent.
+//         interval time.Duration     Heartbeats interval shorter than ttl.
+//         stop     <-chan struct{}   Closed to stop.
+func (a *Agent) Run(
+	report *model.Report, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Heartbeat(); err != nil {
+			log.Printf("Agent heartbeat ERROR: %s", err.Error())
+		}
+		if err := a.PublishReport(report); err != nil {
+			log.Printf("Agent report ERROR: %s", err.Error())
+		}
+		select {
+		case <-stop:
+			if err := a.Leave(); err != nil {
+				log.Printf("Agent leave ERROR: %s", err.Error())
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Removes registration and test report of agent.
+func (a *Agent) Leave() error {
+	if err := a.registry.Unregister(a.info.Name); err != nil {
+		return err
+	}
+	return a.client.Del(REPORT_PREFIX + a.info.Name).Err()
+}
+
+// Closes agent.
+func (a *Agent) Close() {
+	a.client.Close()
+}
@@ -0,0 +1,177 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"gopkg.in/redis.v4"
+	"log"
+	"time"
+)
+
+// Coordinator of distributed stress test.
+// Splits load across live agents and aggregates their test reports.
+type Coordinator struct {
+	client      *redis.Client      // Redis client.
+	registry    *AgentRegistry     // Registry of live agents.
+	report      *model.Report      // Global test report.
+	assignments []model.Assignment // Load assigned to agents.
+	dead        map[string]bool    // Dead agents by name.
+}
+
+// Returns new coordinator of distributed stress test.
+//
+// params: r_url    string          Redis server URL.
+//         password string          Redis server password.
+//         db       int             Redis server Data Base ID.
+//         report   *model.Report   Global test report.
+func NewCoordinator(
+	r_url string, password string, db int, report *model.Report) *Coordinator {
+	client := redis.NewClient(&redis.Options{
+		Addr:     r_url,
+		Password: password,
+		DB:       db,
+	})
+	return &Coordinator{
+		client:   client,
+		registry: NewAgentRegistry(client, DEFAULT_AGENT_TTL),
+		report:   report,
+		dead:     make(map[string]bool),
+	}
+}
+
+// Returns registrations of live agents ordered by name.
+func (c *Coordinator) LiveAgents() ([]AgentInfo, error) {
+	return c.registry.Agents()
+}
+
+// Splits load across live agents and starts test on them.
+// Streams of agents do not overlap. Agents with load above their
+// maximal count of clients are logged.
+//
+// params: test_id      string   Test ID shared by all agents.
+//         model_count  int      Total count of publishers.
+//         client_count int      Count of players per publisher.
+func (c *Coordinator) Start(
+	test_id string, model_count int, client_count int) error {
+	agents, err := c.LiveAgents()
+	if err != nil {
+		return err
+	}
+	if len(agents) == 0 {
+		return fmt.Errorf("no live agents")
+	}
+	names := make([]string, len(agents))
+	max_clients := make(map[string]int)
+	for i, agent := range agents {
+		names[i] = agent.Name
+		max_clients[agent.Name] = agent.MaxClients
+	}
+	c.assignments = model.SplitLoad(test_id, names, model_count, client_count)
+	c.dead = make(map[string]bool)
+	for _, assignment := range c.assignments {
+		clients := assignment.ModelCount * (assignment.ClientCount + 1)
+		if limit := max_clients[assignment.Agent]; limit > 0 && clients > limit {
+			log.Printf("Agent %s assigned %d clients over its maximum %d",
+				assignment.Agent, clients, limit)
+		}
+		data, err := json.Marshal(&assignment)
+		if err != nil {
+			return err
+		}
+		err = c.client.Set(
+			ASSIGNMENT_PREFIX+assignment.Agent, string(data), 0).Err()
+		if err != nil {
+			return err
+		}
+		if err := c.client.Del(REPORT_PREFIX + assignment.Agent).Err(); err != nil {
+			return err
+		}
+		log.Printf("Agent %s assigned %d models from stream %d",
+			assignment.Agent, assignment.ModelCount, assignment.StreamOffset+1)
+	}
+	c.report.ResetReport(test_id, model_count, client_count)
+	return c.client.Publish(STRESS_TEST_CHANNEL, START_COMMAND).Err()
+}
+
+// Stops test on agents and removes their assignments.
+func (c *Coordinator) Stop() error {
+	err := c.client.Publish(STRESS_TEST_CHANNEL, STOP_COMMAND).Err()
+	for _, assignment := range c.assignments {
+		c.client.Del(ASSIGNMENT_PREFIX + assignment.Agent)
+	}
+	c.assignments = nil
+	return err
+}
+
+// Aggregates test reports of assigned agents into the global report.
+// Agents with expired registration are dead, their reports are skipped.
+func (c *Coordinator) Collect() error {
+	agents, err := c.registry.Agents()
+	if err != nil {
+		return err
+	}
+	live := make(map[string]bool)
+	for _, agent := range agents {
+		live[agent.Name] = true
+	}
+	var reports []*model.Report
+	dead := 0
+	for _, assignment := range c.assignments {
+		name := assignment.Agent
+		if !live[name] {
+			if !c.dead[name] {
+				log.Printf("Agent %s is dead", name)
+				c.dead[name] = true
+			}
+			dead++
+			continue
+		}
+		if c.dead[name] {
+			log.Printf("Agent %s is live again", name)
+			delete(c.dead, name)
+		}
+		data, err := c.client.Get(REPORT_PREFIX + name).Result()
+		if err != nil {
+			// Agent has not reported yet.
+			continue
+		}
+		report := &model.Report{}
+		if err := json.Unmarshal([]byte(data), report); err != nil {
+			log.Printf("Agent %s report ERROR: %s", name, err.Error())
+			continue
+		}
+		reports = append(reports, report)
+	}
+	c.report.Aggregate(reports, dead)
+	return nil
+}
+
+// Aggregates test reports of agents until stop channel is closed.
+// Reports are aggregated the last time on stop.
+//
+// params: interval time.Duration     Aggregation interval.
+//         stop     <-chan struct{}   Closed to stop.
+func (c *Coordinator) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+		case <-ticker.C:
+		}
+		if err := c.Collect(); err != nil {
+			log.Printf("Collect reports ERROR: %s", err.Error())
+		}
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// Closes coordinator.
+func (c *Coordinator) Close() {
+	c.client.Close()
+}
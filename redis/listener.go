@@ -99,6 +99,13 @@ func (l *RedisListener) Read(key string) (int64, error) {
 	return l.client.Get(key).Int64()
 }
 
+// Reads string value from redis.
+//
+// params: key string   The values key.
+func (l *RedisListener) ReadString(key string) (string, error) {
+	return l.client.Get(key).Result()
+}
+
 // Closes listener
 func (l *RedisListener) Close() {
 	l.client.Close()
@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"encoding/json"
+	"gopkg.in/redis.v4"
+	"sort"
+	"time"
+)
+
+// Prefix of agent registration key.
+const AGENT_PREFIX = "stress_test:agent:"
+
+// Default lifetime of agent registration without heartbeats.
+const DEFAULT_AGENT_TTL = 10 * time.Second
+
+// Count of keys hinted to Redis per SCAN iteration.
+const SCAN_COUNT = 100
+
+// Statuses of registered agents.
+const (
+	AGENT_READY   = "ready"   // Agent waits for test start.
+	AGENT_STARTED = "started" // Agent runs test.
+)
+
+// Registration of agent with its capabilities.
+type AgentInfo struct {
+	Name       string // Agent name unique across agents.
+	Host       string // Host name of agent.
+	Version    string // Bot version of agent.
+	MaxClients int    // Maximal count of RTMP clients of agent, 0 is unlimited.
+	Status     string // Agent status.
+	Heartbeat  int64  // Time of the latest heartbeat in unix seconds.
+}
+
+// Registry of live agents.
+// Every agent registration is a key with lifetime, so registration of
+// agent without heartbeats expires automatically.
+type AgentRegistry struct {
+	client *redis.Client // Redis client.
+	ttl    time.Duration // Lifetime of registration without heartbeats.
+}
+
+// Returns new registry of agents.
+// Client may be connected to any Redis compatible server.
+//
+// params: client *redis.Client   Redis client.
+//         ttl    time.Duration   Lifetime of registration without heartbeats.
+func NewAgentRegistry(client *redis.Client, ttl time.Duration) *AgentRegistry {
+	return &AgentRegistry{client: client, ttl: ttl}
+}
+
+// Registers agent or refreshes its registration.
+// Heartbeat time of registration is set to the current time.
+//
+// param: info AgentInfo   Registration of agent.
+func (r *AgentRegistry) Register(info AgentInfo) error {
+	info.Heartbeat = time.Now().Unix()
+	data, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(AGENT_PREFIX+info.Name, string(data), r.ttl).Err()
+}
+
+// Removes registration of agent.
+//
+// param: name string   Agent name.
+func (r *AgentRegistry) Unregister(name string) error {
+	return r.client.Del(AGENT_PREFIX + name).Err()
+}
+
+// Returns registrations of live agents ordered by name.
+func (r *AgentRegistry) Agents() ([]AgentInfo, error) {
+	keys, err := r.agentKeys()
+	if err != nil {
+		return nil, err
+	}
+	var agents []AgentInfo
+	for _, key := range keys {
+		data, err := r.client.Get(key).Result()
+		if err == redis.Nil {
+			// Registration expired after listing.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var info AgentInfo
+		if err := json.Unmarshal([]byte(data), &info); err != nil {
+			return nil, err
+		}
+		agents = append(agents, info)
+	}
+	sort.Slice(agents, func(i, j int) bool {
+		return agents[i].Name < agents[j].Name
+	})
+	return agents, nil
+}
+
+// Returns registration keys of agents.
+// Keys are listed by SCAN, so listing does not block Redis like KEYS,
+// the same key may be returned more than once.
+func (r *AgentRegistry) agentKeys() ([]string, error) {
+	seen := make(map[string]bool)
+	var keys []string
+	var cursor uint64
+	for {
+		page, next, err := r.client.Scan(
+			cursor, AGENT_PREFIX+"*", SCAN_COUNT).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range page {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if next == 0 {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	"gopkg.in/redis.v4"
+)
+
+// Lifetime of test agents registrations.
+const TEST_TTL = 10 * time.Second
+
+// Starts in-memory Redis server and returns registry connected to it.
+// Server and client are closed on test cleanup.
+//
+// param: t *testing.T   Test.
+func newTestRegistry(t *testing.T) (*AgentRegistry, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("can not start Redis server: %s", err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return NewAgentRegistry(client, TEST_TTL), server
+}
+
+// Returns names of agents registrations.
+//
+// param: agents []AgentInfo   Agents registrations.
+func agentNames(agents []AgentInfo) []string {
+	var names []string
+	for _, agent := range agents {
+		names = append(names, agent.Name)
+	}
+	return names
+}
+
+// Returns agents of registry failing test on error.
+//
+// params: t        *testing.T       Test.
+//         registry *AgentRegistry   Agents registry.
+func mustAgents(t *testing.T, registry *AgentRegistry) []AgentInfo {
+	t.Helper()
+	agents, err := registry.Agents()
+	if err != nil {
+		t.Fatalf("can not list agents: %s", err)
+	}
+	return agents
+}
+
+// Registered agents are listed by name with their capabilities.
+func TestRegisterAgents(t *testing.T) {
+	registry, server := newTestRegistry(t)
+	for _, name := range []string{"charlie", "alpha", "bravo"} {
+		err := registry.Register(AgentInfo{
+			Name:       name,
+			Host:       name + ".local",
+			MaxClients: 100,
+			Status:     AGENT_READY,
+		})
+		if err != nil {
+			t.Fatalf("can not register %s: %s", name, err)
+		}
+	}
+	server.Set("stress_test:other", "value")
+	agents := mustAgents(t, registry)
+	names := fmt.Sprint(agentNames(agents))
+	if names != "[alpha bravo charlie]" {
+		t.Fatalf("agents are %s, want [alpha bravo charlie]", names)
+	}
+	agent := agents[0]
+	if agent.Host != "alpha.local" || agent.MaxClients != 100 ||
+		agent.Status != AGENT_READY || agent.Heartbeat == 0 {
+		t.Errorf("alpha registration is %+v", agent)
+	}
+	if ttl := server.TTL(AGENT_PREFIX + "alpha"); ttl != TEST_TTL {
+		t.Errorf("registration TTL is %s, want %s", ttl, TEST_TTL)
+	}
+}
+
+// Heartbeats keep registration alive, registration without heartbeats
+// expires.
+func TestAgentHeartbeat(t *testing.T) {
+	registry, server := newTestRegistry(t)
+	for _, name := range []string{"alive", "dead"} {
+		if err := registry.Register(AgentInfo{Name: name}); err != nil {
+			t.Fatalf("can not register %s: %s", name, err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		server.FastForward(TEST_TTL * 2 / 3)
+		err := registry.Register(AgentInfo{Name: "alive", Status: AGENT_STARTED})
+		if err != nil {
+			t.Fatalf("can not refresh registration: %s", err)
+		}
+		if ttl := server.TTL(AGENT_PREFIX + "alive"); ttl != TEST_TTL {
+			t.Errorf("refreshed TTL is %s, want %s", ttl, TEST_TTL)
+		}
+	}
+	agents := mustAgents(t, registry)
+	if len(agents) != 1 || agents[0].Name != "alive" {
+		t.Fatalf("agents are %v, want [alive]", agentNames(agents))
+	}
+	if agents[0].Status != AGENT_STARTED {
+		t.Errorf("refreshed status is %s, want %s",
+			agents[0].Status, AGENT_STARTED)
+	}
+	server.FastForward(TEST_TTL)
+	if agents := mustAgents(t, registry); len(agents) != 0 {
+		t.Errorf("agents without heartbeats are %v", agentNames(agents))
+	}
+}
+
+// Unregistered agent is not listed.
+func TestUnregisterAgent(t *testing.T) {
+	registry, _ := newTestRegistry(t)
+	for _, name := range []string{"first", "second"} {
+		if err := registry.Register(AgentInfo{Name: name}); err != nil {
+			t.Fatalf("can not register %s: %s", name, err)
+		}
+	}
+	if err := registry.Unregister("first"); err != nil {
+		t.Fatalf("can not unregister: %s", err)
+	}
+	if err := registry.Unregister("unknown"); err != nil {
+		t.Errorf("unregister of unknown agent fails: %s", err)
+	}
+	agents := mustAgents(t, registry)
+	if len(agents) != 1 || agents[0].Name != "second" {
+		t.Errorf("agents are %v, want [second]", agentNames(agents))
+	}
+}
+
+// Agents are listed by many SCAN iterations without duplicates.
+func TestAgentsScan(t *testing.T) {
+	registry, server := newTestRegistry(t)
+	count := SCAN_COUNT*2 + 7
+	for i := 0; i < count; i++ {
+		server.Set(fmt.Sprintf("key%d", i), "value")
+		err := registry.Register(AgentInfo{Name: fmt.Sprintf("agent%03d", i)})
+		if err != nil {
+			t.Fatalf("can not register agent %d: %s", i, err)
+		}
+	}
+	agents := mustAgents(t, registry)
+	if len(agents) != count {
+		t.Fatalf("listed %d agents, want %d", len(agents), count)
+	}
+	for i, agent := range agents {
+		if name := fmt.Sprintf("agent%03d", i); agent.Name != name {
+			t.Fatalf("agent %d is %s, want %s", i, agent.Name, name)
+		}
+	}
+}
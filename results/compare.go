@@ -0,0 +1,262 @@
+package results
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+)
+
+// Directions of metric change which are considered as regression.
+const (
+	HIGHER_IS_BETTER = 1  // Metric decrease is regression.
+	LOWER_IS_BETTER  = -1 // Metric increase is regression.
+	NO_DIRECTION     = 0  // Metric is only reported.
+)
+
+// Default comparison thresholds.
+const (
+	DEFAULT_MAX_CHANGE   = 10.0 // Allowed metric worsening in percent.
+	DEFAULT_SIGNIFICANCE = 0.05 // Significance level of change.
+	MIN_SAMPLES          = 3    // Minimal count of samples to test change.
+)
+
+// Directions of report metrics.
+// Durations of connection phases, disconnects and frames integrity
+// are lower is better, counts of clients reached phases are higher is better.
+var metric_directions = map[string]int{
+	"ConnectedModelsCount":      HIGHER_IS_BETTER,
+	"ConnectedClientsCount":     HIGHER_IS_BETTER,
+	"ConnectedModelCountLag":    LOWER_IS_BETTER,
+	"ConnectedClientCountLag":   LOWER_IS_BETTER,
+	"AverageModelFPS":           HIGHER_IS_BETTER,
+	"AverageClientFPS":          HIGHER_IS_BETTER,
+	"AverageAudioBytesSends":    HIGHER_IS_BETTER,
+	"AverageVideoBytesSends":    HIGHER_IS_BETTER,
+	"AverageAudioBytesReceived": HIGHER_IS_BETTER,
+	"AverageVideoBytesReceived": HIGHER_IS_BETTER,
+	"AverageModelStartUpTime":   LOWER_IS_BETTER,
+	"AverageClientStartUpTime":  LOWER_IS_BETTER,
+	"LatencyP50":                LOWER_IS_BETTER,
+	"LatencyP95":                LOWER_IS_BETTER,
+	"LatencyP99":                LOWER_IS_BETTER,
+	"DroppedFrames":             LOWER_IS_BETTER,
+	"Reconnects":                LOWER_IS_BETTER,
+	"TotalDowntime":             LOWER_IS_BETTER,
+	"Stalls":                    LOWER_IS_BETTER,
+	"TotalStallTime":            LOWER_IS_BETTER,
+	"RebufferRatio":             LOWER_IS_BETTER,
+	"AverageSendJitter":         LOWER_IS_BETTER,
+	"SendJitterP95":             LOWER_IS_BETTER,
+	"DeadAgents":                LOWER_IS_BETTER,
+}
+
+// Report fields which describe test instead of measure it.
+var skipped_metrics = map[string]bool{
+	"TestId":                true,
+	"MetricPrefix":          true,
+	"StartTime":             true,
+	"TotalTime":             true,
+	"TotalClients":          true,
+	"RequestedModelsCount":  true,
+	"RequestedClientsCount": true,
+	"TargetModelsCount":     true,
+	"TargetClientsCount":    true,
+	"LiveAgents":            true,
+}
+
+// Thresholds of regression detection.
+type Thresholds struct {
+	MaxChange    float64            // Allowed metric worsening in percent.
+	Significance float64            // Significance level of change.
+	Metrics      map[string]float64 // Allowed worsening of metrics in percent.
+}
+
+// Returns default regression thresholds.
+func DefaultThresholds() *Thresholds {
+	return &Thresholds{
+		MaxChange:    DEFAULT_MAX_CHANGE,
+		Significance: DEFAULT_SIGNIFICANCE,
+		Metrics:      make(map[string]float64),
+	}
+}
+
+// Returns allowed worsening of metric in percent.
+//
+// param: name string   Metric name.
+func (t *Thresholds) maxChange(name string) float64 {
+	if max_change, ok := t.Metrics[name]; ok {
+		return max_change
+	}
+	return t.MaxChange
+}
+
+// Difference of metric between two test runs.
+type MetricDiff struct {
+	Name        string  // Metric name.
+	Direction   int     // Direction of metric improvement.
+	Baseline    float64 // Baseline mean value.
+	Current     float64 // Current mean value.
+	Change      float64 // Relative change of mean value in percent.
+	PValue      float64 // Probability of the change to be random.
+	Significant bool    // Change is statistically significant.
+	Regression  bool    // Change is significant worsening above threshold.
+}
+
+// Comparison of two test runs.
+type Comparison struct {
+	BaselineId string       // Baseline test ID.
+	CurrentId  string       // Current test ID.
+	Metrics    []MetricDiff // Metrics differences.
+}
+
+// Returns regressed metrics.
+func (c *Comparison) Regressions() []MetricDiff {
+	var regressions []MetricDiff
+	for _, metric := range c.Metrics {
+		if metric.Regression {
+			regressions = append(regressions, metric)
+		}
+	}
+	return regressions
+}
+
+// Compares current test run with baseline one.
+// Report metrics are compared by time series of report snapshots,
+// startup and connection phases by clients samples.
+//
+// params: baseline   *model.TestResult   Baseline test result.
+//         current    *model.TestResult   Current test result.
+//         thresholds *Thresholds         Regression thresholds.
+func Compare(baseline *model.TestResult, current *model.TestResult,
+	thresholds *Thresholds) *Comparison {
+	comparison := &Comparison{
+		BaselineId: baseline.TestId,
+		CurrentId:  current.TestId,
+	}
+	baseline_samples := metricSamples(baseline)
+	current_samples := metricSamples(current)
+	var names []string
+	for name := range baseline_samples {
+		if _, ok := current_samples[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		comparison.Metrics = append(comparison.Metrics, compareMetric(name,
+			baseline_samples[name], current_samples[name], thresholds))
+	}
+	return comparison
+}
+
+// Returns difference of metric samples.
+//
+// params: name       string        Metric name.
+//         baseline   []float64     Baseline samples.
+//         current    []float64     Current samples.
+//         thresholds *Thresholds   Regression thresholds.
+func compareMetric(name string, baseline []float64, current []float64,
+	thresholds *Thresholds) MetricDiff {
+	diff := MetricDiff{
+		Name:      name,
+		Direction: metricDirection(name),
+		Baseline:  mean(baseline),
+		Current:   mean(current),
+		PValue:    1,
+	}
+	if diff.Baseline != 0 {
+		diff.Change = (diff.Current - diff.Baseline) / math.Abs(diff.Baseline) * 100
+	} else if diff.Current != 0 {
+		diff.Change = math.Copysign(100, diff.Current)
+	}
+	if len(baseline) >= MIN_SAMPLES && len(current) >= MIN_SAMPLES {
+		diff.PValue = mannWhitneyPValue(baseline, current)
+		diff.Significant = diff.PValue < thresholds.Significance
+	}
+	worsening := -diff.Change * float64(diff.Direction)
+	diff.Regression = diff.Significant &&
+		worsening > thresholds.maxChange(name)
+	return diff
+}
+
+// Returns samples of all comparable metrics of test result.
+//
+// param: result *model.TestResult   Test result.
+func metricSamples(result *model.TestResult) map[string][]float64 {
+	samples := make(map[string][]float64)
+	for _, snapshot := range result.Snapshots {
+		for _, col := range flatten(reflect.ValueOf(snapshot), "") {
+			if skipped_metrics[col.Name] {
+				continue
+			}
+			value, err := strconv.ParseFloat(col.Value, 64)
+			if err != nil {
+				continue
+			}
+			samples[col.Name] = append(samples[col.Name], value)
+		}
+	}
+	for _, client := range result.Clients {
+		role := strings.TrimPrefix(client.Role, "role_")
+		if client.VideoBytes > 0 {
+			name := role + ".startup"
+			samples[name] = append(samples[name], float64(client.VideoStartUpTime))
+		}
+		for phase, duration := range client.Timings {
+			name := role + ".phase." + phase
+			samples[name] = append(samples[name], float64(duration))
+		}
+	}
+	return samples
+}
+
+// Returns direction of metric improvement.
+//
+// param: name string   Metric name.
+func metricDirection(name string) int {
+	if direction, ok := metric_directions[name]; ok {
+		return direction
+	}
+	if strings.HasPrefix(name, "Disconnects.") ||
+		(strings.HasPrefix(name, "Integrity.") && name != "Integrity.Frames") {
+		return LOWER_IS_BETTER
+	}
+	if strings.HasPrefix(name, "SequenceHeaders.") &&
+		(strings.HasSuffix(name, "_late") || strings.HasSuffix(name, "_missing")) {
+		return LOWER_IS_BETTER
+	}
+	// Count of phase summary is count of clients which reached the phase.
+	if strings.Contains(name, "Phases.") && strings.HasSuffix(name, ".Count") {
+		return HIGHER_IS_BETTER
+	}
+	if strings.HasSuffix(name, ".startup") ||
+		strings.Contains(name, ".phase.") || strings.Contains(name, "Phases.") {
+		return LOWER_IS_BETTER
+	}
+	return NO_DIRECTION
+}
+
+// Writes comparison as text table.
+//
+// params: w          io.Writer     Output writer.
+//         comparison *Comparison   Comparison of test runs.
+func WriteComparison(w io.Writer, comparison *Comparison) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "METRIC\tBASELINE\tCURRENT\tCHANGE %%\tP-VALUE\t\n")
+	for _, metric := range comparison.Metrics {
+		mark := ""
+		if metric.Regression {
+			mark = "REGRESSION"
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%+.2f\t%.4f\t%s\n", metric.Name,
+			metric.Baseline, metric.Current, metric.Change, metric.PValue, mark)
+	}
+	return tw.Flush()
+}
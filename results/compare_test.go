@@ -0,0 +1,83 @@
+package results
+
+import (
+	"testing"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+)
+
+// Count of snapshots of test results.
+const TEST_SNAPSHOTS = 20
+
+// Returns test result of snapshots with connection losses and phases.
+// Snapshots are added like by launcher statistic.
+//
+// params: test_id         string   Test ID.
+//         lost            int64    Connection losses per snapshot.
+//         connected_count int64    Players reached connect phase.
+func testResult(test_id string, lost int64, connected_count int64) *model.TestResult {
+	report := model.NewReport("test")
+	report.ResetReport(test_id, 1, 10)
+	result := model.NewTestResult(report)
+	for i := int64(0); i < TEST_SNAPSHOTS; i++ {
+		snapshot := report.Snapshot()
+		snapshot.ConnectedClientsCount = 10
+		snapshot.Disconnects[model.CAUSE_CONNECTION_LOST] = lost * i
+		snapshot.PlayerPhases[model.PHASE_CONNECT] = model.Summary{
+			Count: connected_count + i%2,
+			Mean:  100,
+		}
+		result.AddSnapshot(snapshot, nil)
+	}
+	return result
+}
+
+// Returns compared metric by name.
+//
+// params: t          *testing.T    Test.
+//         comparison *Comparison   Comparison of test runs.
+//         name       string        Metric name.
+func metricOf(t *testing.T, comparison *Comparison, name string) MetricDiff {
+	t.Helper()
+	for _, metric := range comparison.Metrics {
+		if metric.Name == name {
+			return metric
+		}
+	}
+	t.Fatalf("metric %s is not compared", name)
+	return MetricDiff{}
+}
+
+// Growth of disconnects is detected as regression.
+func TestCompareDisconnectsRegression(t *testing.T) {
+	comparison := Compare(testResult("baseline", 0, 10),
+		testResult("current", 3, 10), DefaultThresholds())
+	metric := metricOf(t, comparison, "Disconnects."+model.CAUSE_CONNECTION_LOST)
+	if metric.Direction != LOWER_IS_BETTER {
+		t.Errorf("disconnects direction is %d, want %d",
+			metric.Direction, LOWER_IS_BETTER)
+	}
+	if !metric.Regression {
+		t.Errorf("disconnects growth is not regression: %+v", metric)
+	}
+	if other := metricOf(t, comparison, "Disconnects."+model.CAUSE_STOPPED); other.Regression {
+		t.Errorf("unchanged disconnects are regression: %+v", other)
+	}
+}
+
+// Decrease of clients reached connection phase is detected as regression.
+func TestComparePhaseCountRegression(t *testing.T) {
+	comparison := Compare(testResult("baseline", 0, 10),
+		testResult("current", 0, 5), DefaultThresholds())
+	metric := metricOf(t, comparison, "PlayerPhases."+model.PHASE_CONNECT+".Count")
+	if metric.Direction != HIGHER_IS_BETTER {
+		t.Errorf("phase count direction is %d, want %d",
+			metric.Direction, HIGHER_IS_BETTER)
+	}
+	if !metric.Regression {
+		t.Errorf("phase count decrease is not regression: %+v", metric)
+	}
+	if regressions := comparison.Regressions(); len(regressions) != 1 {
+		t.Errorf("regressions are %+v, want phase count only", regressions)
+	}
+}
@@ -0,0 +1,150 @@
+package results
+
+import (
+	"encoding/csv"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+)
+
+// Named value of CSV row.
+type column struct {
+	Name  string // Column name.
+	Value string // Column value.
+}
+
+// Writes report snapshots time series as CSV, one row per snapshot.
+//
+// params: w      io.Writer           Output writer.
+//         result *model.TestResult   Test result.
+func WriteReportCSV(w io.Writer, result *model.TestResult) error {
+	rows := make([][]column, len(result.Snapshots))
+	for i := range result.Snapshots {
+		rows[i] = flatten(reflect.ValueOf(result.Snapshots[i]), "")
+	}
+	return writeCSV(w, rows)
+}
+
+// Writes the latest clients statistic as CSV, one row per client.
+//
+// params: w      io.Writer           Output writer.
+//         result *model.TestResult   Test result.
+func WriteClientsCSV(w io.Writer, result *model.TestResult) error {
+	var rows [][]column
+	for _, client := range SortedClients(result) {
+		rows = append(rows, flatten(reflect.ValueOf(*client), ""))
+	}
+	return writeCSV(w, rows)
+}
+
+// Returns the latest clients statistic sorted by role, stream and ID.
+//
+// param: result *model.TestResult   Test result.
+func SortedClients(result *model.TestResult) []*model.StatItem {
+	clients := make([]*model.StatItem, 0, len(result.Clients))
+	for _, client := range result.Clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		a, b := clients[i], clients[j]
+		if a.Role != b.Role {
+			return a.Role > b.Role
+		}
+		if a.StreamID != b.StreamID {
+			return a.StreamID < b.StreamID
+		}
+		return a.ClientID < b.ClientID
+	})
+	return clients
+}
+
+// Writes rows as CSV with header.
+// Header is the union of all rows columns, missing values are empty.
+//
+// params: w    io.Writer    Output writer.
+//         rows [][]column   Rows of named values.
+func writeCSV(w io.Writer, rows [][]column) error {
+	var header []string
+	indexes := make(map[string]int)
+	for _, row := range rows {
+		for _, col := range row {
+			if _, ok := indexes[col.Name]; !ok {
+				indexes[col.Name] = len(header)
+				header = append(header, col.Name)
+			}
+		}
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		record := make([]string, len(header))
+		for _, col := range row {
+			record[indexes[col.Name]] = col.Value
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Returns exported string and integer fields of struct as named values.
+// Nested structs and maps with string keys are flattened with dotted names.
+// Fields excluded from JSON are skipped.
+//
+// params: v      reflect.Value   Struct value.
+//         prefix string          Names prefix.
+func flatten(v reflect.Value, prefix string) []column {
+	var columns []column
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" || field.Tag.Get("json") == "-" {
+			continue
+		}
+		columns = append(columns,
+			flattenValue(v.Field(i), prefix+field.Name)...)
+	}
+	return columns
+}
+
+// Returns value as named values.
+//
+// params: v    reflect.Value   Any value.
+//         name string          Value name.
+func flattenValue(v reflect.Value, name string) []column {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return []column{{name, strconv.FormatInt(v.Int(), 10)}}
+	case reflect.Float32, reflect.Float64:
+		return []column{{name, strconv.FormatFloat(v.Float(), 'f', -1, 64)}}
+	case reflect.Bool:
+		return []column{{name, strconv.FormatBool(v.Bool())}}
+	case reflect.String:
+		return []column{{name, v.String()}}
+	case reflect.Struct:
+		return flatten(v, name+".")
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		var keys []string
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+		var columns []column
+		for _, key := range keys {
+			columns = append(columns, flattenValue(
+				v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())),
+				name+"."+key)...)
+		}
+		return columns
+	}
+	return nil
+}
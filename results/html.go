@@ -0,0 +1,221 @@
+package results
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+)
+
+// Size of HTML report charts in pixels.
+const (
+	CHART_WIDTH  = 800
+	CHART_HEIGHT = 200
+)
+
+// Colors of chart series.
+var chart_colors = []string{"#1f77b4", "#d62728", "#2ca02c", "#ff7f0e"}
+
+// Chart of report snapshots fields.
+type chartSpec struct {
+	title  string   // Chart title.
+	fields []string // Report fields names.
+}
+
+// Charts of HTML report.
+var report_charts = []chartSpec{
+	{"Connected RTMP clients",
+		[]string{"ConnectedModelsCount", "ConnectedClientsCount"}},
+	{"Connections lag",
+		[]string{"ConnectedModelCountLag", "ConnectedClientCountLag"}},
+	{"Average FPS", []string{"AverageModelFPS", "AverageClientFPS"}},
+	{"Average video bytes",
+		[]string{"AverageVideoBytesSends", "AverageVideoBytesReceived"}},
+	{"Latency, ms", []string{"LatencyP50", "LatencyP95", "LatencyP99"}},
+}
+
+// Chart data of HTML report.
+type chart struct {
+	Title  string   // Chart title.
+	Max    int64    // Maximal value of all series.
+	Series []series // Chart series.
+}
+
+// Chart series data.
+type series struct {
+	Name   string // Report field name.
+	Color  string // Line color.
+	Points string // SVG polyline points.
+}
+
+// Connection phase row of HTML report.
+type phaseRow struct {
+	Role    string        // RTMP client role.
+	Phase   string        // Connection phase.
+	Summary model.Summary // Phase durations summary in ms.
+}
+
+// Data of HTML report template.
+type htmlData struct {
+	TestId    string            // Test ID.
+	StartTime string            // Test start time.
+	Duration  string            // Test duration.
+	Width     int               // Charts width.
+	Height    int               // Charts height.
+	Summary   []column          // The final report values.
+	Charts    []chart           // Report snapshots charts.
+	Phases    []phaseRow        // Connection phases durations.
+	Sweep     []model.SweepStep // Results of sweep steps.
+	Clients   []*model.StatItem // The latest clients statistic.
+}
+
+// HTML report template.
+var html_template = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Stress test {{.TestId}}</title>
+<style>
+body { font-family: sans-serif; margin: 20px; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: right; }
+th { background: #eee; }
+td.name { text-align: left; }
+svg { border: 1px solid #ccc; background: #fafafa; }
+</style>
+</head>
+<body>
+<h1>Stress test {{.TestId}}</h1>
+<p>Started at {{.StartTime}}, duration {{.Duration}}.</p>
+<h2>Final report</h2>
+<table>
+{{range .Summary}}<tr><td class="name">{{.Name}}</td><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{range .Charts}}<h2>{{.Title}}</h2>
+<p>{{range .Series}}<span style="color: {{.Color}}">&#9632; {{.Name}}</span> {{end}}(max {{.Max}})</p>
+<svg width="{{$.Width}}" height="{{$.Height}}">
+{{range .Series}}<polyline fill="none" stroke="{{.Color}}" stroke-width="2" points="{{.Points}}"/>
+{{end}}</svg>
+{{end}}
+{{if .Phases}}<h2>Connection phases, ms</h2>
+<table>
+<tr><th>Role</th><th>Phase</th><th>Count</th><th>Min</th><th>Mean</th><th>P50</th><th>P95</th><th>P99</th><th>Max</th></tr>
+{{range .Phases}}<tr><td class="name">{{.Role}}</td><td class="name">{{.Phase}}</td><td>{{.Summary.Count}}</td><td>{{.Summary.Min}}</td><td>{{.Summary.Mean}}</td><td>{{.Summary.P50}}</td><td>{{.Summary.P95}}</td><td>{{.Summary.P99}}</td><td>{{.Summary.Max}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Sweep}}<h2>Sweep steps</h2>
+<table>
+<tr><th>Parameter</th><th>Value</th><th>Start, s</th><th>Duration, s</th><th>Models</th><th>Clients</th><th>Model FPS</th><th>Client FPS</th><th>Model bitrate</th><th>Client bitrate</th><th>Latency P95, ms</th><th>Dropped frames</th><th>Reconnects</th><th>Stalls</th><th>Stall time, ms</th></tr>
+{{range .Sweep}}<tr><td class="name">{{.Parameter}}</td><td>{{.Value}}</td><td>{{.StartTime}}</td><td>{{.Duration}}</td><td>{{.ConnectedModels}}</td><td>{{.ConnectedClients}}</td><td>{{.AverageModelFPS}}</td><td>{{.AverageClientFPS}}</td><td>{{.AverageModelBitrate}}</td><td>{{.AverageClientBitrate}}</td><td>{{.LatencyP95}}</td><td>{{.DroppedFrames}}</td><td>{{.Reconnects}}</td><td>{{.Stalls}}</td><td>{{.StallTime}}</td></tr>
+{{end}}</table>
+{{end}}
+<h2>RTMP clients</h2>
+<table>
+<tr><th>Client</th><th>Role</th><th>Stream</th><th>Status</th><th>FPS</th><th>Bitrate</th><th>Video bytes</th><th>Audio bytes</th><th>Startup, ms</th><th>Latency P95, ms</th></tr>
+{{range .Clients}}<tr><td class="name">{{.ClientID}}</td><td class="name">{{.Role}}</td><td class="name">{{.StreamID}}</td><td class="name">{{.Status}}</td><td>{{.FPS}}</td><td>{{.Bitrate}}</td><td>{{.VideoBytes}}</td><td>{{.AudioBytes}}</td><td>{{.VideoStartUpTime}}</td><td>{{.Latency.P95}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// Writes test result as self-contained HTML page with charts.
+//
+// params: w      io.Writer           Output writer.
+//         result *model.TestResult   Test result.
+func WriteHTML(w io.Writer, result *model.TestResult) error {
+	data := &htmlData{
+		TestId:    result.TestId,
+		StartTime: time.Unix(result.StartTime, 0).Format(time.RFC3339),
+		Width:     CHART_WIDTH,
+		Height:    CHART_HEIGHT,
+		Clients:   SortedClients(result),
+		Sweep:     result.SweepSteps,
+	}
+	final := result.Final()
+	if final != nil {
+		data.Duration = (time.Duration(final.TotalTime) * time.Second).String()
+		for _, col := range flatten(reflect.ValueOf(*final), "") {
+			if !strings.Contains(col.Name, ".") {
+				data.Summary = append(data.Summary, col)
+			}
+		}
+		data.Phases = append(
+			phaseRows(model.ROLE_PUBLISHER, final.PublisherPhases,
+				model.PUBLISHER_PHASES),
+			phaseRows(model.ROLE_PLAYER, final.PlayerPhases,
+				model.PLAYER_PHASES)...)
+	}
+	for _, spec := range report_charts {
+		data.Charts = append(data.Charts, newChart(spec, result.Snapshots))
+	}
+	return html_template.Execute(w, data)
+}
+
+// Returns chart of report snapshots.
+//
+// params: spec      chartSpec      Chart specification.
+//         snapshots []model.Report   Report snapshots.
+func newChart(spec chartSpec, snapshots []model.Report) chart {
+	c := chart{Title: spec.title}
+	values := make([][]int64, len(spec.fields))
+	for i, field := range spec.fields {
+		for _, snapshot := range snapshots {
+			value := reflect.ValueOf(snapshot).FieldByName(field).Int()
+			values[i] = append(values[i], value)
+			if value > c.Max {
+				c.Max = value
+			}
+		}
+	}
+	for i, field := range spec.fields {
+		points := make([]string, len(values[i]))
+		for j, value := range values[i] {
+			x := 0
+			if len(values[i]) > 1 {
+				x = j * CHART_WIDTH / (len(values[i]) - 1)
+			}
+			y := CHART_HEIGHT
+			if c.Max > 0 {
+				y = CHART_HEIGHT - int(value*CHART_HEIGHT/c.Max)
+			}
+			points[j] = fmt.Sprintf("%d,%d", x, y)
+		}
+		c.Series = append(c.Series, series{
+			Name:   field,
+			Color:  chart_colors[i%len(chart_colors)],
+			Points: strings.Join(points, " "),
+		})
+	}
+	return c
+}
+
+// Returns connection phases rows with durations in milliseconds.
+//
+// params: role      string                     RTMP client role.
+//         summaries map[string]model.Summary   Phases summaries in ns.
+//         phases    []string                   Phases of role.
+func phaseRows(role string, summaries map[string]model.Summary,
+	phases []string) []phaseRow {
+	var rows []phaseRow
+	for _, phase := range phases {
+		summary, ok := summaries[phase]
+		if !ok || summary.Count == 0 {
+			continue
+		}
+		ms := int64(time.Millisecond)
+		rows = append(rows, phaseRow{role, phase, model.Summary{
+			Count: summary.Count,
+			Min:   summary.Min / ms,
+			Max:   summary.Max / ms,
+			Mean:  summary.Mean / ms,
+			P50:   summary.P50 / ms,
+			P95:   summary.P95 / ms,
+			P99:   summary.P99 / ms,
+		}})
+	}
+	return rows
+}
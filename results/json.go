@@ -0,0 +1,35 @@
+package results
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+)
+
+// Writes test result as indented JSON.
+//
+// params: w      io.Writer           Output writer.
+//         result *model.TestResult   Test result.
+func WriteJSON(w io.Writer, result *model.TestResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// Reads test result saved as JSON file.
+//
+// param: file_name string   Test result JSON file path.
+func LoadJSON(file_name string) (*model.TestResult, error) {
+	file, err := os.Open(file_name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	result := &model.TestResult{}
+	if err = json.NewDecoder(file).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package results
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+)
+
+// Writer of test result in some format.
+type writerFunc func(io.Writer, *model.TestResult) error
+
+// Saves test result into directory as JSON, CSV and HTML files
+// named by test ID.
+// Returns paths of saved files.
+//
+// params: result *model.TestResult   Test result.
+//         dir    string              Reports directory.
+func Save(result *model.TestResult, dir string) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	name := result.TestId
+	if name == "" {
+		name = "report"
+	}
+	files := []struct {
+		suffix string
+		write  writerFunc
+	}{
+		{".json", WriteJSON},
+		{".csv", WriteReportCSV},
+		{"_clients.csv", WriteClientsCSV},
+		{".html", WriteHTML},
+	}
+	var paths []string
+	for _, file := range files {
+		path := filepath.Join(dir, name+file.suffix)
+		if err := saveFile(path, result, file.write); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// Writes test result into file.
+//
+// params: path   string              File path.
+//         result *model.TestResult   Test result.
+//         write  writerFunc          Format writer.
+func saveFile(path string, result *model.TestResult, write writerFunc) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = write(file, result); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
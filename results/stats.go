@@ -0,0 +1,67 @@
+package results
+
+import (
+	"math"
+	"sort"
+)
+
+// Returns average of samples.
+//
+// param: samples []float64   Measured values.
+func mean(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, sample := range samples {
+		sum += sample
+	}
+	return sum / float64(len(samples))
+}
+
+// Returns two-sided p-value of Mann-Whitney U test.
+// Uses normal approximation with ties correction.
+//
+// params: a []float64   The first samples.
+//         b []float64   The second samples.
+func mannWhitneyPValue(a []float64, b []float64) float64 {
+	type sample struct {
+		value float64
+		first bool
+	}
+	samples := make([]sample, 0, len(a)+len(b))
+	for _, value := range a {
+		samples = append(samples, sample{value, true})
+	}
+	for _, value := range b {
+		samples = append(samples, sample{value, false})
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].value < samples[j].value
+	})
+	n1, n2 := float64(len(a)), float64(len(b))
+	n := n1 + n2
+	var rank_sum, ties float64
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].value == samples[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if samples[k].first {
+				rank_sum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+	u := rank_sum - n1*(n1+1)/2
+	variance := n1 * n2 / 12 * ((n + 1) - ties/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := (u - n1*n2/2) / math.Sqrt(variance)
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
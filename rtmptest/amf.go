@@ -0,0 +1,229 @@
+package rtmptest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// AMF0 value markers.
+const (
+	AMF0_NUMBER       = 0x00
+	AMF0_BOOLEAN      = 0x01
+	AMF0_STRING       = 0x02
+	AMF0_OBJECT       = 0x03
+	AMF0_NULL         = 0x05
+	AMF0_UNDEFINED    = 0x06
+	AMF0_ECMA_ARRAY   = 0x08
+	AMF0_OBJECT_END   = 0x09
+	AMF0_STRICT_ARRAY = 0x0a
+	AMF0_DATE         = 0x0b
+	AMF0_LONG_STRING  = 0x0c
+)
+
+// AMF0 object.
+type amfObject map[string]interface{}
+
+// Decodes all AMF0 values of command or data message.
+//
+// param: data []byte   Message payload.
+func amfDecodeAll(data []byte) ([]interface{}, error) {
+	r := bytes.NewReader(data)
+	var values []interface{}
+	for r.Len() > 0 {
+		value, err := amfDecode(r)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// Decodes single AMF0 value.
+// Numbers are decoded as float64, objects and ECMA arrays as amfObject,
+// strict arrays as []interface{}, null and undefined as nil.
+//
+// param: r *bytes.Reader   Encoded values reader.
+func amfDecode(r *bytes.Reader) (interface{}, error) {
+	marker, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch marker {
+	case AMF0_NUMBER:
+		return amfReadNumber(r)
+	case AMF0_BOOLEAN:
+		value, err := r.ReadByte()
+		return value != 0, err
+	case AMF0_STRING:
+		return amfReadString(r, 2)
+	case AMF0_LONG_STRING:
+		return amfReadString(r, 4)
+	case AMF0_OBJECT:
+		return amfReadProperties(r)
+	case AMF0_ECMA_ARRAY:
+		if _, err := r.Seek(4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+		return amfReadProperties(r)
+	case AMF0_STRICT_ARRAY:
+		var count uint32
+		if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+			return nil, err
+		}
+		values := make([]interface{}, 0, count)
+		for i := uint32(0); i < count; i++ {
+			value, err := amfDecode(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case AMF0_DATE:
+		value, err := amfReadNumber(r)
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.Seek(2, io.SeekCurrent)
+		return value, err
+	case AMF0_NULL, AMF0_UNDEFINED:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported AMF0 marker 0x%02x", marker)
+}
+
+// Reads AMF0 number value.
+//
+// param: r *bytes.Reader   Encoded values reader.
+func amfReadNumber(r *bytes.Reader) (float64, error) {
+	var bits uint64
+	if err := binary.Read(r, binary.BigEndian, &bits); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(bits), nil
+}
+
+// Reads AMF0 string value.
+//
+// params: r         *bytes.Reader   Encoded values reader.
+//         size_bytes int            Size of string length field.
+func amfReadString(r *bytes.Reader, size_bytes int) (string, error) {
+	var length uint32
+	if size_bytes == 2 {
+		var short_length uint16
+		if err := binary.Read(r, binary.BigEndian, &short_length); err != nil {
+			return "", err
+		}
+		length = uint32(short_length)
+	} else if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	if int64(length) > int64(r.Len()) {
+		return "", errors.New("AMF0 string is out of data")
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	return string(data), err
+}
+
+// Reads AMF0 object properties up to the object end marker.
+//
+// param: r *bytes.Reader   Encoded values reader.
+func amfReadProperties(r *bytes.Reader) (amfObject, error) {
+	object := make(amfObject)
+	for {
+		key, err := amfReadString(r, 2)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			marker, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			if marker == AMF0_OBJECT_END {
+				return object, nil
+			}
+			if err := r.UnreadByte(); err != nil {
+				return nil, err
+			}
+		}
+		value, err := amfDecode(r)
+		if err != nil {
+			return nil, err
+		}
+		object[key] = value
+	}
+}
+
+// Encodes values as AMF0.
+//
+// param: values ...interface{}   Values to encode.
+func amfEncodeAll(values ...interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for _, value := range values {
+		if err := amfEncode(buf, value); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Encodes single value as AMF0.
+// Object properties are encoded in keys order.
+//
+// params: buf   *bytes.Buffer   Output buffer.
+//         value interface{}     Value to encode.
+func amfEncode(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(AMF0_NULL)
+	case float64:
+		buf.WriteByte(AMF0_NUMBER)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case int:
+		return amfEncode(buf, float64(v))
+	case uint32:
+		return amfEncode(buf, float64(v))
+	case bool:
+		buf.WriteByte(AMF0_BOOLEAN)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case string:
+		if len(v) > math.MaxUint16 {
+			buf.WriteByte(AMF0_LONG_STRING)
+			binary.Write(buf, binary.BigEndian, uint32(len(v)))
+		} else {
+			buf.WriteByte(AMF0_STRING)
+			binary.Write(buf, binary.BigEndian, uint16(len(v)))
+		}
+		buf.WriteString(v)
+	case amfObject:
+		buf.WriteByte(AMF0_OBJECT)
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			binary.Write(buf, binary.BigEndian, uint16(len(key)))
+			buf.WriteString(key)
+			if err := amfEncode(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.Write([]byte{0, 0, AMF0_OBJECT_END})
+	default:
+		return fmt.Errorf("unsupported AMF0 value %T", value)
+	}
+	return nil
+}
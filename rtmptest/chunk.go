@@ -0,0 +1,318 @@
+package rtmptest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// RTMP message types.
+const (
+	MSG_SET_CHUNK_SIZE     = 1
+	MSG_ABORT              = 2
+	MSG_ACK                = 3
+	MSG_USER_CONTROL       = 4
+	MSG_WINDOW_ACK_SIZE    = 5
+	MSG_SET_PEER_BANDWIDTH = 6
+	MSG_AUDIO              = 8
+	MSG_VIDEO              = 9
+	MSG_AMF3_DATA          = 15
+	MSG_AMF3_COMMAND       = 17
+	MSG_AMF0_DATA          = 18
+	MSG_AMF0_COMMAND       = 20
+)
+
+// Chunk stream IDs of sent messages.
+const (
+	CHUNK_STREAM_CONTROL = 2
+	CHUNK_STREAM_COMMAND = 3
+	CHUNK_STREAM_AUDIO   = 4
+	CHUNK_STREAM_DATA    = 5
+	CHUNK_STREAM_VIDEO   = 6
+)
+
+// Chunk sizes.
+const (
+	DEFAULT_CHUNK_SIZE = 128        // Chunk size before Set Chunk Size.
+	SERVER_CHUNK_SIZE  = 4096       // Chunk size of sent messages.
+	MAX_CHUNK_SIZE     = 0x7fffffff // Maximal requested chunk size.
+)
+
+// Timestamp value which means extended timestamp field.
+const EXTENDED_TIMESTAMP = 0xffffff
+
+// RTMP message.
+type message struct {
+	Type      uint8  // Message type.
+	StreamID  uint32 // Message stream ID.
+	Timestamp uint32 // Message timestamp in milliseconds.
+	Data      []byte // Message payload.
+}
+
+// State of received chunk stream.
+type chunkStream struct {
+	timestamp uint32 // Timestamp of the current message.
+	delta     uint32 // Timestamp delta of the last header.
+	length    uint32 // Length of the current message.
+	type_id   uint8  // Type of the current message.
+	stream_id uint32 // Message stream ID of the current message.
+	extended  bool   // The last header has extended timestamp.
+	data      []byte // Received part of the current message.
+}
+
+// Reader of RTMP messages from chunk streams.
+type chunkReader struct {
+	r          *bufio.Reader           // Connection reader.
+	chunk_size uint32                  // Chunk size of peer.
+	streams    map[uint32]*chunkStream // Chunk streams by ID.
+}
+
+// Constructs new chunk reader.
+//
+// param: r *bufio.Reader   Connection reader.
+func newChunkReader(r *bufio.Reader) *chunkReader {
+	return &chunkReader{
+		r:          r,
+		chunk_size: DEFAULT_CHUNK_SIZE,
+		streams:    make(map[uint32]*chunkStream),
+	}
+}
+
+// Reads chunks until the whole message is received.
+func (r *chunkReader) ReadMessage() (*message, error) {
+	for {
+		basic, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		format := basic >> 6
+		csid, err := r.readChunkStreamID(uint32(basic & 0x3f))
+		if err != nil {
+			return nil, err
+		}
+		cs, ok := r.streams[csid]
+		if !ok {
+			if format != 0 {
+				return nil, errors.New("chunk stream starts without full header")
+			}
+			cs = &chunkStream{}
+			r.streams[csid] = cs
+		}
+		if err := r.readHeader(cs, format); err != nil {
+			return nil, err
+		}
+		size := cs.length - uint32(len(cs.data))
+		if size > r.chunk_size {
+			size = r.chunk_size
+		}
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(r.r, chunk); err != nil {
+			return nil, err
+		}
+		cs.data = append(cs.data, chunk...)
+		if uint32(len(cs.data)) < cs.length {
+			continue
+		}
+		msg := &message{
+			Type:      cs.type_id,
+			StreamID:  cs.stream_id,
+			Timestamp: cs.timestamp,
+			Data:      cs.data,
+		}
+		cs.data = nil
+		return msg, nil
+	}
+}
+
+// Aborts partly received message of chunk stream.
+//
+// param: csid uint32   Chunk stream ID.
+func (r *chunkReader) Abort(csid uint32) {
+	if cs, ok := r.streams[csid]; ok {
+		cs.data = nil
+	}
+}
+
+// Reads the rest of chunk basic header.
+//
+// param: csid uint32   Chunk stream ID of the first header byte.
+func (r *chunkReader) readChunkStreamID(csid uint32) (uint32, error) {
+	switch csid {
+	case 0:
+		b, err := r.r.ReadByte()
+		return 64 + uint32(b), err
+	case 1:
+		var b [2]byte
+		_, err := io.ReadFull(r.r, b[:])
+		return 64 + uint32(b[0]) + uint32(b[1])*256, err
+	}
+	return csid, nil
+}
+
+// Reads chunk message header and updates chunk stream state.
+//
+// params: cs     *chunkStream   Chunk stream state.
+//         format byte           Chunk header format.
+func (r *chunkReader) readHeader(cs *chunkStream, format byte) error {
+	header_sizes := [4]int{11, 7, 3, 0}
+	var header [11]byte
+	if _, err := io.ReadFull(r.r, header[:header_sizes[format]]); err != nil {
+		return err
+	}
+	starts := len(cs.data) == 0
+	if format == 3 {
+		if cs.extended {
+			if _, err := r.readExtendedTimestamp(); err != nil {
+				return err
+			}
+		}
+		if starts {
+			cs.timestamp += cs.delta
+		}
+		return nil
+	}
+	if !starts {
+		return errors.New("chunk header interrupts message")
+	}
+	timestamp := readUint24(header[0:3])
+	cs.extended = timestamp == EXTENDED_TIMESTAMP
+	if cs.extended {
+		extended, err := r.readExtendedTimestamp()
+		if err != nil {
+			return err
+		}
+		timestamp = extended
+	}
+	if format <= 1 {
+		cs.length = readUint24(header[3:6])
+		cs.type_id = header[6]
+	}
+	if format == 0 {
+		cs.stream_id = binary.LittleEndian.Uint32(header[7:11])
+		cs.timestamp = timestamp
+		cs.delta = timestamp
+	} else {
+		cs.timestamp += timestamp
+		cs.delta = timestamp
+	}
+	return nil
+}
+
+// Reads extended timestamp field.
+func (r *chunkReader) readExtendedTimestamp() (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// Writer of RTMP messages as chunks.
+// Every message starts with full chunk header.
+type chunkWriter struct {
+	w          *bufio.Writer // Connection writer.
+	chunk_size uint32        // Chunk size of sent messages.
+}
+
+// Constructs new chunk writer.
+//
+// param: w *bufio.Writer   Connection writer.
+func newChunkWriter(w *bufio.Writer) *chunkWriter {
+	return &chunkWriter{
+		w:          w,
+		chunk_size: DEFAULT_CHUNK_SIZE,
+	}
+}
+
+// Writes message as chunks.
+// Sent Set Chunk Size message changes chunk size of next messages.
+//
+// param: msg *message   Message to write.
+func (w *chunkWriter) WriteMessage(msg *message) error {
+	csid := byte(chunkStreamOf(msg.Type))
+	extended := msg.Timestamp >= EXTENDED_TIMESTAMP
+	header := make([]byte, 12, 16)
+	header[0] = csid
+	if extended {
+		putUint24(header[1:4], EXTENDED_TIMESTAMP)
+	} else {
+		putUint24(header[1:4], msg.Timestamp)
+	}
+	putUint24(header[4:7], uint32(len(msg.Data)))
+	header[7] = msg.Type
+	binary.LittleEndian.PutUint32(header[8:12], msg.StreamID)
+	var extended_field [4]byte
+	binary.BigEndian.PutUint32(extended_field[:], msg.Timestamp)
+	if extended {
+		header = append(header, extended_field[:]...)
+	}
+	if _, err := w.w.Write(header); err != nil {
+		return err
+	}
+	data := msg.Data
+	for {
+		size := uint32(len(data))
+		if size > w.chunk_size {
+			size = w.chunk_size
+		}
+		if _, err := w.w.Write(data[:size]); err != nil {
+			return err
+		}
+		data = data[size:]
+		if len(data) == 0 {
+			break
+		}
+		if err := w.w.WriteByte(0xc0 | csid); err != nil {
+			return err
+		}
+		if extended {
+			if _, err := w.w.Write(extended_field[:]); err != nil {
+				return err
+			}
+		}
+	}
+	if msg.Type == MSG_SET_CHUNK_SIZE && len(msg.Data) >= 4 {
+		w.chunk_size = binary.BigEndian.Uint32(msg.Data) & MAX_CHUNK_SIZE
+	}
+	return nil
+}
+
+// Flushes written chunks to connection.
+func (w *chunkWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// Returns chunk stream ID of sent message type.
+//
+// param: type_id uint8   Message type.
+func chunkStreamOf(type_id uint8) uint32 {
+	switch type_id {
+	case MSG_AUDIO:
+		return CHUNK_STREAM_AUDIO
+	case MSG_VIDEO:
+		return CHUNK_STREAM_VIDEO
+	case MSG_AMF0_DATA, MSG_AMF3_DATA:
+		return CHUNK_STREAM_DATA
+	case MSG_AMF0_COMMAND, MSG_AMF3_COMMAND:
+		return CHUNK_STREAM_COMMAND
+	}
+	return CHUNK_STREAM_CONTROL
+}
+
+// Returns big endian 24 bit value.
+//
+// param: b []byte   3 bytes of value.
+func readUint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// Puts big endian 24 bit value.
+//
+// params: b     []byte   3 bytes of value.
+//         value uint32   Value to put.
+func putUint24(b []byte, value uint32) {
+	b[0] = byte(value >> 16)
+	b[1] = byte(value >> 8)
+	b[2] = byte(value)
+}
@@ -0,0 +1,135 @@
+package rtmptest
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"time"
+)
+
+// Handshake constants.
+const (
+	RTMP_VERSION   = 3    // Supported RTMP version.
+	HANDSHAKE_SIZE = 1536 // Size of C1, C2, S1 and S2 packets.
+	DIGEST_SIZE    = 32   // Size of HMAC-SHA256 digest.
+)
+
+// Version of server announced in S1.
+var server_version = []byte{4, 5, 0, 1}
+
+// Common part of handshake digest keys.
+var key_suffix = []byte{
+	0xf0, 0xee, 0xc2, 0x4a, 0x80, 0x68, 0xbe, 0xe8,
+	0x2e, 0x00, 0xd0, 0xd1, 0x02, 0x9e, 0x7e, 0x57,
+	0x6e, 0xec, 0x5d, 0x2d, 0x29, 0x80, 0x6f, 0xab,
+	0x93, 0xb8, 0xe6, 0x36, 0xcf, 0xeb, 0x31, 0xae,
+}
+
+// Handshake digest keys of server and client.
+var (
+	server_key = append([]byte("Genuine Adobe Flash Media Server 001"), key_suffix...)
+	client_key = append([]byte("Genuine Adobe Flash Player 001"), key_suffix...)
+)
+
+// Makes server side of RTMP handshake.
+// Clients which sign C1 with digest get digest signed S1 and S2,
+// other clients get simple handshake with echoed C1.
+//
+// params: r     *bufio.Reader   Connection reader.
+//         w     *bufio.Writer   Connection writer.
+//         delay time.Duration   Delay before server response.
+func serverHandshake(r *bufio.Reader, w *bufio.Writer, delay time.Duration) error {
+	version, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if version != RTMP_VERSION {
+		return errors.New("unsupported RTMP version")
+	}
+	c1 := make([]byte, HANDSHAKE_SIZE)
+	if _, err := io.ReadFull(r, c1); err != nil {
+		return err
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	s1 := make([]byte, HANDSHAKE_SIZE)
+	if _, err := rand.Read(s1[8:]); err != nil {
+		return err
+	}
+	copy(s1[4:8], server_version)
+	offset := digestOffset(s1, 8)
+	copy(s1[offset:], makeDigest(s1, offset, server_key[:36]))
+
+	s2 := make([]byte, HANDSHAKE_SIZE)
+	if c1_offset := findDigest(c1, client_key[:30]); c1_offset >= 0 {
+		if _, err := rand.Read(s2); err != nil {
+			return err
+		}
+		key := hmacSHA256(server_key, c1[c1_offset:c1_offset+DIGEST_SIZE])
+		copy(s2[HANDSHAKE_SIZE-DIGEST_SIZE:],
+			hmacSHA256(key, s2[:HANDSHAKE_SIZE-DIGEST_SIZE]))
+	} else {
+		copy(s2, c1)
+	}
+
+	w.WriteByte(RTMP_VERSION)
+	w.Write(s1)
+	w.Write(s2)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	c2 := make([]byte, HANDSHAKE_SIZE)
+	_, err = io.ReadFull(r, c2)
+	return err
+}
+
+// Returns offset of digest in handshake packet.
+//
+// params: packet []byte   Handshake packet.
+//         base   int      Offset of digest block.
+func digestOffset(packet []byte, base int) int {
+	sum := int(packet[base]) + int(packet[base+1]) +
+		int(packet[base+2]) + int(packet[base+3])
+	return sum%728 + base + 4
+}
+
+// Returns digest of handshake packet without digest itself.
+//
+// params: packet []byte   Handshake packet.
+//         offset int      Digest offset.
+//         key    []byte   Digest key.
+func makeDigest(packet []byte, offset int, key []byte) []byte {
+	message := make([]byte, 0, HANDSHAKE_SIZE-DIGEST_SIZE)
+	message = append(message, packet[:offset]...)
+	message = append(message, packet[offset+DIGEST_SIZE:]...)
+	return hmacSHA256(key, message)
+}
+
+// Returns offset of valid digest in handshake packet or -1.
+//
+// params: packet []byte   Handshake packet.
+//         key    []byte   Digest key.
+func findDigest(packet []byte, key []byte) int {
+	for _, base := range []int{8, 772} {
+		offset := digestOffset(packet, base)
+		if hmac.Equal(packet[offset:offset+DIGEST_SIZE],
+			makeDigest(packet, offset, key)) {
+			return offset
+		}
+	}
+	return -1
+}
+
+// Returns HMAC-SHA256 of message.
+//
+// params: key     []byte   HMAC key.
+//         message []byte   Signed message.
+func hmacSHA256(key []byte, message []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(message)
+	return h.Sum(nil)
+}
@@ -0,0 +1,332 @@
+// Package rtmptest implements in-process RTMP server for self-tests.
+// The server accepts handshake, connect, createStream, publish and play,
+// relays published streams to players and injects faults on request.
+package rtmptest
+
+import (
+	"github.com/instrumentisto/go-rtmp-bot/media"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default server listen address with random free port.
+const DEFAULT_ADDRESS = "127.0.0.1:0"
+
+// RTMP application name of server URL.
+const APP_NAME = "live"
+
+// Faults injected by server.
+type Faults struct {
+	Refuse         bool          // Close connections right after accept.
+	HandshakeDelay time.Duration // Delay before handshake response.
+	DropAfter      time.Duration // Drop published streams after duration, 0 disables.
+}
+
+// Server counters.
+type Stats struct {
+	Connections int64 // Accepted connections.
+	Refused     int64 // Connections refused by fault injection.
+	Published   int64 // Started publishes.
+	Played      int64 // Started plays.
+	Received    int64 // Media messages received from publishers.
+	Relayed     int64 // Media messages sent to players.
+	Dropped     int64 // Media messages dropped for slow players.
+}
+
+// In-process RTMP server.
+// Counters are the first field to be aligned for atomic access.
+type Server struct {
+	stats    Stats                  // Server counters.
+	URL      string                 // RTMP application URL of server.
+	listener net.Listener           // Server listener.
+	mutex    sync.Mutex             // Guards faults, streams and sessions.
+	faults   Faults                 // Injected faults.
+	streams  map[string]*liveStream // Published streams by key.
+	sessions map[*session]bool      // Open client sessions.
+	closed   bool                   // Server is closed.
+	wait     sync.WaitGroup         // Running server goroutines.
+}
+
+// Published stream with its players.
+type liveStream struct {
+	key          string              // RTMP stream key.
+	publisher    *session            // Publisher session.
+	players      map[*session]uint32 // Players sessions with their stream IDs.
+	metadata     *message            // The latest metadata message.
+	video_header *message            // AVC sequence header message.
+	audio_header *message            // AAC sequence header message.
+}
+
+// Starts new RTMP server on address.
+//
+// param: address string   Listen address, DEFAULT_ADDRESS for random port.
+func NewServer(address string) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		URL:      "rtmp://" + listener.Addr().String() + "/" + APP_NAME,
+		listener: listener,
+		streams:  make(map[string]*liveStream),
+		sessions: make(map[*session]bool),
+	}
+	s.wait.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Sets faults injected to new connections and publishes.
+//
+// param: faults Faults   Injected faults.
+func (s *Server) SetFaults(faults Faults) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = faults
+}
+
+// Returns snapshot of server counters.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Connections: atomic.LoadInt64(&s.stats.Connections),
+		Refused:     atomic.LoadInt64(&s.stats.Refused),
+		Published:   atomic.LoadInt64(&s.stats.Published),
+		Played:      atomic.LoadInt64(&s.stats.Played),
+		Received:    atomic.LoadInt64(&s.stats.Received),
+		Relayed:     atomic.LoadInt64(&s.stats.Relayed),
+		Dropped:     atomic.LoadInt64(&s.stats.Dropped),
+	}
+}
+
+// Returns counts of players of published streams by stream key.
+func (s *Server) Streams() map[string]int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	streams := make(map[string]int)
+	for key, stream := range s.streams {
+		streams[key] = len(stream.players)
+	}
+	return streams
+}
+
+// Drops published stream mid-way.
+// Connections of stream publisher and players are closed.
+// Returns false if the stream is not published.
+//
+// param: key string   RTMP stream key.
+func (s *Server) DropStream(key string) bool {
+	s.mutex.Lock()
+	stream, ok := s.streams[key]
+	var sessions []*session
+	if ok {
+		sessions = append(sessions, stream.publisher)
+		for player := range stream.players {
+			sessions = append(sessions, player)
+		}
+	}
+	s.mutex.Unlock()
+	for _, session := range sessions {
+		session.Close()
+	}
+	return ok
+}
+
+// Stops server and closes all connections.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mutex.Lock()
+	s.closed = true
+	var sessions []*session
+	for session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mutex.Unlock()
+	for _, session := range sessions {
+		session.Close()
+	}
+	s.wait.Wait()
+}
+
+// Accepts connections until the listener is closed.
+func (s *Server) serve() {
+	defer s.wait.Done()
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt64(&s.stats.Connections, 1)
+		s.mutex.Lock()
+		faults := s.faults
+		s.mutex.Unlock()
+		if faults.Refuse {
+			atomic.AddInt64(&s.stats.Refused, 1)
+			c.Close()
+			continue
+		}
+		session := newSession(s, c)
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			c.Close()
+			return
+		}
+		s.sessions[session] = true
+		s.mutex.Unlock()
+		s.wait.Add(1)
+		go func() {
+			defer s.wait.Done()
+			session.Run(faults.HandshakeDelay)
+		}()
+	}
+}
+
+// Starts publishing of stream.
+// Returns false if the stream is already published.
+//
+// params: key       string     RTMP stream key.
+//         publisher *session   Publisher session.
+func (s *Server) publish(key string, publisher *session) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.streams[key]; ok {
+		return false
+	}
+	s.streams[key] = &liveStream{
+		key:       key,
+		publisher: publisher,
+		players:   make(map[*session]uint32),
+	}
+	atomic.AddInt64(&s.stats.Published, 1)
+	if s.faults.DropAfter > 0 {
+		time.AfterFunc(s.faults.DropAfter, func() {
+			s.dropPublisher(key, publisher)
+		})
+	}
+	return true
+}
+
+// Drops stream if it is still published by the publisher.
+//
+// params: key       string     RTMP stream key.
+//         publisher *session   Publisher session.
+func (s *Server) dropPublisher(key string, publisher *session) {
+	s.mutex.Lock()
+	stream, ok := s.streams[key]
+	s.mutex.Unlock()
+	if ok && stream.publisher == publisher {
+		s.DropStream(key)
+	}
+}
+
+// Stops publishing of stream and notifies its players.
+//
+// params: key       string     RTMP stream key.
+//         publisher *session   Publisher session.
+func (s *Server) unpublish(key string, publisher *session) {
+	s.mutex.Lock()
+	stream, ok := s.streams[key]
+	if !ok || stream.publisher != publisher {
+		s.mutex.Unlock()
+		return
+	}
+	delete(s.streams, key)
+	players := make(map[*session]uint32, len(stream.players))
+	for player, stream_id := range stream.players {
+		players[player] = stream_id
+	}
+	s.mutex.Unlock()
+	for player, stream_id := range players {
+		player.Unpublished(stream_id)
+	}
+}
+
+// Adds player to stream.
+// Play start messages and cached metadata and sequence headers are queued
+// before any relayed media.
+// Returns false if the stream is not published.
+//
+// params: key       string     RTMP stream key.
+//         player    *session   Player session.
+//         stream_id uint32     Player stream ID.
+func (s *Server) play(key string, player *session, stream_id uint32) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stream, ok := s.streams[key]
+	if !ok {
+		return false
+	}
+	var headers []*message
+	for _, msg := range []*message{
+		stream.metadata, stream.video_header, stream.audio_header} {
+		if msg != nil {
+			headers = append(headers, msg)
+		}
+	}
+	player.startPlay(stream_id, headers)
+	stream.players[player] = stream_id
+	atomic.AddInt64(&s.stats.Played, 1)
+	return true
+}
+
+// Removes player from stream.
+//
+// params: key    string     RTMP stream key.
+//         player *session   Player session.
+func (s *Server) stopPlay(key string, player *session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if stream, ok := s.streams[key]; ok {
+		delete(stream.players, player)
+	}
+}
+
+// Relays publisher message to stream players.
+// Metadata and sequence headers are cached for late players.
+// Messages are queued without blocking, so slow players lose them.
+//
+// params: key       string     RTMP stream key.
+//         publisher *session   Publisher session.
+//         msg       *message   Media or metadata message.
+func (s *Server) relay(key string, publisher *session, msg *message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stream, ok := s.streams[key]
+	if !ok || stream.publisher != publisher {
+		return
+	}
+	switch {
+	case msg.Type == MSG_AMF0_DATA:
+		stream.metadata = msg
+	case msg.Type == MSG_VIDEO && media.IsAVCSequenceHeader(msg.Data):
+		stream.video_header = msg
+	case msg.Type == MSG_AUDIO && media.IsAACSequenceHeader(msg.Data):
+		stream.audio_header = msg
+	}
+	if msg.Type == MSG_AUDIO || msg.Type == MSG_VIDEO {
+		atomic.AddInt64(&s.stats.Received, 1)
+	}
+	for player, stream_id := range stream.players {
+		if player.Send(&message{
+			Type:      msg.Type,
+			StreamID:  stream_id,
+			Timestamp: msg.Timestamp,
+			Data:      msg.Data,
+		}) {
+			atomic.AddInt64(&s.stats.Relayed, 1)
+		} else {
+			atomic.AddInt64(&s.stats.Dropped, 1)
+		}
+	}
+}
+
+// Removes closed session.
+//
+// param: session *session   Closed client session.
+func (s *Server) removeSession(session *session) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.sessions, session)
+}
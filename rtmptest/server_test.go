@@ -0,0 +1,352 @@
+package rtmptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Max time of waiting for server response.
+const TEST_TIMEOUT = 5 * time.Second
+
+// Test AVC sequence header and frames.
+var (
+	test_video_header = []byte{0x17, 0x00, 0, 0, 0, 0x01, 0x64}
+	test_key_frame    = []byte{0x17, 0x01, 0, 0, 0, 0xaa}
+	test_inter_frame  = []byte{0x27, 0x01, 0, 0, 0, 0xbb}
+)
+
+// Minimal RTMP client of test server.
+type testClient struct {
+	t           *testing.T   // Test.
+	conn        net.Conn     // Server connection.
+	reader      *chunkReader // Received messages reader.
+	writer      *chunkWriter // Sent messages writer.
+	transaction float64      // The last command transaction ID.
+}
+
+// Starts test server with faults.
+// Server is closed on test cleanup.
+//
+// params: t      *testing.T   Test.
+//         faults Faults       Injected faults.
+func startServer(t *testing.T, faults Faults) *Server {
+	server, err := NewServer(DEFAULT_ADDRESS)
+	if err != nil {
+		t.Fatalf("can not start server: %s", err)
+	}
+	server.SetFaults(faults)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Connects to server without handshake.
+// Connection is closed on test cleanup.
+//
+// params: t      *testing.T   Test.
+//         server *Server      Test server.
+func dialServer(t *testing.T, server *Server) *testClient {
+	conn, err := net.Dial("tcp", server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("can not dial server: %s", err)
+	}
+	conn.SetDeadline(time.Now().Add(TEST_TIMEOUT))
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{
+		t:      t,
+		conn:   conn,
+		reader: newChunkReader(bufio.NewReader(conn)),
+		writer: newChunkWriter(bufio.NewWriter(conn)),
+	}
+}
+
+// Connects to server, makes simple handshake and sends connect command.
+//
+// params: t      *testing.T   Test.
+//         server *Server      Test server.
+func connectServer(t *testing.T, server *Server) *testClient {
+	c := dialServer(t, server)
+	if err := c.handshake(); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	c.command(0, "connect", amfObject{"app": APP_NAME})
+	c.expectCommand("_result")
+	return c
+}
+
+// Makes simple client handshake without digest.
+func (c *testClient) handshake() error {
+	c0c1 := make([]byte, 1+HANDSHAKE_SIZE)
+	c0c1[0] = RTMP_VERSION
+	if _, err := c.conn.Write(c0c1); err != nil {
+		return err
+	}
+	s0s1s2 := make([]byte, 1+2*HANDSHAKE_SIZE)
+	if _, err := io.ReadFull(c.reader.r, s0s1s2); err != nil {
+		return err
+	}
+	if !bytes.Equal(s0s1s2[1+HANDSHAKE_SIZE:], c0c1[1:]) {
+		c.t.Error("S2 is not echo of C1")
+	}
+	_, err := c.conn.Write(s0s1s2[1 : 1+HANDSHAKE_SIZE])
+	return err
+}
+
+// Sends AMF0 command with the next transaction ID.
+//
+// params: stream_id uint32           Message stream ID.
+//         name      string           Command name.
+//         args      ...interface{}   Command arguments.
+func (c *testClient) command(stream_id uint32, name string, args ...interface{}) {
+	c.transaction++
+	data, err := amfEncodeAll(
+		append([]interface{}{name, c.transaction}, args...)...)
+	if err != nil {
+		c.t.Fatalf("can not encode %s: %s", name, err)
+	}
+	c.send(&message{Type: MSG_AMF0_COMMAND, StreamID: stream_id, Data: data})
+}
+
+// Sends message.
+//
+// param: msg *message   Sent message.
+func (c *testClient) send(msg *message) {
+	if err := c.writer.WriteMessage(msg); err != nil {
+		c.t.Fatalf("can not send message: %s", err)
+	}
+	if err := c.writer.Flush(); err != nil {
+		c.t.Fatalf("can not send message: %s", err)
+	}
+}
+
+// Reads messages until command and returns its values.
+// Protocol control and media messages are skipped.
+//
+// param: name string   Command name.
+func (c *testClient) expectCommand(name string) []interface{} {
+	for {
+		msg, err := c.reader.ReadMessage()
+		if err != nil {
+			c.t.Fatalf("%s is not received: %s", name, err)
+		}
+		if msg.Type == MSG_SET_CHUNK_SIZE {
+			c.reader.chunk_size = binary.BigEndian.Uint32(msg.Data)
+		}
+		if msg.Type != MSG_AMF0_COMMAND {
+			continue
+		}
+		values, err := amfDecodeAll(msg.Data)
+		if err != nil {
+			c.t.Fatalf("can not decode command: %s", err)
+		}
+		if values[0] == name {
+			return values
+		}
+	}
+}
+
+// Reads messages until onStatus command and returns its code.
+func (c *testClient) expectStatus() string {
+	values := c.expectCommand("onStatus")
+	info, _ := argAt(values, 3).(amfObject)
+	code, _ := info["code"].(string)
+	return code
+}
+
+// Reads messages until media message.
+func (c *testClient) expectMedia() *message {
+	for {
+		msg, err := c.reader.ReadMessage()
+		if err != nil {
+			c.t.Fatalf("media is not received: %s", err)
+		}
+		if msg.Type == MSG_AUDIO || msg.Type == MSG_VIDEO {
+			return msg
+		}
+	}
+}
+
+// Creates stream and returns its ID.
+func (c *testClient) createStream() uint32 {
+	c.command(0, "createStream", nil)
+	id, _ := argAt(c.expectCommand("_result"), 3).(float64)
+	if id == 0 {
+		c.t.Fatal("stream is not created")
+	}
+	return uint32(id)
+}
+
+// Creates stream and starts publishing, returns publisher stream ID.
+//
+// param: key string   RTMP stream key.
+func (c *testClient) publish(key string) uint32 {
+	stream_id := c.createStream()
+	c.command(stream_id, "publish", nil, key, "live")
+	if code := c.expectStatus(); code != "NetStream.Publish.Start" {
+		c.t.Fatalf("publish status is %s", code)
+	}
+	return stream_id
+}
+
+// Returns true if the connection is closed by server before deadline.
+func (c *testClient) closed() bool {
+	for {
+		_, err := c.reader.ReadMessage()
+		if err == nil {
+			continue
+		}
+		net_err, ok := err.(net.Error)
+		return !ok || !net_err.Timeout()
+	}
+}
+
+// Published media is relayed to players with cached sequence header.
+func TestPublishAndPlay(t *testing.T) {
+	server := startServer(t, Faults{})
+	publisher := connectServer(t, server)
+	publish_id := publisher.publish("stream")
+	publisher.send(&message{
+		Type: MSG_VIDEO, StreamID: publish_id, Data: test_video_header})
+	publisher.send(&message{
+		Type: MSG_VIDEO, StreamID: publish_id, Timestamp: 40,
+		Data: test_key_frame})
+
+	player := connectServer(t, server)
+	play_id := player.createStream()
+	player.command(play_id, "play", nil, "stream")
+	for _, want := range []string{
+		"NetStream.Play.Reset", "NetStream.Play.Start"} {
+		if code := player.expectStatus(); code != want {
+			t.Fatalf("play status is %s, want %s", code, want)
+		}
+	}
+	if header := player.expectMedia(); !bytes.Equal(header.Data, test_video_header) {
+		t.Errorf("the first played message is %x, want sequence header",
+			header.Data)
+	}
+	publisher.send(&message{
+		Type: MSG_VIDEO, StreamID: publish_id, Timestamp: 80,
+		Data: test_inter_frame})
+	frame := player.expectMedia()
+	if !bytes.Equal(frame.Data, test_inter_frame) ||
+		frame.Timestamp != 80 || frame.StreamID != play_id {
+		t.Errorf("relayed frame is %+v", frame)
+	}
+
+	if streams := server.Streams(); streams["stream"] != 1 {
+		t.Errorf("server streams are %v, want one player", streams)
+	}
+	stats := server.Stats()
+	if stats.Connections != 2 || stats.Published != 1 || stats.Played != 1 ||
+		stats.Received != 3 || stats.Relayed != 1 {
+		t.Errorf("server stats are %+v", stats)
+	}
+}
+
+// Stream may be published once and played only while it is published.
+func TestPublishAndPlayErrors(t *testing.T) {
+	server := startServer(t, Faults{})
+	first := connectServer(t, server)
+	first.publish("stream")
+
+	second := connectServer(t, server)
+	stream_id := second.createStream()
+	second.command(stream_id, "publish", nil, "stream", "live")
+	if code := second.expectStatus(); code != "NetStream.Publish.BadName" {
+		t.Errorf("second publish status is %s", code)
+	}
+
+	player := connectServer(t, server)
+	stream_id = player.createStream()
+	player.command(stream_id, "play", nil, "unknown")
+	if code := player.expectStatus(); code != "NetStream.Play.StreamNotFound" {
+		t.Errorf("play of not published stream status is %s", code)
+	}
+}
+
+// Players are notified when publisher deletes its stream.
+func TestUnpublish(t *testing.T) {
+	server := startServer(t, Faults{})
+	publisher := connectServer(t, server)
+	publish_id := publisher.publish("stream")
+	player := connectServer(t, server)
+	play_id := player.createStream()
+	player.command(play_id, "play", nil, "stream")
+	player.expectStatus()
+	player.expectStatus()
+
+	publisher.command(0, "deleteStream", nil, float64(publish_id))
+	if code := player.expectStatus(); code != "NetStream.Play.UnpublishNotify" {
+		t.Errorf("player status is %s, want unpublish notify", code)
+	}
+	if streams := server.Streams(); len(streams) != 0 {
+		t.Errorf("server streams are %v after unpublish", streams)
+	}
+}
+
+// Refused connections are closed before handshake.
+func TestRefuseFault(t *testing.T) {
+	server := startServer(t, Faults{Refuse: true})
+	c := dialServer(t, server)
+	if err := c.handshake(); err == nil {
+		t.Error("handshake with refusing server succeeded")
+	}
+	if stats := server.Stats(); stats.Refused != 1 || stats.Connections != 1 {
+		t.Errorf("server stats are %+v", stats)
+	}
+	server.SetFaults(Faults{})
+	connectServer(t, server)
+}
+
+// Handshake response is delayed.
+func TestHandshakeDelayFault(t *testing.T) {
+	delay := 300 * time.Millisecond
+	server := startServer(t, Faults{HandshakeDelay: delay})
+	c := dialServer(t, server)
+	started := time.Now()
+	if err := c.handshake(); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if elapsed := time.Since(started); elapsed < delay {
+		t.Errorf("handshake took %s, want at least %s", elapsed, delay)
+	}
+}
+
+// Published streams are dropped after requested time.
+func TestDropAfterFault(t *testing.T) {
+	server := startServer(t, Faults{DropAfter: 200 * time.Millisecond})
+	publisher := connectServer(t, server)
+	publisher.publish("stream")
+	started := time.Now()
+	if !publisher.closed() {
+		t.Fatal("publisher connection is not closed")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("stream is dropped in %s, want 200ms", elapsed)
+	}
+}
+
+// Dropped stream closes connections of publisher and players.
+func TestDropStream(t *testing.T) {
+	server := startServer(t, Faults{})
+	if server.DropStream("stream") {
+		t.Error("not published stream is dropped")
+	}
+	publisher := connectServer(t, server)
+	publisher.publish("stream")
+	player := connectServer(t, server)
+	play_id := player.createStream()
+	player.command(play_id, "play", nil, "stream")
+	player.expectStatus()
+
+	if !server.DropStream("stream") {
+		t.Fatal("published stream is not dropped")
+	}
+	if !publisher.closed() || !player.closed() {
+		t.Error("connections of dropped stream are not closed")
+	}
+}
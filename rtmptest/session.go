@@ -0,0 +1,435 @@
+package rtmptest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Capacity of session send queue.
+// Relayed media messages are dropped if the queue is full.
+const SEND_QUEUE_SIZE = 512
+
+// Window acknowledgement size and peer bandwidth of server.
+const WINDOW_SIZE = 2500000
+
+// User control events.
+const (
+	EVENT_STREAM_BEGIN = 0
+	EVENT_STREAM_EOF   = 1
+)
+
+// RTMP connection of server client.
+type session struct {
+	server         *Server           // Server of session.
+	conn           net.Conn          // Client connection.
+	reader         *chunkReader      // Received messages reader.
+	writer         *chunkWriter      // Sent messages writer.
+	queue          chan *message     // Messages to send.
+	done           chan struct{}     // Closed with session.
+	close_once     sync.Once         // Closes session once.
+	next_stream_id uint32            // The last created stream ID.
+	published      map[uint32]string // Published stream keys by stream ID.
+	played         map[uint32]string // Played stream keys by stream ID.
+}
+
+// Constructs new client session.
+//
+// params: server *Server    Server of session.
+//         c      net.Conn   Accepted client connection.
+func newSession(server *Server, c net.Conn) *session {
+	return &session{
+		server:    server,
+		conn:      c,
+		queue:     make(chan *message, SEND_QUEUE_SIZE),
+		done:      make(chan struct{}),
+		published: make(map[uint32]string),
+		played:    make(map[uint32]string),
+	}
+}
+
+// Makes handshake and handles client messages until the session is closed.
+//
+// param: handshake_delay time.Duration   Delay before handshake response.
+func (s *session) Run(handshake_delay time.Duration) {
+	defer s.cleanup()
+	r := bufio.NewReader(s.conn)
+	w := bufio.NewWriter(s.conn)
+	if err := serverHandshake(r, w, handshake_delay); err != nil {
+		return
+	}
+	s.reader = newChunkReader(r)
+	s.writer = newChunkWriter(w)
+	s.server.wait.Add(1)
+	go s.writeLoop()
+	for {
+		msg, err := s.reader.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := s.handle(msg); err != nil {
+			return
+		}
+	}
+}
+
+// Closes session connection.
+func (s *session) Close() {
+	s.close_once.Do(func() {
+		close(s.done)
+		s.conn.Close()
+	})
+}
+
+// Queues message without blocking.
+// Returns false if the queue is full or the session is closed.
+//
+// param: msg *message   Message to send.
+func (s *session) Send(msg *message) bool {
+	select {
+	case <-s.done:
+		return false
+	default:
+	}
+	select {
+	case s.queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// Notifies player that the played stream is unpublished.
+//
+// param: stream_id uint32   Player stream ID.
+func (s *session) Unpublished(stream_id uint32) {
+	s.Send(userControl(EVENT_STREAM_EOF, stream_id))
+	if msg, err := onStatus(stream_id, "status",
+		"NetStream.Play.UnpublishNotify", "Stream is unpublished."); err == nil {
+		s.Send(msg)
+	}
+}
+
+// Queues play start messages and cached headers of played stream.
+//
+// params: stream_id uint32       Player stream ID.
+//         headers   []*message   Cached metadata and sequence headers.
+func (s *session) startPlay(stream_id uint32, headers []*message) {
+	s.Send(userControl(EVENT_STREAM_BEGIN, stream_id))
+	for _, status := range [][2]string{
+		{"NetStream.Play.Reset", "Playing and resetting stream."},
+		{"NetStream.Play.Start", "Started playing stream."},
+	} {
+		if msg, err := onStatus(stream_id, "status", status[0], status[1]); err == nil {
+			s.Send(msg)
+		}
+	}
+	for _, header := range headers {
+		s.Send(&message{
+			Type:      header.Type,
+			StreamID:  stream_id,
+			Timestamp: header.Timestamp,
+			Data:      header.Data,
+		})
+	}
+}
+
+// Writes queued messages until the session is closed.
+func (s *session) writeLoop() {
+	defer s.server.wait.Done()
+	for {
+		select {
+		case msg := <-s.queue:
+			if err := s.writer.WriteMessage(msg); err != nil {
+				s.Close()
+				return
+			}
+			if len(s.queue) > 0 {
+				continue
+			}
+			if err := s.writer.Flush(); err != nil {
+				s.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Stops session streams and removes session from server.
+func (s *session) cleanup() {
+	for _, key := range s.published {
+		s.server.unpublish(key, s)
+	}
+	for _, key := range s.played {
+		s.server.stopPlay(key, s)
+	}
+	s.server.removeSession(s)
+	s.Close()
+}
+
+// Handles received message.
+//
+// param: msg *message   Received message.
+func (s *session) handle(msg *message) error {
+	switch msg.Type {
+	case MSG_SET_CHUNK_SIZE:
+		if len(msg.Data) < 4 {
+			return errors.New("short Set Chunk Size message")
+		}
+		size := binary.BigEndian.Uint32(msg.Data) & MAX_CHUNK_SIZE
+		if size == 0 {
+			return errors.New("zero chunk size")
+		}
+		s.reader.chunk_size = size
+	case MSG_ABORT:
+		if len(msg.Data) >= 4 {
+			s.reader.Abort(binary.BigEndian.Uint32(msg.Data))
+		}
+	case MSG_AMF0_COMMAND:
+		return s.handleCommand(msg.StreamID, msg.Data)
+	case MSG_AMF3_COMMAND:
+		if len(msg.Data) > 0 {
+			return s.handleCommand(msg.StreamID, msg.Data[1:])
+		}
+	case MSG_AUDIO, MSG_VIDEO:
+		if key, ok := s.published[msg.StreamID]; ok {
+			s.server.relay(key, s, msg)
+		}
+	case MSG_AMF0_DATA:
+		if key, ok := s.published[msg.StreamID]; ok {
+			msg.Data = withoutSetDataFrame(msg.Data)
+			s.server.relay(key, s, msg)
+		}
+	}
+	return nil
+}
+
+// Handles AMF0 command.
+//
+// params: stream_id uint32   Message stream ID.
+//         data      []byte   AMF0 command values.
+func (s *session) handleCommand(stream_id uint32, data []byte) error {
+	values, err := amfDecodeAll(data)
+	if err != nil {
+		return err
+	}
+	if len(values) < 2 {
+		return errors.New("command without transaction ID")
+	}
+	name, _ := values[0].(string)
+	transaction, _ := values[1].(float64)
+	switch name {
+	case "connect":
+		return s.onConnect(transaction)
+	case "createStream":
+		s.next_stream_id++
+		return s.sendCommand(0, "_result", transaction, nil,
+			float64(s.next_stream_id))
+	case "publish":
+		return s.onPublish(stream_id, stringArg(values, 3))
+	case "play":
+		return s.onPlay(stream_id, stringArg(values, 3))
+	case "deleteStream":
+		if id, ok := argAt(values, 3).(float64); ok {
+			s.closeStream(uint32(id))
+		}
+		return nil
+	case "closeStream":
+		s.closeStream(stream_id)
+		return nil
+	}
+	if transaction != 0 {
+		return s.sendCommand(0, "_result", transaction, nil, nil)
+	}
+	return nil
+}
+
+// Accepts connect command.
+//
+// param: transaction float64   Command transaction ID.
+func (s *session) onConnect(transaction float64) error {
+	window := make([]byte, 4)
+	binary.BigEndian.PutUint32(window, WINDOW_SIZE)
+	bandwidth := append(append([]byte{}, window...), 2)
+	chunk_size := make([]byte, 4)
+	binary.BigEndian.PutUint32(chunk_size, SERVER_CHUNK_SIZE)
+	for _, msg := range []*message{
+		{Type: MSG_WINDOW_ACK_SIZE, Data: window},
+		{Type: MSG_SET_PEER_BANDWIDTH, Data: bandwidth},
+		{Type: MSG_SET_CHUNK_SIZE, Data: chunk_size},
+	} {
+		if err := s.enqueue(msg); err != nil {
+			return err
+		}
+	}
+	return s.sendCommand(0, "_result", transaction,
+		amfObject{
+			"fmsVer":       "FMS/3,0,1,123",
+			"capabilities": float64(31),
+		},
+		amfObject{
+			"level":          "status",
+			"code":           "NetConnection.Connect.Success",
+			"description":    "Connection succeeded.",
+			"objectEncoding": float64(0),
+		})
+}
+
+// Starts publishing of stream.
+// Publishing of already published stream is refused.
+//
+// params: stream_id uint32   Message stream ID.
+//         key       string   RTMP stream key.
+func (s *session) onPublish(stream_id uint32, key string) error {
+	if key == "" || !s.server.publish(key, s) {
+		return s.sendStatus(stream_id, "error",
+			"NetStream.Publish.BadName", "Stream is already published.")
+	}
+	s.published[stream_id] = key
+	if err := s.enqueue(userControl(EVENT_STREAM_BEGIN, stream_id)); err != nil {
+		return err
+	}
+	return s.sendStatus(stream_id, "status",
+		"NetStream.Publish.Start", "Start publishing "+key+".")
+}
+
+// Starts playing of published stream.
+//
+// params: stream_id uint32   Message stream ID.
+//         key       string   RTMP stream key.
+func (s *session) onPlay(stream_id uint32, key string) error {
+	if !s.server.play(key, s, stream_id) {
+		return s.sendStatus(stream_id, "error",
+			"NetStream.Play.StreamNotFound", "Stream is not published.")
+	}
+	s.played[stream_id] = key
+	return nil
+}
+
+// Stops publishing or playing of stream.
+//
+// param: stream_id uint32   Message stream ID.
+func (s *session) closeStream(stream_id uint32) {
+	if key, ok := s.published[stream_id]; ok {
+		delete(s.published, stream_id)
+		s.server.unpublish(key, s)
+	}
+	if key, ok := s.played[stream_id]; ok {
+		delete(s.played, stream_id)
+		s.server.stopPlay(key, s)
+	}
+}
+
+// Queues onStatus command.
+//
+// params: stream_id   uint32   Message stream ID.
+//         level       string   Status level.
+//         code        string   Status code.
+//         description string   Status description.
+func (s *session) sendStatus(stream_id uint32, level string, code string,
+	description string) error {
+	msg, err := onStatus(stream_id, level, code, description)
+	if err != nil {
+		return err
+	}
+	return s.enqueue(msg)
+}
+
+// Queues AMF0 command.
+//
+// params: stream_id uint32           Message stream ID.
+//         values    ...interface{}   Command name, transaction ID and arguments.
+func (s *session) sendCommand(stream_id uint32, values ...interface{}) error {
+	data, err := amfEncodeAll(values...)
+	if err != nil {
+		return err
+	}
+	return s.enqueue(&message{
+		Type:     MSG_AMF0_COMMAND,
+		StreamID: stream_id,
+		Data:     data,
+	})
+}
+
+// Queues message waiting for free space in the queue.
+//
+// param: msg *message   Message to send.
+func (s *session) enqueue(msg *message) error {
+	select {
+	case s.queue <- msg:
+		return nil
+	case <-s.done:
+		return errors.New("session is closed")
+	}
+}
+
+// Returns onStatus command message.
+//
+// params: stream_id   uint32   Message stream ID.
+//         level       string   Status level.
+//         code        string   Status code.
+//         description string   Status description.
+func onStatus(stream_id uint32, level string, code string,
+	description string) (*message, error) {
+	data, err := amfEncodeAll("onStatus", float64(0), nil, amfObject{
+		"level":       level,
+		"code":        code,
+		"description": description,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &message{
+		Type:     MSG_AMF0_COMMAND,
+		StreamID: stream_id,
+		Data:     data,
+	}, nil
+}
+
+// Returns user control message of stream event.
+//
+// params: event     uint16   User control event type.
+//         stream_id uint32   Stream ID of event.
+func userControl(event uint16, stream_id uint32) *message {
+	data := make([]byte, 6)
+	binary.BigEndian.PutUint16(data, event)
+	binary.BigEndian.PutUint32(data[2:], stream_id)
+	return &message{Type: MSG_USER_CONTROL, Data: data}
+}
+
+// Returns metadata without @setDataFrame command of publisher.
+//
+// param: data []byte   AMF0 data message payload.
+func withoutSetDataFrame(data []byte) []byte {
+	r := bytes.NewReader(data)
+	if value, err := amfDecode(r); err == nil && value == "@setDataFrame" {
+		return data[len(data)-r.Len():]
+	}
+	return data
+}
+
+// Returns command argument or nil if it is absent.
+//
+// params: values []interface{}   Command values.
+//         index  int             Argument index.
+func argAt(values []interface{}, index int) interface{} {
+	if index < len(values) {
+		return values[index]
+	}
+	return nil
+}
+
+// Returns string command argument or empty string.
+//
+// params: values []interface{}   Command values.
+//         index  int             Argument index.
+func stringArg(values []interface{}, index int) string {
+	value, _ := argAt(values, index).(string)
+	return value
+}
@@ -0,0 +1,87 @@
+package rtmp_bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/instrumentisto/go-rtmp-bot/model"
+	"github.com/instrumentisto/go-rtmp-bot/rtmptest"
+)
+
+// Scenario phases scale publishers and players relayed by test server.
+func TestScenarioPhases(t *testing.T) {
+	scenario := &model.Scenario{
+		Name: "e2e",
+		Phases: []*model.Phase{
+			{Name: "warm-up", Duration: 4, ModelCount: 1, ClientCount: 2},
+			{Name: "load", ModelCount: 2, ClientCount: 1},
+		},
+	}
+	if err := scenario.Validate(); err != nil {
+		t.Fatalf("invalid scenario: %s", err)
+	}
+	data := &model.StartRequest{Scenario: scenario}
+	data.ModelCount, data.ClientCount = scenario.MaxCounts()
+	server := startTestServer(t, rtmptest.Faults{})
+	launcher := startTestLauncher(t, server, data)
+
+	warm_up := waitReport(t, launcher.TestReport, func(r *model.Report) bool {
+		return r.ConnectedModelsCount == 1 && r.ConnectedClientsCount == 2 &&
+			r.AverageClientFPS > 0 && r.AverageVideoBytesReceived > 0
+	})
+	if warm_up.TargetModelsCount != 1 || warm_up.TargetClientsCount != 2 {
+		t.Errorf("warm-up targets are %d/%d, want 1/2",
+			warm_up.TargetModelsCount, warm_up.TargetClientsCount)
+	}
+	if warm_up.AverageModelFPS <= 0 || warm_up.AverageVideoBytesSends <= 0 {
+		t.Errorf("publisher sends nothing: fps %d, video %d KB",
+			warm_up.AverageModelFPS, warm_up.AverageVideoBytesSends)
+	}
+
+	load := waitReport(t, launcher.TestReport, func(r *model.Report) bool {
+		return r.TargetModelsCount == 2 && r.ConnectedModelsCount == 2 &&
+			r.ConnectedClientsCount == 2
+	})
+	if load.TargetClientsCount != 2 {
+		t.Errorf("load target clients count is %d, want 2",
+			load.TargetClientsCount)
+	}
+	loaded := waitReport(t, launcher.TestReport, func(r *model.Report) bool {
+		return r.AverageVideoBytesReceived > load.AverageVideoBytesReceived
+	})
+	if loaded.AverageModelFPS <= 0 || loaded.AverageClientFPS <= 0 {
+		t.Errorf("load FPS of publishers and players are %d/%d",
+			loaded.AverageModelFPS, loaded.AverageClientFPS)
+	}
+
+	streams := server.Streams()
+	if len(streams) != 2 || streams["model1"] != 1 || streams["model2"] != 1 {
+		t.Errorf("server streams are %v, want one player of each model",
+			streams)
+	}
+	stats := server.Stats()
+	if stats.Received == 0 || stats.Relayed == 0 {
+		t.Errorf("server relays nothing: %+v", stats)
+	}
+}
+
+// Clients refused by server are not connected.
+func TestRefusedConnections(t *testing.T) {
+	server := startTestServer(t, rtmptest.Faults{Refuse: true})
+	launcher := startTestLauncher(t, server, &model.StartRequest{
+		ModelCount:  1,
+		ClientCount: 1,
+	})
+	waitReport(t, launcher.TestReport, func(r *model.Report) bool {
+		return server.Stats().Refused > 0 && r.TargetModelsCount == 1
+	})
+	time.Sleep(1500 * time.Millisecond)
+	report := launcher.TestReport.Snapshot()
+	if report.ConnectedModelsCount != 0 || report.ConnectedClientsCount != 0 {
+		t.Errorf("refused clients are connected: %d/%d",
+			report.ConnectedModelsCount, report.ConnectedClientsCount)
+	}
+	if stats := server.Stats(); stats.Published != 0 || stats.Played != 0 {
+		t.Errorf("refused clients publish or play: %+v", stats)
+	}
+}
@@ -0,0 +1,174 @@
+// Package gortmp implements RTMP transport with
+// github.com/zhangpeihao/gortmp library.
+package gortmp
+
+import (
+	"log"
+	"net"
+
+	"github.com/instrumentisto/go-rtmp-bot/transport"
+	rtmp "github.com/zhangpeihao/gortmp"
+)
+
+// Transport name.
+const NAME = "gortmp"
+
+// Max count of RTMP channels.
+const MAX_CHANNEL_NUMBER = 100
+
+// Type of published streams.
+const PUBLISH_TYPE = "live"
+
+func init() {
+	transport.Register(NAME, &Transport{})
+}
+
+// RTMP transport based on gortmp.
+type Transport struct{}
+
+// Makes RTMP handshake over established network connection.
+//
+// params: c          net.Conn            Established network connection.
+//         server_url string              RTMP server application URL.
+//         handler    transport.Handler   Connection events handler.
+func (t *Transport) NewConn(c net.Conn, server_url string,
+	handler transport.Handler) (transport.Conn, error) {
+	ob_conn, err := rtmp.NewOutbounConn(
+		c, server_url, &connHandler{handler}, MAX_CHANNEL_NUMBER)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{ob_conn}, nil
+}
+
+// Outbound gortmp connection.
+type conn struct {
+	ob_conn rtmp.OutboundConn // gortmp connection.
+}
+
+// Sends connect command.
+// gortmp creates stream after successful connect itself.
+func (c *conn) Connect() error {
+	return c.ob_conn.Connect()
+}
+
+// Returns connection status.
+// gortmp statuses have the same values as transport statuses.
+func (c *conn) Status() uint {
+	status, err := c.ob_conn.Status()
+	if err != nil {
+		return transport.STATUS_CLOSE
+	}
+	return status
+}
+
+// Closes connection.
+func (c *conn) Close() {
+	c.ob_conn.Close()
+}
+
+// Stream of gortmp connection.
+type stream struct {
+	ob_stream rtmp.OutboundStream // gortmp stream.
+}
+
+// Starts live publishing.
+//
+// param: stream_key string   RTMP stream key.
+func (s *stream) Publish(stream_key string) error {
+	return s.ob_stream.Publish(stream_key, PUBLISH_TYPE)
+}
+
+// Starts playing.
+//
+// param: stream_key string   RTMP stream key.
+func (s *stream) Play(stream_key string) error {
+	return s.ob_stream.Play(stream_key, nil, nil, nil)
+}
+
+// Publishes media message.
+//
+// params: message_type    uint8    Message type.
+//         data            []byte   Message payload.
+//         delta_timestamp uint32   Timestamp delta in milliseconds.
+func (s *stream) PublishData(
+	message_type uint8, data []byte, delta_timestamp uint32) error {
+	return s.ob_stream.PublishData(message_type, data, delta_timestamp)
+}
+
+// Closes stream.
+func (s *stream) Close() {
+	s.ob_stream.Close()
+}
+
+// Adapter of transport handler to gortmp connection and stream handlers.
+type connHandler struct {
+	handler transport.Handler // Bot connection events handler.
+}
+
+// Handles changing status of gortmp connection.
+//
+// param: ob_conn rtmp.OutboundConn   gortmp connection.
+func (h *connHandler) OnStatus(ob_conn rtmp.OutboundConn) {
+	status, err := ob_conn.Status()
+	if err != nil {
+		log.Panicf("can not read status: %s", err.Error())
+	}
+	h.handler.OnStatus(status)
+}
+
+// Handles close of gortmp connection.
+//
+// param: c rtmp.Conn   gortmp connection.
+func (h *connHandler) OnClosed(c rtmp.Conn) {
+	h.handler.OnClosed()
+}
+
+// Handles received message.
+// Only audio and video messages are passed to transport handler.
+//
+// params: c       rtmp.Conn       gortmp connection.
+//         message *rtmp.Message   Received message.
+func (h *connHandler) OnReceived(c rtmp.Conn, message *rtmp.Message) {
+	if message.Type != rtmp.AUDIO_TYPE && message.Type != rtmp.VIDEO_TYPE {
+		return
+	}
+	h.handler.OnReceived(&transport.Message{
+		Type:      message.Type,
+		Timestamp: message.Timestamp,
+		Data:      message.Buf.Bytes(),
+	})
+}
+
+// Handles received command.
+// Commands are processed by gortmp itself.
+//
+// params: c       rtmp.Conn       gortmp connection.
+//         command *rtmp.Command   Received command.
+func (h *connHandler) OnReceivedRtmpCommand(c rtmp.Conn, command *rtmp.Command) {
+}
+
+// Handles stream creation.
+// Attaches handler to the stream to get publish and play events.
+//
+// params: ob_conn   rtmp.OutboundConn     gortmp connection.
+//         ob_stream rtmp.OutboundStream   Created gortmp stream.
+func (h *connHandler) OnStreamCreated(
+	ob_conn rtmp.OutboundConn, ob_stream rtmp.OutboundStream) {
+	ob_stream.Attach(h)
+	h.handler.OnStreamCreated(&stream{ob_stream})
+}
+
+// Handles play start.
+//
+// param: ob_stream rtmp.OutboundStream   gortmp stream.
+func (h *connHandler) OnPlayStart(ob_stream rtmp.OutboundStream) {
+	h.handler.OnPlayStart(&stream{ob_stream})
+}
+
+// Handles publish start.
+//
+// param: ob_stream rtmp.OutboundStream   gortmp stream.
+func (h *connHandler) OnPublishStart(ob_stream rtmp.OutboundStream) {
+	h.handler.OnPublishStart(&stream{ob_stream})
+}
@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Name of transport used if no transport is requested.
+const DEFAULT_TRANSPORT = "gortmp"
+
+// Registered transports by name.
+var (
+	transports_mutex sync.RWMutex
+	transports       = make(map[string]Transport)
+)
+
+// Registers transport implementation.
+// Usually called from init function of implementation package.
+//
+// params: name      string      Transport name.
+//         transport Transport   Transport implementation.
+func Register(name string, transport Transport) {
+	transports_mutex.Lock()
+	defer transports_mutex.Unlock()
+	transports[name] = transport
+}
+
+// Returns registered transport.
+// Returns default transport if name is empty.
+//
+// param: name string   Transport name.
+func Get(name string) (Transport, error) {
+	if name == "" {
+		name = DEFAULT_TRANSPORT
+	}
+	transports_mutex.RLock()
+	defer transports_mutex.RUnlock()
+	transport, ok := transports[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown RTMP transport %q", name)
+	}
+	return transport, nil
+}
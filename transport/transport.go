@@ -0,0 +1,67 @@
+package transport
+
+import "net"
+
+// RTMP connection statuses.
+// Values are indexes of model.STATUS_DESCRIPTIONS.
+const (
+	STATUS_CLOSE            uint = 0 // Connection closed.
+	STATUS_HANDSHAKE_OK     uint = 1 // RTMP handshake done.
+	STATUS_CONNECT          uint = 2 // RTMP connect command sent.
+	STATUS_CONNECT_OK       uint = 3 // RTMP connect command succeeded.
+	STATUS_CREATE_STREAM    uint = 4 // RTMP createStream command sent.
+	STATUS_CREATE_STREAM_OK uint = 5 // RTMP stream created.
+)
+
+// Types of RTMP media messages.
+// Values are the same as FLV tag types.
+const (
+	AUDIO_MESSAGE uint8 = 8 // Audio message.
+	VIDEO_MESSAGE uint8 = 9 // Video message.
+)
+
+// RTMP media message.
+type Message struct {
+	Type      uint8  // Message type.
+	Timestamp uint32 // Message timestamp in milliseconds.
+	Data      []byte // Message payload (FLV tag data).
+}
+
+// Handler of RTMP connection events.
+// Implemented by bot and called by transport.
+type Handler interface {
+	OnStatus(status uint)          // Connection status is changed.
+	OnStreamCreated(stream Stream) // Stream is created after connect.
+	OnPublishStart(stream Stream)  // Stream publishing is started.
+	OnPlayStart(stream Stream)     // Stream playing is started.
+	OnReceived(message *Message)   // Media message is received.
+	OnClosed()                     // Connection is closed.
+}
+
+// Outbound RTMP connection.
+type Conn interface {
+	// Sends connect command.
+	// Stream is created and handler is notified after successful connect.
+	Connect() error
+	Status() uint // Returns connection status.
+	Close()       // Closes connection.
+}
+
+// RTMP stream of outbound connection.
+type Stream interface {
+	Publish(stream_key string) error // Starts live publishing.
+	Play(stream_key string) error    // Starts playing.
+	// Publishes media message of type with timestamp delta in milliseconds.
+	PublishData(message_type uint8, data []byte, delta_timestamp uint32) error
+	Close() // Closes stream.
+}
+
+// RTMP client implementation.
+type Transport interface {
+	// Makes RTMP handshake over established network connection.
+	//
+	// params: c          net.Conn   Established TCP or TLS connection.
+	//         server_url string     RTMP server application URL.
+	//         handler    Handler    Connection events handler.
+	NewConn(c net.Conn, server_url string, handler Handler) (Conn, error)
+}
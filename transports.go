@@ -0,0 +1,6 @@
+package rtmp_bot
+
+// Registers built-in RTMP transports.
+import (
+	_ "github.com/instrumentisto/go-rtmp-bot/transport/gortmp"
+)